@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const activityBlockFrames = 4096 // Analysis frames decoded per block
+
+// ActivityConfig controls the energy / spectral-flux activity detector that
+// decides which regions of a recording are turned into chunks. Durations are
+// in seconds and thresholds in dB unless noted otherwise.
+type ActivityConfig struct {
+	Enabled            bool    `json:"enabled"`
+	FrameDuration      float64 `json:"frame_duration"`
+	HopDuration        float64 `json:"hop_duration"`
+	EnergyThresholdDB  float64 `json:"energy_threshold_db"` // Above the estimated noise floor
+	MinEnergyDB        float64 `json:"min_energy_db"`       // Absolute floor (dBFS) below which frames are silent
+	ActiveEnergyDB     float64 `json:"active_energy_db"`    // Absolute level (dBFS) above which frames are always active
+	FluxThreshold      float64 `json:"flux_threshold"`      // In robust standard deviations above the median flux
+	MinSegmentDuration float64 `json:"min_segment_duration"`
+	MergeGap           float64 `json:"merge_gap"`
+	Padding            float64 `json:"padding"`
+}
+
+func defaultActivityConfig() ActivityConfig {
+	return ActivityConfig{
+		Enabled:            true,
+		FrameDuration:      0.025,
+		HopDuration:        0.010,
+		EnergyThresholdDB:  12,
+		MinEnergyDB:        -70,
+		ActiveEnergyDB:     -30,
+		FluxThreshold:      4,
+		MinSegmentDuration: 0.1,
+		MergeGap:           0.3,
+		Padding:            0.1,
+	}
+}
+
+func (cfg ActivityConfig) validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.FrameDuration <= 0 || cfg.HopDuration <= 0 {
+		return fmt.Errorf("frame and hop duration must be positive")
+	}
+	if cfg.MinSegmentDuration < 0 || cfg.MergeGap < 0 || cfg.Padding < 0 {
+		return fmt.Errorf("segment durations cannot be negative")
+	}
+	return nil
+}
+
+// ActivitySegment is one detected region of activity in a source file.
+type ActivitySegment struct {
+	StartTime  float64 `json:"start_time"`
+	EndTime    float64 `json:"end_time"`
+	StartFrame int     `json:"start_frame"`
+	EndFrame   int     `json:"end_frame"`
+	PeakDB     float64 `json:"peak_db"`
+	MeanDB     float64 `json:"mean_db"`
+}
+
+// ActivityReport is the per-source result of activity detection.
+type ActivityReport struct {
+	SourceFile        string            `json:"source_file"`
+	SampleRate        int               `json:"sample_rate"`
+	Duration          float64           `json:"duration"`
+	ActiveDuration    float64           `json:"active_duration"`
+	NoiseFloorDB      float64           `json:"noise_floor_db"`
+	EnergyThresholdDB float64           `json:"energy_threshold_db"`
+	FluxThreshold     float64           `json:"flux_threshold"`
+	Config            ActivityConfig    `json:"config"`
+	Segments          []ActivitySegment `json:"segments"`
+}
+
+// frameRanges returns the detected segments as sample frame ranges.
+func (report *ActivityReport) frameRanges() []frameRange {
+	ranges := make([]frameRange, len(report.Segments))
+	for i, segment := range report.Segments {
+		ranges[i] = frameRange{Start: segment.StartFrame, End: segment.EndFrame}
+	}
+	return ranges
+}
+
+// detectActivity scans the whole recording in blocks, computing the RMS
+// energy and spectral flux of short frames, and returns the regions where
+// either rises clearly above the recording's own background level.
+func detectActivity(r *wavReader, cfg ActivityConfig) (*ActivityReport, error) {
+	info := r.Info()
+	frameLen := int(cfg.FrameDuration * float64(info.SampleRate))
+	hop := int(cfg.HopDuration * float64(info.SampleRate))
+	if frameLen <= 0 || hop <= 0 {
+		return nil, fmt.Errorf("frame duration too short for sample rate %d", info.SampleRate)
+	}
+
+	fftSize := 1
+	for fftSize < frameLen {
+		fftSize *= 2
+	}
+	stftConfig := STFTConfig{WindowSize: frameLen, HopSize: hop, FFTSize: fftSize, WindowFunction: "hann"}
+
+	numFrames := 1
+	if info.NumFrames > frameLen {
+		numFrames = 1 + (info.NumFrames-frameLen+hop-1)/hop
+	}
+	energies := make([]float64, 0, numFrames)
+	fluxes := make([]float64, 0, numFrames)
+	var previous []float64
+
+	for first := 0; first < numFrames; first += activityBlockFrames {
+		count := activityBlockFrames
+		if first+count > numFrames {
+			count = numFrames - first
+		}
+		start := first * hop
+		samples, err := r.ReadRange(start, start+(count-1)*hop+frameLen)
+		if err != nil {
+			return nil, err
+		}
+
+		spectra, err := computeSTFT(samples, stftConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		for f := 0; f < count && f < len(spectra); f++ {
+			var sum float64
+			n := 0
+			for i := f * hop; i < f*hop+frameLen && i < len(samples); i++ {
+				sum += samples[i] * samples[i]
+				n++
+			}
+			energy := -120.0
+			if n > 0 && sum > 0 {
+				energy = math.Max(energy, 10*math.Log10(sum/float64(n)))
+			}
+			energies = append(energies, energy)
+
+			var flux float64
+			for bin, m := range spectra[f] {
+				if previous != nil {
+					if diff := m - previous[bin]; diff > 0 {
+						flux += diff
+					}
+				}
+			}
+			fluxes = append(fluxes, flux/float64(len(spectra[f])))
+			previous = spectra[f]
+		}
+	}
+
+	report := &ActivityReport{
+		SampleRate: info.SampleRate,
+		Duration:   info.Duration(),
+		Config:     cfg,
+		Segments:   []ActivitySegment{},
+	}
+	report.NoiseFloorDB = percentile(energies, 0.1)
+	report.EnergyThresholdDB = math.Max(report.NoiseFloorDB+cfg.EnergyThresholdDB, cfg.MinEnergyDB)
+	fluxMedian := percentile(fluxes, 0.5)
+	report.FluxThreshold = fluxMedian + cfg.FluxThreshold*1.4826*medianAbsoluteDeviation(fluxes, fluxMedian)
+
+	active := make([]bool, len(energies))
+	for f, energy := range energies {
+		loud := energy > report.EnergyThresholdDB || energy > cfg.ActiveEnergyDB
+		onset := fluxes[f] > report.FluxThreshold && energy > cfg.MinEnergyDB
+		active[f] = loud || onset
+	}
+
+	ranges := activeFrameRanges(active, hop, frameLen, info.NumFrames)
+	ranges = mergeFrameRanges(ranges, int(cfg.MergeGap*float64(info.SampleRate)))
+	padding := int(cfg.Padding * float64(info.SampleRate))
+	minLength := int(cfg.MinSegmentDuration * float64(info.SampleRate))
+
+	var padded []frameRange
+	for _, fr := range ranges {
+		if fr.End-fr.Start < minLength {
+			continue
+		}
+		padded = append(padded, frameRange{
+			Start: max(0, fr.Start-padding),
+			End:   min(info.NumFrames, fr.End+padding),
+		})
+	}
+	padded = mergeFrameRanges(padded, 0)
+
+	for _, fr := range padded {
+		segment := ActivitySegment{
+			StartTime:  float64(fr.Start) / float64(info.SampleRate),
+			EndTime:    float64(fr.End) / float64(info.SampleRate),
+			StartFrame: fr.Start,
+			EndFrame:   fr.End,
+			PeakDB:     -120,
+		}
+		var sum float64
+		n := 0
+		for f := fr.Start / hop; f < len(energies) && f*hop < fr.End; f++ {
+			segment.PeakDB = math.Max(segment.PeakDB, energies[f])
+			sum += energies[f]
+			n++
+		}
+		if n > 0 {
+			segment.MeanDB = sum / float64(n)
+		}
+		report.ActiveDuration += segment.EndTime - segment.StartTime
+		report.Segments = append(report.Segments, segment)
+	}
+
+	return report, nil
+}
+
+// activeFrameRanges converts runs of active analysis frames into sample ranges.
+func activeFrameRanges(active []bool, hop, frameLen, numFrames int) []frameRange {
+	var ranges []frameRange
+	for f := 0; f < len(active); f++ {
+		if !active[f] {
+			continue
+		}
+		start := f
+		for f+1 < len(active) && active[f+1] {
+			f++
+		}
+		ranges = append(ranges, frameRange{
+			Start: start * hop,
+			End:   min(numFrames, f*hop+frameLen),
+		})
+	}
+	return ranges
+}
+
+// mergeFrameRanges joins sorted ranges separated by no more than gap frames.
+func mergeFrameRanges(ranges []frameRange, gap int) []frameRange {
+	var merged []frameRange
+	for _, fr := range ranges {
+		if n := len(merged); n > 0 && fr.Start-merged[n-1].End <= gap {
+			if fr.End > merged[n-1].End {
+				merged[n-1].End = fr.End
+			}
+			continue
+		}
+		merged = append(merged, fr)
+	}
+	return merged
+}
+
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func medianAbsoluteDeviation(values []float64, median float64) float64 {
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+	return percentile(deviations, 0.5)
+}
+
+func saveActivityReport(activityDir string, report *ActivityReport) error {
+	if err := os.MkdirAll(activityDir, os.ModePerm); err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(report.SourceFile, filepath.Ext(report.SourceFile)) + ".json"
+	return os.WriteFile(filepath.Join(activityDir, name), jsonData, os.ModePerm)
+}
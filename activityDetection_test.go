@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestDetectActivityFindsBurst(t *testing.T) {
+	// Two seconds of faint noise with a loud tone from 0.8 s to 1.2 s
+	const sampleRate = 8000
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]float64, 2*sampleRate)
+	for i := range samples {
+		samples[i] = 0.001 * rng.NormFloat64()
+		if i >= 6400 && i < 9600 {
+			samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/sampleRate)
+		}
+	}
+	r, err := openWAV(writeTestFile(t, "burst.wav", encodeWAV(samples, sampleRate)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	cfg := defaultActivityConfig()
+	report, err := detectActivity(r, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Segments) != 1 {
+		t.Fatalf("%d segments, want 1: %+v", len(report.Segments), report.Segments)
+	}
+	segment := report.Segments[0]
+	// The padding widens the segment by 0.1 s, plus up to one analysis frame
+	if math.Abs(segment.StartTime-0.7) > 0.05 || math.Abs(segment.EndTime-1.3) > 0.05 {
+		t.Errorf("segment %.3f-%.3f s, want about 0.7-1.3 s", segment.StartTime, segment.EndTime)
+	}
+	if segment.PeakDB < -10 || report.NoiseFloorDB > -50 {
+		t.Errorf("peak %.1f dB and noise floor %.1f dB", segment.PeakDB, report.NoiseFloorDB)
+	}
+}
+
+func TestActiveFrameRanges(t *testing.T) {
+	active := []bool{false, true, true, false, false, true, false}
+	got := activeFrameRanges(active, 10, 25, 70)
+	want := []frameRange{{10, 45}, {50, 70}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestMergeFrameRanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ranges []frameRange
+		gap    int
+		want   []frameRange
+	}{
+		{"within the gap", []frameRange{{0, 10}, {15, 20}}, 5, []frameRange{{0, 20}}},
+		{"beyond the gap", []frameRange{{0, 10}, {16, 20}}, 5, []frameRange{{0, 10}, {16, 20}}},
+		{"overlapping", []frameRange{{0, 10}, {5, 8}, {9, 12}}, 0, []frameRange{{0, 12}}},
+		{"none", nil, 5, nil},
+	}
+	for _, tt := range tests {
+		if got := mergeFrameRanges(tt.ranges, tt.gap); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{0.5, 3},
+		{1, 5},
+	}
+	for _, tt := range tests {
+		if got := percentile(values, tt.p); got != tt.want {
+			t.Errorf("percentile %g: got %g, want %g", tt.p, got, tt.want)
+		}
+	}
+	if got := medianAbsoluteDeviation(values, 3); got != 1 {
+		t.Errorf("median absolute deviation %g, want 1", got)
+	}
+}
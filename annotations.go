@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const annotationsFile = "annotations.json"
+
+// Origins of an annotation.
+const (
+	annotationItem    = "item"    // One spectrogram, labelled by hand
+	annotationCluster = "cluster" // One spectrogram, labelled along with its whole cluster
+	annotationRange   = "range"   // A time range of a source file
+)
+
+// Export and import formats of annotations.
+const (
+	annotationFormatJSON = "json"
+	annotationFormatCSV  = "csv"
+)
+
+// Errors of annotation calls.
+var (
+	errInvalidAnnotation  = errors.New("invalid annotation")
+	errAnnotationNotFound = errors.New("annotation not found")
+)
+
+// annotationCSVHeader lists the CSV columns of exported annotations.
+var annotationCSVHeader = []string{"id", "label", "origin", "md5_hash", "source_file", "start_time", "end_time", "cluster", "annotator", "created_at", "updated_at"}
+
+// Annotation assigns a label of the project's taxonomy to a sound: either to
+// one spectrogram, identified by its MD5 hash, or to a time range of a source
+// file. Spectrogram annotations also record the time range of the chunk, so
+// they survive as time ranges when spectrograms are regenerated.
+type Annotation struct {
+	ID         string    `json:"id"`
+	Label      string    `json:"label"` // Label path, e.g. "bird/song/alarm"
+	Origin     string    `json:"origin"`
+	MD5Hash    string    `json:"md5_hash,omitempty"`
+	SourceFile string    `json:"source_file"` // WAV file name in the sounds directory
+	StartTime  float64   `json:"start_time"`  // In seconds
+	EndTime    float64   `json:"end_time"`
+	Cluster    *int      `json:"cluster,omitempty"` // Cluster labelled, for cluster annotations
+	Annotator  string    `json:"annotator"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ListAnnotations returns all annotations of a project.
+func (a *App) ListAnnotations(projectName string) ([]Annotation, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	a.labelsMu.Lock()
+	defer a.labelsMu.Unlock()
+	annotations, err := loadAnnotations(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+	return annotations, nil
+}
+
+// AnnotateSpectrogram labels one spectrogram. It replaces any earlier label of
+// the spectrogram, including one from labelling its cluster, and is kept when
+// the cluster is labelled again.
+func (a *App) AnnotateSpectrogram(projectName string, md5Hash string, label string, annotator string) (*Annotation, error) {
+	if _, err := hex.DecodeString(md5Hash); err != nil || len(md5Hash) != 32 {
+		return nil, fmt.Errorf("%w: invalid MD5 hash %q", errInvalidAnnotation, md5Hash)
+	}
+
+	var annotation Annotation
+	err := a.updateAnnotations(projectName, func(projectDir string, annotations []Annotation) ([]Annotation, error) {
+		spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", md5Hash+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown spectrogram %q", errInvalidAnnotation, md5Hash)
+		}
+		annotation = newAnnotation(label, annotationItem, annotator)
+		annotation.setSpectrogram(spectrogram)
+		return upsertSpectrogramAnnotation(annotations, annotation), nil
+	}, label)
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+// AnnotateTimeRange labels a time range of a WAV file in the sounds
+// directory. Ranges may overlap; each is its own annotation.
+func (a *App) AnnotateTimeRange(projectName string, sourceFile string, startTime float64, endTime float64, label string, annotator string) (*Annotation, error) {
+	if sourceFile == "" || filepath.Base(sourceFile) != sourceFile {
+		return nil, fmt.Errorf("%w: source file must be a file name in the sounds directory", errInvalidAnnotation)
+	}
+	if startTime < 0 || endTime <= startTime {
+		return nil, fmt.Errorf("%w: time range %g-%g", errInvalidAnnotation, startTime, endTime)
+	}
+
+	var annotation Annotation
+	err := a.updateAnnotations(projectName, func(projectDir string, annotations []Annotation) ([]Annotation, error) {
+		if !knownSourceFile(projectDir, sourceFile) {
+			return nil, fmt.Errorf("%w: unknown source file %q", errInvalidAnnotation, sourceFile)
+		}
+		annotation = newAnnotation(label, annotationRange, annotator)
+		annotation.SourceFile = sourceFile
+		annotation.StartTime, annotation.EndTime = startTime, endTime
+		return append(annotations, annotation), nil
+	}, label)
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+// AnnotateCluster labels every spectrogram of a cluster of the last
+// clustering run. Spectrograms labelled one by one keep their own label. It
+// returns the number of spectrograms labelled.
+func (a *App) AnnotateCluster(projectName string, cluster int, label string, annotator string) (int, error) {
+	if cluster == noiseCluster {
+		return 0, fmt.Errorf("%w: noise is not a cluster", errInvalidAnnotation)
+	}
+
+	labelled := 0
+	err := a.updateAnnotations(projectName, func(projectDir string, annotations []Annotation) ([]Annotation, error) {
+		assignments, err := loadClusterAssignments(projectDir)
+		if err != nil {
+			return nil, err
+		}
+		if cluster < 0 || cluster >= len(assignments.ClusterSizes) {
+			return nil, fmt.Errorf("%w: no cluster %d in the last clustering run", errInvalidAnnotation, cluster)
+		}
+
+		for _, assignment := range assignments.Assignments {
+			if assignment.Cluster != cluster {
+				continue
+			}
+			if i := findSpectrogramAnnotation(annotations, assignment.MD5Hash); i >= 0 && annotations[i].Origin == annotationItem {
+				continue
+			}
+			spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", assignment.MD5Hash+".json"))
+			if err != nil {
+				return nil, fmt.Errorf("error loading spectrogram %s: %v", assignment.MD5Hash, err)
+			}
+			annotation := newAnnotation(label, annotationCluster, annotator)
+			annotation.setSpectrogram(spectrogram)
+			annotation.Cluster = &cluster
+			annotations = upsertSpectrogramAnnotation(annotations, annotation)
+			labelled++
+		}
+		return annotations, nil
+	}, label)
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Labelled %d spectrograms of cluster %d as %s\n", labelled, cluster, label)
+	return labelled, nil
+}
+
+// DeleteAnnotation removes one annotation.
+func (a *App) DeleteAnnotation(projectName string, annotationID string) error {
+	return a.updateAnnotations(projectName, func(projectDir string, annotations []Annotation) ([]Annotation, error) {
+		for i, annotation := range annotations {
+			if annotation.ID == annotationID {
+				return append(annotations[:i], annotations[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", errAnnotationNotFound, annotationID)
+	})
+}
+
+// GetSpectrogramLabels resolves the label of every annotated spectrogram:
+// its own annotation if it has one, otherwise the time range annotation of
+// its source file that covers most of it, provided it covers at least half.
+func (a *App) GetSpectrogramLabels(projectName string) (map[string]string, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	a.labelsMu.Lock()
+	annotations, err := loadAnnotations(projectDir)
+	a.labelsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return spectrogramLabels(projectDir, annotations)
+}
+
+// ExportAnnotations returns all annotations of a project as JSON or CSV.
+func (a *App) ExportAnnotations(projectName string, format string) (string, error) {
+	annotations, err := a.ListAnnotations(projectName)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case annotationFormatJSON:
+		jsonData, err := json.MarshalIndent(annotations, "", "  ")
+		return string(jsonData), err
+	case annotationFormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(annotationCSVHeader)
+		for _, annotation := range annotations {
+			w.Write(annotation.csvRecord())
+		}
+		w.Flush()
+		return buf.String(), w.Error()
+	}
+	return "", fmt.Errorf("unknown annotation format: %s", format)
+}
+
+// ImportAnnotations adds exported annotations to a project, adding their
+// labels to the label tree when missing. Annotations with a known ID replace
+// the existing one, and spectrogram annotations replace earlier annotations of
+// the same spectrogram. It returns the number of annotations imported.
+func (a *App) ImportAnnotations(projectName string, data string, format string) (int, error) {
+	var imported []Annotation
+	switch format {
+	case annotationFormatJSON:
+		if err := json.Unmarshal([]byte(data), &imported); err != nil {
+			return 0, fmt.Errorf("%w: %v", errInvalidAnnotation, err)
+		}
+	case annotationFormatCSV:
+		var err error
+		imported, err = parseAnnotationsCSV(strings.NewReader(data))
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", errInvalidAnnotation, err)
+		}
+	default:
+		return 0, fmt.Errorf("unknown annotation format: %s", format)
+	}
+
+	now := time.Now()
+	for i := range imported {
+		annotation := &imported[i]
+		if err := annotation.validate(); err != nil {
+			return 0, err
+		}
+		if annotation.ID == "" {
+			annotation.ID = newID()
+		}
+		if annotation.CreatedAt.IsZero() {
+			annotation.CreatedAt = now
+		}
+		if annotation.UpdatedAt.IsZero() {
+			annotation.UpdatedAt = annotation.CreatedAt
+		}
+	}
+
+	err := a.updateLabels(projectName, func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		annotations, err := loadAnnotations(projectDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, annotation := range imported {
+			parts, _ := splitLabel(annotation.Label)
+			labels.add(parts)
+			if i := findAnnotation(annotations, annotation.ID); i >= 0 {
+				annotations[i] = annotation
+			} else if annotation.MD5Hash != "" {
+				annotations = upsertSpectrogramAnnotation(annotations, annotation)
+			} else {
+				annotations = append(annotations, annotation)
+			}
+		}
+		return labels, annotations, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("Imported %d annotations\n", len(imported))
+	return len(imported), nil
+}
+
+// updateAnnotations applies fn to the annotations of a project and saves the
+// ones it returns. The labels given must exist in the project's label tree.
+func (a *App) updateAnnotations(projectName string, fn func(projectDir string, annotations []Annotation) ([]Annotation, error), labels ...string) error {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return err
+	}
+
+	a.labelsMu.Lock()
+	defer a.labelsMu.Unlock()
+
+	if len(labels) > 0 {
+		taxonomy, err := loadLabels(projectDir)
+		if err != nil {
+			return err
+		}
+		for _, label := range labels {
+			if _, err := splitLabel(label); err != nil {
+				return err
+			}
+			if !taxonomy.has(label) {
+				return fmt.Errorf("%w: %s", errLabelNotFound, label)
+			}
+		}
+	}
+
+	annotations, err := loadAnnotations(projectDir)
+	if err != nil {
+		return err
+	}
+	annotations, err = fn(projectDir, annotations)
+	if err != nil {
+		return err
+	}
+	return saveAnnotations(projectDir, annotations)
+}
+
+func newAnnotation(label, origin, annotator string) Annotation {
+	if annotator == "" {
+		annotator = defaultAnnotator()
+	}
+	now := time.Now()
+	return Annotation{
+		ID:        newID(),
+		Label:     label,
+		Origin:    origin,
+		Annotator: annotator,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// defaultAnnotator names the local user for annotations made without an
+// annotator.
+func defaultAnnotator() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
+
+func (annotation *Annotation) setSpectrogram(spectrogram *SpectrogramData) {
+	annotation.MD5Hash = spectrogram.MD5Hash
+	annotation.SourceFile = spectrogram.SourceFile
+	annotation.StartTime = spectrogram.StartTime
+	annotation.EndTime = spectrogram.EndTime
+}
+
+func (annotation Annotation) validate() error {
+	if _, err := splitLabel(annotation.Label); err != nil {
+		return err
+	}
+	switch annotation.Origin {
+	case annotationItem, annotationCluster:
+		if annotation.MD5Hash == "" {
+			return fmt.Errorf("%w: %s annotation %s without an MD5 hash", errInvalidAnnotation, annotation.Origin, annotation.ID)
+		}
+	case annotationRange:
+		if annotation.SourceFile == "" || annotation.EndTime <= annotation.StartTime {
+			return fmt.Errorf("%w: range annotation %s without a source file and time range", errInvalidAnnotation, annotation.ID)
+		}
+	default:
+		return fmt.Errorf("%w: unknown origin %q", errInvalidAnnotation, annotation.Origin)
+	}
+	return nil
+}
+
+func (annotation Annotation) csvRecord() []string {
+	cluster := ""
+	if annotation.Cluster != nil {
+		cluster = strconv.Itoa(*annotation.Cluster)
+	}
+	return []string{
+		annotation.ID,
+		annotation.Label,
+		annotation.Origin,
+		annotation.MD5Hash,
+		annotation.SourceFile,
+		strconv.FormatFloat(annotation.StartTime, 'f', -1, 64),
+		strconv.FormatFloat(annotation.EndTime, 'f', -1, 64),
+		cluster,
+		annotation.Annotator,
+		annotation.CreatedAt.Format(time.RFC3339Nano),
+		annotation.UpdatedAt.Format(time.RFC3339Nano),
+	}
+}
+
+// parseAnnotationsCSV reads annotations written by ExportAnnotations. Columns
+// are matched by header name, so they may come in any order and all but
+// label and origin may be left out.
+func parseAnnotationsCSV(r io.Reader) ([]Annotation, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"label", "origin"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing CSV column: %s", required)
+		}
+	}
+
+	annotations := make([]Annotation, 0, len(records)-1)
+	for line, record := range records[1:] {
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		annotation := Annotation{
+			ID:         field("id"),
+			Label:      field("label"),
+			Origin:     field("origin"),
+			MD5Hash:    field("md5_hash"),
+			SourceFile: field("source_file"),
+			Annotator:  field("annotator"),
+		}
+		for name, value := range map[string]*float64{"start_time": &annotation.StartTime, "end_time": &annotation.EndTime} {
+			if field(name) == "" {
+				continue
+			}
+			if *value, err = strconv.ParseFloat(field(name), 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %v", line+2, name, err)
+			}
+		}
+		if field("cluster") != "" {
+			cluster, err := strconv.Atoi(field("cluster"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid cluster: %v", line+2, err)
+			}
+			annotation.Cluster = &cluster
+		}
+		for name, value := range map[string]*time.Time{"created_at": &annotation.CreatedAt, "updated_at": &annotation.UpdatedAt} {
+			if field(name) == "" {
+				continue
+			}
+			if *value, err = time.Parse(time.RFC3339Nano, field(name)); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %v", line+2, name, err)
+			}
+		}
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+// upsertSpectrogramAnnotation stores the annotation of a spectrogram in place
+// of an earlier one, keeping its creation time.
+func upsertSpectrogramAnnotation(annotations []Annotation, annotation Annotation) []Annotation {
+	i := findSpectrogramAnnotation(annotations, annotation.MD5Hash)
+	if i < 0 {
+		return append(annotations, annotation)
+	}
+	annotation.CreatedAt = annotations[i].CreatedAt
+	annotations[i] = annotation
+	return annotations
+}
+
+func findSpectrogramAnnotation(annotations []Annotation, md5Hash string) int {
+	for i, annotation := range annotations {
+		if annotation.MD5Hash == md5Hash {
+			return i
+		}
+	}
+	return -1
+}
+
+func findAnnotation(annotations []Annotation, id string) int {
+	for i, annotation := range annotations {
+		if annotation.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// knownSourceFile reports whether a WAV file is or was in the sounds
+// directory; under the delete retention policy only the manifest remembers it.
+func knownSourceFile(projectDir, sourceFile string) bool {
+	if _, err := os.Stat(filepath.Join(projectDir, "sounds", sourceFile)); err == nil {
+		return true
+	}
+	manifest, err := openManifest(projectDir)
+	if err != nil {
+		return false
+	}
+	_, ok := manifest.lookup("sounds/" + sourceFile)
+	return ok
+}
+
+// spectrogramLabels resolves the label of every spectrogram that has its own
+// annotation or lies mostly within an annotated time range.
+func spectrogramLabels(projectDir string, annotations []Annotation) (map[string]string, error) {
+	labels := map[string]string{}
+	ranges := map[string][]Annotation{}
+	for _, annotation := range annotations {
+		if annotation.MD5Hash != "" {
+			labels[annotation.MD5Hash] = annotation.Label
+		} else {
+			ranges[annotation.SourceFile] = append(ranges[annotation.SourceFile], annotation)
+		}
+	}
+	if len(ranges) == 0 {
+		return labels, nil
+	}
+
+	files, err := listSpectrogramFiles(filepath.Join(projectDir, "spectrograms"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading spectrograms directory: %v", err)
+	}
+	md5Hashes := make([]string, 0, len(files))
+	for _, file := range files {
+		md5Hashes = append(md5Hashes, strings.TrimSuffix(filepath.Base(file), ".json"))
+	}
+
+	for _, md5Hash := range md5Hashes {
+		if _, ok := labels[md5Hash]; ok {
+			continue
+		}
+		spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", md5Hash+".json"))
+		if err != nil {
+			return nil, fmt.Errorf("error loading spectrogram %s: %v", md5Hash, err)
+		}
+		length := spectrogram.EndTime - spectrogram.StartTime
+		best := length / 2
+		for _, annotation := range ranges[spectrogram.SourceFile] {
+			overlap := min(annotation.EndTime, spectrogram.EndTime) - max(annotation.StartTime, spectrogram.StartTime)
+			if overlap >= best && overlap > 0 {
+				labels[md5Hash], best = annotation.Label, overlap
+			}
+		}
+	}
+	return labels, nil
+}
+
+// loadAnnotations returns the annotations of a project, none if the project
+// has not been annotated yet.
+func loadAnnotations(projectDir string) ([]Annotation, error) {
+	fileData, err := os.ReadFile(filepath.Join(projectDir, annotationsFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading annotations: %v", err)
+	}
+	var annotations []Annotation
+	if err := json.Unmarshal(fileData, &annotations); err != nil {
+		return nil, fmt.Errorf("error unmarshalling annotations: %v", err)
+	}
+	return annotations, nil
+}
+
+func saveAnnotations(projectDir string, annotations []Annotation) error {
+	jsonData, err := json.MarshalIndent(annotations, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(projectDir, annotationsFile), jsonData); err != nil {
+		return fmt.Errorf("error saving annotations: %v", err)
+	}
+	return nil
+}
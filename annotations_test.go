@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestChunk saves the spectrogram of a chunk of a source file in the
+// sounds directory of the project, creating the source file if needed.
+func writeTestChunk(t *testing.T, projectDir, md5Hash, sourceFile string, startTime, endTime float64) {
+	t.Helper()
+	soundsDir := filepath.Join(projectDir, "sounds")
+	if err := os.MkdirAll(soundsDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(soundsDir, sourceFile), encodeWAV(make([]float64, 100), 8000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+	if err := os.MkdirAll(spectrogramsDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	data := SpectrogramData{FileName: md5Hash + ".wav", MD5Hash: md5Hash, SourceFile: sourceFile, StartTime: startTime, EndTime: endTime, SampleRate: 8000, Spectrogram: [][]float64{{0}}}
+	if err := saveJSON(filepath.Join(spectrogramsDir, md5Hash+".json"), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpectrogramLabels(t *testing.T) {
+	app, projectDir := newTestProject(t, "birds")
+	for _, label := range []string{"bird/song", "bird/call", "frog"} {
+		if err := app.AddLabel("birds", label); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hash := func(i int) string { return strings.Repeat("0", 31) + string(rune('a'+i)) }
+	chunks := []struct {
+		sourceFile string
+		start, end float64
+	}{
+		{"a.wav", 0, 2},  // Labelled by hand
+		{"a.wav", 2, 4},  // Within the first range
+		{"a.wav", 4, 6},  // Mostly within the first range
+		{"a.wav", 6, 8},  // Mostly within the second range
+		{"a.wav", 9, 11}, // A quarter within the second range
+		{"b.wav", 0, 2},  // Other file
+	}
+	for i, chunk := range chunks {
+		writeTestChunk(t, projectDir, hash(i), chunk.sourceFile, chunk.start, chunk.end)
+	}
+	// Feature files in the spectrograms directory are not spectrograms
+	if err := os.WriteFile(featuresFilePath(filepath.Join(projectDir, "spectrograms"), hash(0)), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := app.AnnotateTimeRange("birds", "a.wav", 0, 5.5, "bird/song", "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.AnnotateTimeRange("birds", "a.wav", 5.5, 9.5, "bird/call", "tester"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.AnnotateSpectrogram("birds", hash(0), "frog", "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := app.GetSpectrogramLabels("birds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		hash(0): "frog",
+		hash(1): "bird/song",
+		hash(2): "bird/song",
+		hash(3): "bird/call",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("labels %v, want %v", labels, want)
+	}
+}
+
+func TestAnnotationValidation(t *testing.T) {
+	app, projectDir := newTestProject(t, "birds")
+	if err := app.AddLabel("birds", "bird"); err != nil {
+		t.Fatal(err)
+	}
+	md5Hash := strings.Repeat("ab", 16)
+	writeTestChunk(t, projectDir, md5Hash, "a.wav", 0, 2)
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{"unknown label", func() error { _, err := app.AnnotateSpectrogram("birds", md5Hash, "frog", ""); return err }, errLabelNotFound},
+		{"invalid hash", func() error { _, err := app.AnnotateSpectrogram("birds", "../labels", "bird", ""); return err }, errInvalidAnnotation},
+		{"unknown spectrogram", func() error {
+			_, err := app.AnnotateSpectrogram("birds", strings.Repeat("cd", 16), "bird", "")
+			return err
+		}, errInvalidAnnotation},
+		{"path as source file", func() error { _, err := app.AnnotateTimeRange("birds", "../a.wav", 0, 1, "bird", ""); return err }, errInvalidAnnotation},
+		{"unknown source file", func() error { _, err := app.AnnotateTimeRange("birds", "b.wav", 0, 1, "bird", ""); return err }, errInvalidAnnotation},
+		{"empty range", func() error { _, err := app.AnnotateTimeRange("birds", "a.wav", 1, 1, "bird", ""); return err }, errInvalidAnnotation},
+		{"no clustering run", func() error { _, err := app.AnnotateCluster("birds", 0, "bird", ""); return err }, nil},
+		{"noise cluster", func() error { _, err := app.AnnotateCluster("birds", noiseCluster, "bird", ""); return err }, errInvalidAnnotation},
+		{"missing annotation", func() error { return app.DeleteAnnotation("birds", "missing") }, errAnnotationNotFound},
+	}
+	for _, tt := range tests {
+		err := tt.call()
+		if tt.wantErr == nil {
+			if err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLabelsInUse(t *testing.T) {
+	app, projectDir := newTestProject(t, "birds")
+	if err := app.AddLabel("birds", "bird/song"); err != nil {
+		t.Fatal(err)
+	}
+	md5Hash := strings.Repeat("ab", 16)
+	writeTestChunk(t, projectDir, md5Hash, "a.wav", 0, 2)
+	if _, err := app.AnnotateSpectrogram("birds", md5Hash, "bird/song", "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.DeleteLabel("birds", "bird"); !errors.Is(err, errLabelInUse) {
+		t.Errorf("deleting a used label: got error %v, want errLabelInUse", err)
+	}
+	if err := app.SaveLabels("birds", LabelTaxonomy{"frog": nil}); !errors.Is(err, errLabelInUse) {
+		t.Errorf("dropping a used label: got error %v, want errLabelInUse", err)
+	}
+	relabeled, err := app.RenameLabel("birds", "bird", "birds")
+	if err != nil || relabeled != 1 {
+		t.Fatalf("RenameLabel returned %d, %v; want 1 annotation changed", relabeled, err)
+	}
+	annotations, err := app.ListAnnotations("birds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annotations) != 1 || annotations[0].Label != "birds/song" {
+		t.Errorf("annotations %+v, want one labelled birds/song", annotations)
+	}
+}
+
+func TestExportImportAnnotations(t *testing.T) {
+	for _, format := range []string{annotationFormatJSON, annotationFormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			app, projectDir := newTestProject(t, "birds")
+			if err := app.AddLabel("birds", "bird/song/alarm"); err != nil {
+				t.Fatal(err)
+			}
+			md5Hash := strings.Repeat("ab", 16)
+			writeTestChunk(t, projectDir, md5Hash, "a.wav", 1, 3)
+			if _, err := app.AnnotateSpectrogram("birds", md5Hash, "bird/song/alarm", "tester"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := app.AnnotateTimeRange("birds", "a.wav", 0.5, 1.25, "bird/song", "tester"); err != nil {
+				t.Fatal(err)
+			}
+			exported, err := app.ExportAnnotations("birds", format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := app.CreateProject("frogs"); err != nil {
+				t.Fatal(err)
+			}
+			n, err := app.ImportAnnotations("frogs", exported, format)
+			if err != nil || n != 2 {
+				t.Fatalf("ImportAnnotations returned %d, %v; want 2", n, err)
+			}
+			want, _ := app.ListAnnotations("birds")
+			got, _ := app.ListAnnotations("frogs")
+			if len(got) != len(want) {
+				t.Fatalf("%d annotations imported, want %d", len(got), len(want))
+			}
+			for i := range want {
+				if got[i].ID != want[i].ID || got[i].Label != want[i].Label || got[i].MD5Hash != want[i].MD5Hash ||
+					got[i].StartTime != want[i].StartTime || got[i].EndTime != want[i].EndTime || !got[i].CreatedAt.Equal(want[i].CreatedAt) {
+					t.Errorf("annotation %d: imported %+v, want %+v", i, got[i], want[i])
+				}
+			}
+			labels, _ := app.GetLabels("frogs")
+			if !labels.has("bird/song/alarm") {
+				t.Errorf("labels of the import were not added: %v", labels)
+			}
+
+			// Importing again replaces the annotations instead of adding them
+			if _, err := app.ImportAnnotations("frogs", exported, format); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := app.ListAnnotations("frogs"); len(got) != len(want) {
+				t.Errorf("%d annotations after importing twice, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestParseAnnotationsCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"reordered columns", "origin,label,source_file,start_time,end_time\nrange,bird,a.wav,0,1\n", true},
+		{"missing origin", "label,md5_hash\nbird,abc\n", false},
+		{"invalid time", "label,origin,start_time\nbird,range,soon\n", false},
+		{"invalid cluster", "label,origin,cluster\nbird,cluster,first\n", false},
+	}
+	for _, tt := range tests {
+		annotations, err := parseAnnotationsCSV(strings.NewReader(tt.data))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: got %v, %v; want valid %t", tt.name, annotations, err, tt.valid)
+		}
+	}
+}
@@ -3,123 +3,147 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 type App struct {
-    ctx context.Context
+	ctx       context.Context
+	workspace *Workspace // Where the projects of this instance live
+	jobs      *jobManager
+	labelsMu  sync.Mutex // Guards the label and annotation files of all projects
 }
 
+// Errors of project management calls, wrapped with the project name.
+var (
+	errProjectNotFound = errors.New("project not found")
+	errProjectExists   = errors.New("project already exists")
+	errProjectBusy     = errors.New("project has queued or running jobs")
+)
+
 type ProjectData struct {
-	SelectedDirectory string            `json:"selected_directory"`
+	SelectedDirectory string              `json:"selected_directory"`
 	FileList          map[string][]string `json:"file_list"`
 }
 
-func NewApp() *App {
-    return &App{}
+func NewApp(workspace *Workspace) *App {
+	a := &App{workspace: workspace}
+	a.jobs = newJobManager(a.emitJobEvent)
+	return a
 }
 
 func (a *App) startup(ctx context.Context) {
-    a.ctx = ctx
-
-    // Initialize project directories
-    projectDir, err := a.CreateProjectFolder()
-    if err != nil {
-        fmt.Println("Error creating NeuralForge directory:", err)
-        return
-    }
-
-    // Ensure the "projects" subdirectory exists
-    projectsDir := filepath.Join(projectDir, "projects")
-    err = os.MkdirAll(projectsDir, os.ModePerm)
-    if err != nil {
-        fmt.Println("Error creating projects directory:", err)
-        return
-    }
-
-    fmt.Println("NeuralForge and projects directories are ready.")
+	a.ctx = ctx
+
+	// Initialize the workspace and its projects directory
+	err := a.workspace.ensure()
+	if err != nil {
+		fmt.Println("Error creating projects directory:", err)
+		return
+	}
+
+	fmt.Printf("NeuralForge workspace %s is ready.\n", a.workspace.Root)
 }
 
 func (a *App) Greet(name string) string {
-    return fmt.Sprintf("Hello %s, It's show time!", name)
+	return fmt.Sprintf("Hello %s, It's show time!", name)
 }
 
 func (a *App) CreateProjectFolder() (string, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return "", err
-    }
-
-    NeuralForgeDir := filepath.Join(homeDir, "NeuralForge")
-
-    err = os.MkdirAll(NeuralForgeDir, os.ModePerm)
-    if err != nil {
-        return "", err
-    }
+	err := a.workspace.ensure()
+	if err != nil {
+		return "", err
+	}
 
-    return NeuralForgeDir, nil
+	return a.workspace.Root, nil
 }
 
 func (a *App) ListProjects() ([]string, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return nil, err
-    }
-
-    projectsDir := filepath.Join(homeDir, "NeuralForge", "projects")
-    folders, err := ioutil.ReadDir(projectsDir)
-    if err != nil {
-        return nil, err
-    }
-
-    var projectNames []string
-    for _, folder := range folders {
-        if folder.IsDir() {
-            projectNames = append(projectNames, folder.Name())
-        }
-    }
-
-    return projectNames, nil
-}
+	projectsDir := a.workspace.projectsDir()
+	folders, err := ioutil.ReadDir(projectsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var projectNames []string
+	for _, folder := range folders {
+		// Skip folders that cannot be addressed as a project, such as hidden ones
+		if folder.IsDir() && checkProjectName(folder.Name()) == nil {
+			projectNames = append(projectNames, folder.Name())
+		}
+	}
 
+	return projectNames, nil
+}
 
+// CreateProject creates a project named after the slug of projectName and
+// records projectName as its display name. It returns the project folder,
+// whose base name is the slug used by every other call. The project type is
+// taken from the _sup suffix the frontend gives supervised projects.
 func (a *App) CreateProject(projectName string) (string, error) {
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return "", err
-    }
-
-    projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
-    err = os.MkdirAll(projectDir, os.ModePerm)
-    if err != nil {
-        return "", err
-    }
-
-    return projectDir, nil
+	return a.CreateProjectWithType(projectName, "")
 }
 
+// CreateProjectWithType creates a project like CreateProject with the given
+// project type, or the type inferred from the name if it is empty.
+func (a *App) CreateProjectWithType(projectName string, projectType string) (string, error) {
+	slug, err := projectSlug(projectName)
+	if err != nil {
+		return "", err
+	}
+	if projectType == "" {
+		projectType = projectTypeFromName(projectName)
+	}
+	err = checkProjectType(projectType)
+	if err != nil {
+		return "", err
+	}
+	projectDir, err := a.workspace.projectDir(slug)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(projectDir); err == nil {
+		return "", fmt.Errorf("%w: %s", errProjectExists, slug)
+	}
 
-func (a *App) SaveSelectedDirectory(directoryPath string, projectName string) error {
-	// Save the selected directory path in the project folder config JSON
-	projectDir, err := a.CreateProject(projectName)
+	err = os.MkdirAll(projectDir, os.ModePerm)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	info := ProjectInfo{Name: slug, DisplayName: strings.TrimSpace(projectName)}
+	err = saveProjectInfo(projectDir, info)
+	if err != nil {
+		return "", fmt.Errorf("error saving project info: %v", err)
 	}
 
-	configFilePath := filepath.Join(projectDir, "config.json")
-	configData := map[string]string{
-		"selected_directory": directoryPath,
+	err = saveProjectConfig(projectDir, defaultProjectConfig(projectType))
+	if err != nil {
+		return "", fmt.Errorf("error saving project config: %v", err)
 	}
 
-	configJson, err := json.MarshalIndent(configData, "", "  ")
+	return projectDir, nil
+}
+
+func (a *App) SaveSelectedDirectory(directoryPath string, projectName string) error {
+	// Save the selected directory path in the project folder config JSON
+	projectDir, err := a.workspace.projectDir(projectName)
 	if err != nil {
 		return err
 	}
+	if !projectExists(projectDir) {
+		return fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
 
-	err = ioutil.WriteFile(configFilePath, configJson, os.ModePerm)
+	err = updateProjectConfig(projectDir, func(config *ProjectConfig) error {
+		config.SelectedDirectory = directoryPath
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -141,30 +165,19 @@ func (a *App) SaveSelectedDirectory(directoryPath string, projectName string) er
 	return nil
 }
 
-
 func (a *App) GetProjectData(projectName string) (*ProjectData, error) {
-	homeDir, err := os.UserHomeDir()
+	projectDir, err := a.workspace.projectDir(projectName)
 	if err != nil {
 		return nil, err
 	}
 
-	projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
-
 	// Read config.json to get the selected directory path
-	configFilePath := filepath.Join(projectDir, "config.json")
-	configData, err := ioutil.ReadFile(configFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %v", err)
-	}
-
-	var config map[string]string
-	err = json.Unmarshal(configData, &config)
+	config, err := loadProjectConfig(projectDir)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshalling config data: %v", err)
+		return nil, err
 	}
-
-	selectedDirectory, ok := config["selected_directory"]
-	if !ok {
+	selectedDirectory := config.SelectedDirectory
+	if selectedDirectory == "" {
 		return nil, fmt.Errorf("selected_directory not found in config")
 	}
 
@@ -190,3 +203,80 @@ func (a *App) GetProjectData(projectName string) (*ProjectData, error) {
 	return projectData, nil
 }
 
+// DeleteProject removes a project folder with everything in it.
+func (a *App) DeleteProject(projectName string) error {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return err
+	}
+
+	if !projectExists(projectDir) {
+		return fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	if a.jobs.active(projectName) {
+		return fmt.Errorf("%w: %s", errProjectBusy, projectName)
+	}
+
+	err = os.RemoveAll(projectDir)
+	if err != nil {
+		return fmt.Errorf("error deleting project: %v", err)
+	}
+
+	fmt.Printf("Project %s has been deleted.\n", projectName)
+	return nil
+}
+
+// RenameProject gives a project a new display name and moves its folder to
+// the slug of that name. Project files only use paths relative to the project
+// folder, so nothing inside needs rewriting.
+func (a *App) RenameProject(projectName string, newName string) (*ProjectInfo, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(newName) == "" {
+		return nil, fmt.Errorf("%w: new project name is required", errInvalidProjectName)
+	}
+	slug, err := projectSlug(newName)
+	if err != nil {
+		return nil, err
+	}
+	newProjectDir, err := a.workspace.projectDir(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	if !projectExists(projectDir) {
+		return nil, fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	if _, err := os.Stat(newProjectDir); err == nil && slug != projectName {
+		return nil, fmt.Errorf("%w: %s", errProjectExists, slug)
+	}
+	if a.jobs.active(projectName) {
+		return nil, fmt.Errorf("%w: %s", errProjectBusy, projectName)
+	}
+
+	info, err := loadProjectInfo(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if slug != projectName {
+		err = os.Rename(projectDir, newProjectDir)
+		if err != nil {
+			return nil, fmt.Errorf("error renaming project: %v", err)
+		}
+	}
+	info.Name, info.DisplayName = slug, strings.TrimSpace(newName)
+	err = saveProjectInfo(newProjectDir, info)
+	if err != nil {
+		return nil, fmt.Errorf("error saving project info: %v", err)
+	}
+
+	fmt.Printf("Project %s has been renamed to %s.\n", projectName, slug)
+	return &info, nil
+}
+
+func projectExists(projectDir string) bool {
+	info, err := os.Stat(projectDir)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestProject creates a project in a temporary workspace and returns the
+// app serving it along with the project directory.
+func newTestProject(t *testing.T, projectName string) (*App, string) {
+	t.Helper()
+	workspace, err := newWorkspace(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewApp(workspace)
+	projectDir, err := app.CreateProject(projectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app, projectDir
+}
+
+// writeTestSpectrogram saves a spectrogram file named after md5Hash in the
+// project.
+func writeTestSpectrogram(t *testing.T, projectDir, md5Hash string, spectrogram [][]float64) {
+	t.Helper()
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+	if err := os.MkdirAll(spectrogramsDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	data := SpectrogramData{FileName: md5Hash + ".wav", MD5Hash: md5Hash, SampleRate: 8000, Spectrogram: spectrogram}
+	if err := saveJSON(filepath.Join(spectrogramsDir, md5Hash+".json"), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestClusters saves spectrograms around two well separated centres:
+// the first n hashes near 0 and the next n near 10.
+func writeTestClusters(t *testing.T, projectDir string, n int) []string {
+	t.Helper()
+	var md5Hashes []string
+	for i := 0; i < 2*n; i++ {
+		centre := 0.0
+		if i >= n {
+			centre = 10
+		}
+		offset := float64(i%n) * 0.1
+		md5Hash := fmt.Sprintf("%032x", i)
+		writeTestSpectrogram(t, projectDir, md5Hash, [][]float64{{centre + offset, centre - offset}, {centre, centre + offset}})
+		md5Hashes = append(md5Hashes, md5Hash)
+	}
+	return md5Hashes
+}
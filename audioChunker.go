@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+)
+
+// ChunkConfig controls how source recordings are segmented before
+// spectrogram generation. Durations are in seconds.
+type ChunkConfig struct {
+	Duration   float64 `json:"duration"`    // 0 keeps each file as a single chunk
+	Overlap    float64 `json:"overlap"`     // Overlap between consecutive chunks
+	TailPolicy string  `json:"tail_policy"` // "pad" zero-fills the last chunk, "drop" discards it
+}
+
+func defaultChunkConfig() ChunkConfig {
+	return ChunkConfig{
+		Duration:   3,
+		Overlap:    0,
+		TailPolicy: "pad",
+	}
+}
+
+func (cfg ChunkConfig) validate() error {
+	if cfg.Duration < 0 {
+		return fmt.Errorf("chunk duration cannot be negative")
+	}
+	if cfg.Overlap < 0 || (cfg.Duration > 0 && cfg.Overlap >= cfg.Duration) {
+		return fmt.Errorf("chunk overlap must be between 0 and the chunk duration")
+	}
+	if cfg.TailPolicy != "pad" && cfg.TailPolicy != "drop" {
+		return fmt.Errorf("unknown tail policy: %s", cfg.TailPolicy)
+	}
+	return nil
+}
+
+// chunkSpan is a range of frames [Start, End) of a source file. Length is the
+// chunk length in frames, which exceeds End-Start when the tail is padded.
+type chunkSpan struct {
+	Start  int
+	End    int
+	Length int
+}
+
+// audioChunk is one decoded segment of a source recording.
+type audioChunk struct {
+	SourcePath string
+	Index      int
+	SampleRate int
+	Span       chunkSpan
+	Samples    []float64
+}
+
+func (c audioChunk) StartTime() float64 {
+	return float64(c.Span.Start) / float64(c.SampleRate)
+}
+
+func (c audioChunk) EndTime() float64 {
+	return float64(c.Span.End) / float64(c.SampleRate)
+}
+
+// MD5 identifies the chunk by the hash of its 16-bit PCM WAV encoding, so the
+// same audio always maps to the same spectrogram file.
+func (c audioChunk) MD5() string {
+	hash := md5.Sum(encodeWAV(c.Samples, c.SampleRate))
+	return hex.EncodeToString(hash[:])
+}
+
+// planChunks splits the frames [start, end) into fixed-length windows
+// according to cfg.
+func planChunks(start, end, sampleRate int, cfg ChunkConfig) []chunkSpan {
+	if end <= start {
+		return nil
+	}
+	if cfg.Duration == 0 {
+		return []chunkSpan{{Start: start, End: end, Length: end - start}}
+	}
+
+	length := int(cfg.Duration * float64(sampleRate))
+	step := length - int(cfg.Overlap*float64(sampleRate))
+	if length <= 0 || step <= 0 {
+		return []chunkSpan{{Start: start, End: end, Length: end - start}}
+	}
+
+	var spans []chunkSpan
+	for pos := start; pos < end; pos += step {
+		chunkEnd := pos + length
+		if chunkEnd <= end {
+			spans = append(spans, chunkSpan{Start: pos, End: chunkEnd, Length: length})
+			if chunkEnd == end {
+				break
+			}
+			continue
+		}
+
+		// Skip tails that are fully covered by the previous overlapping chunk.
+		if len(spans) > 0 && spans[len(spans)-1].End >= end {
+			break
+		}
+		if cfg.TailPolicy == "pad" {
+			spans = append(spans, chunkSpan{Start: pos, End: end, Length: length})
+		}
+		break
+	}
+	return spans
+}
+
+// frameRange is a half-open range [Start, End) of sample frames.
+type frameRange struct {
+	Start int
+	End   int
+}
+
+// widenRange grows a region shorter than one chunk symmetrically with the
+// surrounding audio, so short events survive the "drop" tail policy.
+func widenRange(fr frameRange, length, numFrames int) frameRange {
+	missing := length - (fr.End - fr.Start)
+	if missing <= 0 {
+		return fr
+	}
+	start := max(0, fr.Start-missing/2)
+	end := min(numFrames, start+length)
+	start = max(0, end-length)
+	return frameRange{Start: start, End: end}
+}
+
+// forEachChunk decodes the given regions of a source file chunk by chunk and
+// hands each chunk to fn, so long recordings never have to be held in memory
+// at once. Chunk indices run sequentially across regions.
+func forEachChunk(r *wavReader, sourcePath string, regions []frameRange, cfg ChunkConfig, fn func(chunk audioChunk) error) error {
+	info := r.Info()
+	length := int(cfg.Duration * float64(info.SampleRate))
+
+	index := 0
+	for _, region := range regions {
+		if cfg.Duration > 0 {
+			region = widenRange(region, length, info.NumFrames)
+		}
+
+		for _, span := range planChunks(region.Start, region.End, info.SampleRate, cfg) {
+			samples, err := r.ReadRange(span.Start, span.End)
+			if err != nil {
+				return fmt.Errorf("error reading chunk %d of %s: %v", index, sourcePath, err)
+			}
+			if len(samples) < span.Length {
+				samples = append(samples, make([]float64, span.Length-len(samples))...)
+			}
+
+			chunk := audioChunk{
+				SourcePath: sourcePath,
+				Index:      index,
+				SampleRate: info.SampleRate,
+				Span:       span,
+				Samples:    samples,
+			}
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			index++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+	const sampleRate = 10
+	tests := []struct {
+		name       string
+		start, end int
+		cfg        ChunkConfig
+		want       []chunkSpan
+	}{
+		{"whole file", 0, 25, ChunkConfig{Duration: 0, TailPolicy: "pad"}, []chunkSpan{{0, 25, 25}}},
+		{"exact fit", 0, 20, ChunkConfig{Duration: 1, TailPolicy: "pad"}, []chunkSpan{{0, 10, 10}, {10, 20, 10}}},
+		{"padded tail", 0, 25, ChunkConfig{Duration: 1, TailPolicy: "pad"}, []chunkSpan{{0, 10, 10}, {10, 20, 10}, {20, 25, 10}}},
+		{"dropped tail", 0, 25, ChunkConfig{Duration: 1, TailPolicy: "drop"}, []chunkSpan{{0, 10, 10}, {10, 20, 10}}},
+		{"shorter than a chunk", 0, 5, ChunkConfig{Duration: 1, TailPolicy: "pad"}, []chunkSpan{{0, 5, 10}}},
+		{"shorter than a chunk dropped", 0, 5, ChunkConfig{Duration: 1, TailPolicy: "drop"}, nil},
+		{"overlap", 0, 20, ChunkConfig{Duration: 1, Overlap: 0.5, TailPolicy: "pad"}, []chunkSpan{{0, 10, 10}, {5, 15, 10}, {10, 20, 10}}},
+		{"overlap covers the tail", 0, 22, ChunkConfig{Duration: 1, Overlap: 0.8, TailPolicy: "pad"}, []chunkSpan{{0, 10, 10}, {2, 12, 10}, {4, 14, 10}, {6, 16, 10}, {8, 18, 10}, {10, 20, 10}, {12, 22, 10}}},
+		{"offset region", 30, 45, ChunkConfig{Duration: 1, TailPolicy: "pad"}, []chunkSpan{{30, 40, 10}, {40, 45, 10}}},
+		{"empty region", 10, 10, ChunkConfig{Duration: 1, TailPolicy: "pad"}, nil},
+	}
+	for _, tt := range tests {
+		if got := planChunks(tt.start, tt.end, sampleRate, tt.cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChunkConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   ChunkConfig
+		valid bool
+	}{
+		{"default", defaultChunkConfig(), true},
+		{"whole files", ChunkConfig{Duration: 0, TailPolicy: "drop"}, true},
+		{"negative duration", ChunkConfig{Duration: -1, TailPolicy: "pad"}, false},
+		{"overlap of a whole chunk", ChunkConfig{Duration: 1, Overlap: 1, TailPolicy: "pad"}, false},
+		{"unknown tail policy", ChunkConfig{Duration: 1, TailPolicy: "wrap"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestWidenRange(t *testing.T) {
+	tests := []struct {
+		name string
+		fr   frameRange
+		want frameRange
+	}{
+		{"long enough", frameRange{10, 30}, frameRange{10, 30}},
+		{"centred", frameRange{40, 46}, frameRange{38, 48}},
+		{"at the start", frameRange{0, 4}, frameRange{0, 10}},
+		{"at the end", frameRange{96, 100}, frameRange{90, 100}},
+	}
+	for _, tt := range tests {
+		if got := widenRange(tt.fr, 10, 100); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
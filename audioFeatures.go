@@ -0,0 +1,382 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const featuresFileSuffix = ".features.json"
+
+// FeatureConfig controls mel filterbank and MFCC extraction.
+type FeatureConfig struct {
+	MelBands        int     `json:"mel_bands"`
+	NumCoefficients int     `json:"num_coefficients"`
+	MinFrequency    float64 `json:"min_frequency"`
+	MaxFrequency    float64 `json:"max_frequency"` // 0 means the Nyquist frequency
+	DeltaWidth      int     `json:"delta_width"`   // Frames on each side used for deltas
+}
+
+func defaultFeatureConfig() FeatureConfig {
+	return FeatureConfig{
+		MelBands:        40,
+		NumCoefficients: 13,
+		MinFrequency:    0,
+		MaxFrequency:    0,
+		DeltaWidth:      2,
+	}
+}
+
+func (cfg FeatureConfig) validate() error {
+	if cfg.MelBands <= 0 {
+		return fmt.Errorf("mel bands must be positive")
+	}
+	if cfg.NumCoefficients <= 0 || cfg.NumCoefficients > cfg.MelBands {
+		return fmt.Errorf("coefficient count must be between 1 and the number of mel bands (%d)", cfg.MelBands)
+	}
+	if cfg.MinFrequency < 0 || (cfg.MaxFrequency != 0 && cfg.MaxFrequency <= cfg.MinFrequency) {
+		return fmt.Errorf("invalid frequency range %.1f-%.1f Hz", cfg.MinFrequency, cfg.MaxFrequency)
+	}
+	if cfg.DeltaWidth <= 0 {
+		return fmt.Errorf("delta width must be positive")
+	}
+	return nil
+}
+
+// AudioFeatures holds the compact per-frame features of one spectrogram. All
+// matrices are indexed as [frame][band or coefficient].
+type AudioFeatures struct {
+	FileName    string        `json:"file_name"`
+	MD5Hash     string        `json:"md5_hash"`
+	SampleRate  int           `json:"sample_rate"`
+	Config      FeatureConfig `json:"config"`
+	MelEnergies [][]float64   `json:"mel_energies"`
+	LogMel      [][]float64   `json:"log_mel"`
+	MFCC        [][]float64   `json:"mfcc"`
+	Delta       [][]float64   `json:"delta"`
+	DeltaDelta  [][]float64   `json:"delta_delta"`
+}
+
+// summaryVector condenses the per-frame features into one fixed-length vector:
+// the mean and standard deviation of every MFCC, delta and delta-delta.
+func (f *AudioFeatures) summaryVector() []float64 {
+	var summary []float64
+	for _, frames := range [][][]float64{f.MFCC, f.Delta, f.DeltaDelta} {
+		summary = appendMeanAndDeviation(summary, frames)
+	}
+	return summary
+}
+
+// melSummaryVector is the mean and standard deviation of every log-mel band.
+func (f *AudioFeatures) melSummaryVector() []float64 {
+	return appendMeanAndDeviation(nil, f.LogMel)
+}
+
+// appendMeanAndDeviation appends the mean and then the standard deviation of
+// every column of frames to summary.
+func appendMeanAndDeviation(summary []float64, frames [][]float64) []float64 {
+	if len(frames) == 0 {
+		return summary
+	}
+	dims := len(frames[0])
+	mean := make([]float64, dims)
+	deviation := make([]float64, dims)
+	for _, frame := range frames {
+		for i, v := range frame {
+			mean[i] += v
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(len(frames))
+	}
+	for _, frame := range frames {
+		for i, v := range frame {
+			deviation[i] += (v - mean[i]) * (v - mean[i])
+		}
+	}
+	for i := range deviation {
+		deviation[i] = math.Sqrt(deviation[i] / float64(len(frames)))
+	}
+	summary = append(summary, mean...)
+	return append(summary, deviation...)
+}
+
+// ExtractAudioFeatures computes mel and MFCC features for every spectrogram of
+// the project and saves them as <md5>.features.json next to the spectrogram
+// JSON. Spectrograms whose features the manifest shows as extracted with the
+// current settings are skipped. It returns the MD5 hashes that were processed.
+func (a *App) ExtractAudioFeatures(projectName string) ([]string, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, a.LogError(projectName, err, "error loading pipeline settings")
+	}
+	if err := settings.Features.validate(); err != nil {
+		return nil, a.LogError(projectName, err, "invalid feature settings")
+	}
+
+	files, err := listSpectrogramFiles(spectrogramsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spectrogram JSON files: %v", err)
+	}
+
+	manifest, err := openManifest(projectDir)
+	if err != nil {
+		return nil, a.LogError(projectName, err, "error loading manifest")
+	}
+	featureParams := paramsHash(settings.Features)
+	done := map[string]bool{}
+	for _, entry := range manifest.snapshot() {
+		if entry.stageDone(stageFeatures, featureParams) {
+			for _, md5Hash := range entry.Chunks {
+				done[md5Hash] = true
+			}
+		}
+	}
+	pending := files[:0]
+	for _, file := range files {
+		md5Hash := strings.TrimSuffix(filepath.Base(file), ".json")
+		if _, err := os.Stat(featuresFilePath(spectrogramsDir, md5Hash)); err != nil || !done[md5Hash] {
+			done[md5Hash] = false
+			pending = append(pending, file)
+		}
+	}
+	files = pending
+
+	var processed []string
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var fileCounter int32
+	fileChan := make(chan string, spectrogramChunkBatch)
+
+	for i := 0; i < spectrogramChunkBatch; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range fileChan {
+				md5Hash, err := generateAndSaveFeatures(filePath, settings.Features)
+				if err != nil {
+					a.LogError(projectName, err, fmt.Sprintf("error extracting features: %s", filePath))
+				} else {
+					mu.Lock()
+					processed = append(processed, md5Hash)
+					done[md5Hash] = true
+					mu.Unlock()
+				}
+				atomic.AddInt32(&fileCounter, 1)
+				fmt.Printf("Extracted features %d/%d: %s\n", atomic.LoadInt32(&fileCounter), len(files), filePath)
+			}
+		}()
+	}
+
+	for _, file := range files {
+		fileChan <- file
+	}
+	close(fileChan)
+	wg.Wait()
+
+	if err := manifest.markStage(stageFeatures, featureParams, done); err != nil {
+		a.LogError(projectName, err, "error saving manifest")
+	}
+
+	fmt.Println("Feature extraction completed.")
+	return processed, nil
+}
+
+// listSpectrogramFiles returns the spectrogram JSON files of a directory,
+// leaving out the feature files stored alongside them.
+func listSpectrogramFiles(spectrogramsDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(spectrogramsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	spectrograms := files[:0]
+	for _, file := range files {
+		if !strings.HasSuffix(file, featuresFileSuffix) {
+			spectrograms = append(spectrograms, file)
+		}
+	}
+	return spectrograms, nil
+}
+
+func featuresFilePath(spectrogramsDir, md5Hash string) string {
+	return filepath.Join(spectrogramsDir, md5Hash+featuresFileSuffix)
+}
+
+func generateAndSaveFeatures(spectrogramPath string, cfg FeatureConfig) (string, error) {
+	fileData, err := os.ReadFile(spectrogramPath)
+	if err != nil {
+		return "", err
+	}
+
+	var spectrogram SpectrogramData
+	if err := json.Unmarshal(fileData, &spectrogram); err != nil {
+		return "", fmt.Errorf("error unmarshalling spectrogram: %v", err)
+	}
+	if spectrogram.SampleRate == 0 || spectrogram.STFT.FFTSize == 0 {
+		return "", fmt.Errorf("spectrogram has no STFT metadata; regenerate it")
+	}
+
+	features := extractFeatures(dbToMagnitudes(spectrogram.Spectrogram), spectrogram.SampleRate, spectrogram.STFT.FFTSize, cfg)
+	features.FileName = spectrogram.FileName
+	features.MD5Hash = spectrogram.MD5Hash
+
+	jsonData, err := json.MarshalIndent(features, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	outPath := featuresFilePath(filepath.Dir(spectrogramPath), spectrogram.MD5Hash)
+	if err := os.WriteFile(outPath, jsonData, os.ModePerm); err != nil {
+		return "", fmt.Errorf("error saving features: %v", err)
+	}
+
+	return spectrogram.MD5Hash, nil
+}
+
+// extractFeatures turns a linear magnitude spectrogram into mel energies,
+// log-mel (dB), MFCCs and their first and second order deltas.
+func extractFeatures(magnitudes [][]float64, sampleRate, fftSize int, cfg FeatureConfig) *AudioFeatures {
+	filterbank := melFilterbank(sampleRate, fftSize, cfg)
+
+	features := &AudioFeatures{
+		SampleRate:  sampleRate,
+		Config:      cfg,
+		MelEnergies: make([][]float64, len(magnitudes)),
+		LogMel:      make([][]float64, len(magnitudes)),
+		MFCC:        make([][]float64, len(magnitudes)),
+	}
+
+	for f, row := range magnitudes {
+		energies := make([]float64, len(filterbank))
+		logMel := make([]float64, len(filterbank))
+		for m, filter := range filterbank {
+			var energy float64
+			for bin, weight := range filter {
+				if weight != 0 && bin < len(row) {
+					energy += weight * row[bin] * row[bin]
+				}
+			}
+			energies[m] = energy
+			logMel[m] = 10 * math.Log10(math.Max(energy, 1e-12))
+		}
+		features.MelEnergies[f] = energies
+		features.LogMel[f] = logMel
+		features.MFCC[f] = dctII(logMel, cfg.NumCoefficients)
+	}
+
+	features.Delta = computeDeltas(features.MFCC, cfg.DeltaWidth)
+	features.DeltaDelta = computeDeltas(features.Delta, cfg.DeltaWidth)
+	return features
+}
+
+func hzToMel(hz float64) float64 {
+	return 2595 * math.Log10(1+hz/700)
+}
+
+func melToHz(mel float64) float64 {
+	return 700 * (math.Pow(10, mel/2595) - 1)
+}
+
+// melFilterbank builds triangular filters spaced evenly on the mel scale,
+// each expressed as weights over the FFT bins.
+func melFilterbank(sampleRate, fftSize int, cfg FeatureConfig) [][]float64 {
+	numBins := fftSize/2 + 1
+	maxFrequency := cfg.MaxFrequency
+	nyquist := float64(sampleRate) / 2
+	if maxFrequency == 0 || maxFrequency > nyquist {
+		maxFrequency = nyquist
+	}
+
+	minMel := hzToMel(cfg.MinFrequency)
+	maxMel := hzToMel(maxFrequency)
+	edges := make([]float64, cfg.MelBands+2)
+	for i := range edges {
+		edges[i] = melToHz(minMel + (maxMel-minMel)*float64(i)/float64(cfg.MelBands+1))
+	}
+
+	binWidth := float64(sampleRate) / float64(fftSize)
+	filterbank := make([][]float64, cfg.MelBands)
+	for m := range filterbank {
+		lower, center, upper := edges[m], edges[m+1], edges[m+2]
+		filter := make([]float64, numBins)
+		for bin := range filter {
+			freq := float64(bin) * binWidth
+			switch {
+			case freq > lower && freq <= center:
+				filter[bin] = (freq - lower) / (center - lower)
+			case freq > center && freq < upper:
+				filter[bin] = (upper - freq) / (upper - center)
+			}
+		}
+		filterbank[m] = filter
+	}
+	return filterbank
+}
+
+// dctII returns the first n coefficients of the orthonormal DCT-II of x.
+func dctII(x []float64, n int) []float64 {
+	size := float64(len(x))
+	out := make([]float64, n)
+	for k := 0; k < n; k++ {
+		var sum float64
+		for i, v := range x {
+			sum += v * math.Cos(math.Pi*float64(k)*(float64(i)+0.5)/size)
+		}
+		scale := math.Sqrt(2 / size)
+		if k == 0 {
+			scale = math.Sqrt(1 / size)
+		}
+		out[k] = sum * scale
+	}
+	return out
+}
+
+// computeDeltas applies the standard regression formula over +/- width frames,
+// repeating the edge frames at the boundaries.
+func computeDeltas(frames [][]float64, width int) [][]float64 {
+	deltas := make([][]float64, len(frames))
+	if len(frames) == 0 {
+		return deltas
+	}
+
+	var denominator float64
+	for n := 1; n <= width; n++ {
+		denominator += 2 * float64(n*n)
+	}
+
+	clamp := func(i int) int {
+		if i < 0 {
+			return 0
+		}
+		if i >= len(frames) {
+			return len(frames) - 1
+		}
+		return i
+	}
+
+	for t := range frames {
+		delta := make([]float64, len(frames[t]))
+		for n := 1; n <= width; n++ {
+			next, prev := frames[clamp(t+n)], frames[clamp(t-n)]
+			for i := range delta {
+				delta[i] += float64(n) * (next[i] - prev[i])
+			}
+		}
+		for i := range delta {
+			delta[i] /= denominator
+		}
+		deltas[t] = delta
+	}
+	return deltas
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMelScaleRoundTrip(t *testing.T) {
+	for _, hz := range []float64{0, 100, 700, 1000, 8000, 22050} {
+		if got := melToHz(hzToMel(hz)); math.Abs(got-hz) > 1e-6 {
+			t.Errorf("melToHz(hzToMel(%g)) = %g", hz, got)
+		}
+	}
+	// 1000 Hz is close to 1000 mel by construction of the scale
+	if mel := hzToMel(1000); math.Abs(mel-1000) > 1 {
+		t.Errorf("hzToMel(1000) = %g, want about 1000", mel)
+	}
+}
+
+func TestMelFilterbank(t *testing.T) {
+	cfg := FeatureConfig{MelBands: 10, NumCoefficients: 5, MaxFrequency: 4000, DeltaWidth: 2}
+	filterbank := melFilterbank(16000, 512, cfg)
+	if len(filterbank) != cfg.MelBands {
+		t.Fatalf("%d filters, want %d", len(filterbank), cfg.MelBands)
+	}
+	binWidth := 16000.0 / 512
+	previousPeak := -1
+	for m, filter := range filterbank {
+		if len(filter) != 257 {
+			t.Fatalf("filter %d has %d weights, want 257", m, len(filter))
+		}
+		peak := 0
+		for bin, weight := range filter {
+			if weight < 0 || weight > 1 {
+				t.Errorf("filter %d: weight %g at bin %d outside [0, 1]", m, weight, bin)
+			}
+			if weight > 1e-9 && float64(bin)*binWidth >= cfg.MaxFrequency {
+				t.Errorf("filter %d: weight %g at %g Hz above the maximum frequency", m, weight, float64(bin)*binWidth)
+			}
+			if weight > filter[peak] {
+				peak = bin
+			}
+		}
+		if peak <= previousPeak {
+			t.Errorf("filter %d peaks at bin %d, not above the previous filter's %d", m, peak, previousPeak)
+		}
+		previousPeak = peak
+	}
+}
+
+func TestDCTII(t *testing.T) {
+	tests := []struct {
+		name string
+		x    []float64
+		want []float64
+	}{
+		{"constant", []float64{1, 1, 1, 1}, []float64{2, 0, 0, 0}},
+		{"alternating", []float64{1, -1}, []float64{0, math.Sqrt2}},
+		{"truncated", []float64{3, 3, 3}, []float64{3 * math.Sqrt(3)}},
+	}
+	for _, tt := range tests {
+		got := dctII(tt.x, len(tt.want))
+		for k := range tt.want {
+			if math.Abs(got[k]-tt.want[k]) > 1e-9 {
+				t.Errorf("%s: coefficient %d is %g, want %g", tt.name, k, got[k], tt.want[k])
+			}
+		}
+	}
+}
+
+func TestComputeDeltas(t *testing.T) {
+	// A linear ramp has a slope of one away from the edges, where the
+	// repeated edge frames flatten it
+	frames := [][]float64{{0}, {1}, {2}, {3}, {4}, {5}}
+	deltas := computeDeltas(frames, 2)
+	want := []float64{0.5, 0.8, 1, 1, 0.8, 0.5}
+	for i, delta := range deltas {
+		if math.Abs(delta[0]-want[i]) > 1e-9 {
+			t.Errorf("frame %d: delta %g, want %g", i, delta[0], want[i])
+		}
+	}
+	if len(computeDeltas(nil, 2)) != 0 {
+		t.Error("deltas of no frames are not empty")
+	}
+}
+
+func TestExtractFeaturesShapes(t *testing.T) {
+	cfg := defaultFeatureConfig()
+	magnitudes := make([][]float64, 5)
+	for f := range magnitudes {
+		magnitudes[f] = make([]float64, 257)
+		magnitudes[f][10+f] = 1
+	}
+	features := extractFeatures(magnitudes, 16000, 512, cfg)
+	for name, matrix := range map[string][][]float64{
+		"mel energies": features.MelEnergies,
+		"log-mel":      features.LogMel,
+		"MFCC":         features.MFCC,
+		"delta":        features.Delta,
+		"delta-delta":  features.DeltaDelta,
+	} {
+		if len(matrix) != len(magnitudes) {
+			t.Errorf("%s has %d frames, want %d", name, len(matrix), len(magnitudes))
+		}
+	}
+	if len(features.LogMel[0]) != cfg.MelBands || len(features.MFCC[0]) != cfg.NumCoefficients {
+		t.Errorf("%d log-mel bands and %d MFCCs, want %d and %d", len(features.LogMel[0]), len(features.MFCC[0]), cfg.MelBands, cfg.NumCoefficients)
+	}
+	if got, want := len(features.summaryVector()), 6*cfg.NumCoefficients; got != want {
+		t.Errorf("summary vector of %d values, want %d", got, want)
+	}
+}
+
+func TestFeatureConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   FeatureConfig
+		valid bool
+	}{
+		{"default", defaultFeatureConfig(), true},
+		{"no bands", FeatureConfig{MelBands: 0, NumCoefficients: 1, DeltaWidth: 1}, false},
+		{"more coefficients than bands", FeatureConfig{MelBands: 10, NumCoefficients: 11, DeltaWidth: 1}, false},
+		{"inverted range", FeatureConfig{MelBands: 10, NumCoefficients: 5, MinFrequency: 500, MaxFrequency: 300, DeltaWidth: 1}, false},
+		{"no delta width", FeatureConfig{MelBands: 10, NumCoefficients: 5}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
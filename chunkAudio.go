@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// GetChunkAudio returns the audio behind a spectrogram as a base64 WAV data
+// URL that the frontend can hand straight to an <audio> element.
+func (a *App) GetChunkAudio(projectName string, md5Hash string) (string, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return "", err
+	}
+
+	wavData, err := loadChunkAudio(projectDir, md5Hash)
+	if err != nil {
+		return "", err
+	}
+	return "data:audio/wav;base64," + base64.StdEncoding.EncodeToString(wavData), nil
+}
+
+// loadChunkAudio returns the WAV bytes of a chunk, read from the retained chunk
+// file when there is one and otherwise cut from the retained source WAV.
+func loadChunkAudio(projectDir, md5Hash string) ([]byte, error) {
+	if _, err := hex.DecodeString(md5Hash); err != nil || len(md5Hash) != 32 {
+		return nil, fmt.Errorf("invalid MD5 hash: %q", md5Hash)
+	}
+
+	spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", md5Hash+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("error loading spectrogram %s: %v", md5Hash, err)
+	}
+
+	if spectrogram.ChunkPath != "" {
+		if wavData, err := os.ReadFile(filepath.Join(projectDir, spectrogram.ChunkPath)); err == nil {
+			return wavData, nil
+		}
+	}
+
+	if spectrogram.SourcePath == "" {
+		return nil, fmt.Errorf("no audio retained for %s; set the retention policy to keep WAV files and reprocess", md5Hash)
+	}
+
+	r, cleanup, err := openAudio(context.Background(), filepath.Join(projectDir, spectrogram.SourcePath))
+	if err != nil {
+		return nil, fmt.Errorf("error opening source audio: %v", err)
+	}
+	defer cleanup()
+	defer r.Close()
+
+	sampleRate := r.Info().SampleRate
+	start := int(math.Round(spectrogram.StartTime * float64(sampleRate)))
+	end := int(math.Round(spectrogram.EndTime * float64(sampleRate)))
+	samples, err := r.ReadRange(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error reading source audio: %v", err)
+	}
+	return encodeWAV(samples, sampleRate), nil
+}
+
+func loadSpectrogramFile(filePath string) (*SpectrogramData, error) {
+	fileData, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var spectrogram SpectrogramData
+	if err := json.Unmarshal(fileData, &spectrogram); err != nil {
+		return nil, err
+	}
+	return &spectrogram, nil
+}
@@ -0,0 +1,1005 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// Supervised classifier models.
+const (
+	classifierLogistic = "logistic" // Softmax regression
+	classifierMLP      = "mlp"      // One hidden ReLU layer
+	classifierCNN      = "cnn"      // Convolutional network on the spectrogram itself
+)
+
+// Optimizers for training.
+const (
+	optimizerSGD  = "sgd" // Mini-batch SGD with momentum
+	optimizerAdam = "adam"
+	adamBeta1     = 0.9
+	adamBeta2     = 0.999
+	adamEpsilon   = 1e-8
+)
+
+// Feature vectors a classifier can be trained on.
+const (
+	classifierInputMFCC = "mfcc" // Mean and deviation of MFCCs and deltas
+	classifierInputMel  = "mel"  // Mean and deviation of log-mel bands
+)
+
+const (
+	classifierDir            = "classifier"
+	classifierModelFile      = "model.json"
+	classifierCheckpointsDir = "checkpoints"
+)
+
+// TrainingConfig holds the settings of supervised training.
+type TrainingConfig struct {
+	Model           string    `json:"model"`
+	Input           string    `json:"input"`       // Summary features of the dense models; the CNN uses the spectrogram
+	LabelLevel      int       `json:"label_level"` // Label levels used as classes, 0 for the full label
+	HiddenUnits     int       `json:"hidden_units"`
+	CNN             CNNConfig `json:"cnn"`
+	Epochs          int       `json:"epochs"`
+	Optimizer       string    `json:"optimizer"`
+	LearningRate    float64   `json:"learning_rate"`
+	Momentum        float64   `json:"momentum"` // SGD only
+	BatchSize       int       `json:"batch_size"`
+	L2              float64   `json:"l2"`               // Weight decay
+	ValidationSplit float64   `json:"validation_split"` // Fraction of each class held out for validation
+	Checkpoints     int       `json:"checkpoints"`      // Per-epoch checkpoints kept, 0 for none
+	Seed            int64     `json:"seed"`
+}
+
+func defaultTrainingConfig() TrainingConfig {
+	return TrainingConfig{
+		Model:           classifierMLP,
+		Input:           classifierInputMFCC,
+		LabelLevel:      0,
+		HiddenUnits:     64,
+		CNN:             defaultCNNConfig(),
+		Epochs:          100,
+		Optimizer:       optimizerSGD,
+		LearningRate:    0.01,
+		Momentum:        0.9,
+		BatchSize:       32,
+		L2:              1e-4,
+		ValidationSplit: 0.2,
+		Checkpoints:     3,
+		Seed:            42,
+	}
+}
+
+func (cfg TrainingConfig) validate() error {
+	switch cfg.Model {
+	case classifierLogistic:
+	case classifierMLP:
+		if cfg.HiddenUnits <= 0 {
+			return fmt.Errorf("hidden unit count must be positive")
+		}
+	case classifierCNN:
+		if err := cfg.CNN.validate(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown classifier model: %s", cfg.Model)
+	}
+	if cfg.Input != classifierInputMFCC && cfg.Input != classifierInputMel {
+		return fmt.Errorf("unknown classifier input: %s", cfg.Input)
+	}
+	if cfg.LabelLevel < 0 || cfg.LabelLevel > labelLevels {
+		return fmt.Errorf("label level must be between 0 and %d", labelLevels)
+	}
+	if cfg.Epochs <= 0 || cfg.BatchSize <= 0 {
+		return fmt.Errorf("epochs and batch size must be positive")
+	}
+	if cfg.Optimizer != optimizerSGD && cfg.Optimizer != optimizerAdam {
+		return fmt.Errorf("unknown optimizer: %s", cfg.Optimizer)
+	}
+	if cfg.LearningRate <= 0 {
+		return fmt.Errorf("learning rate must be positive")
+	}
+	if cfg.Momentum < 0 || cfg.Momentum >= 1 {
+		return fmt.Errorf("momentum must be in [0, 1)")
+	}
+	if cfg.L2 < 0 {
+		return fmt.Errorf("L2 penalty must not be negative")
+	}
+	if cfg.ValidationSplit < 0 || cfg.ValidationSplit >= 1 {
+		return fmt.Errorf("validation split must be in [0, 1)")
+	}
+	if cfg.Checkpoints < 0 {
+		return fmt.Errorf("checkpoint count must not be negative")
+	}
+	return nil
+}
+
+// TrainingEpoch holds the metrics of one training epoch. Validation metrics
+// are 0 without a validation split.
+type TrainingEpoch struct {
+	Epoch              int     `json:"epoch"`
+	TrainLoss          float64 `json:"train_loss"`
+	TrainAccuracy      float64 `json:"train_accuracy"`
+	ValidationLoss     float64 `json:"validation_loss"`
+	ValidationAccuracy float64 `json:"validation_accuracy"`
+}
+
+// DenseLayer holds the weights of a fully connected layer.
+type DenseLayer struct {
+	Weights [][]float64 `json:"weights"` // Indexed as [output][input]
+	Bias    []float64   `json:"bias"`
+}
+
+// ClassifierModel is a trained classifier together with everything needed to
+// apply it to new spectrograms. The weights are those of the epoch with the
+// lowest validation loss.
+type ClassifierModel struct {
+	Model             string          `json:"model"`
+	Input             string          `json:"input"`
+	LabelLevel        int             `json:"label_level"`
+	Classes           []string        `json:"classes"`
+	ClassCounts       []int           `json:"class_counts"` // Training samples per class
+	FeatureMean       []float64       `json:"feature_mean"` // A single value for the CNN
+	FeatureScale      []float64       `json:"feature_scale"`
+	InputShape        []int           `json:"input_shape,omitempty"` // Frames and frequency bins of CNN input
+	ConvLayers        []ConvLayer     `json:"conv_layers,omitempty"`
+	Layers            []DenseLayer    `json:"layers"` // For the CNN, the layers after the convolutions
+	Config            TrainingConfig  `json:"config"`
+	History           []TrainingEpoch `json:"history"`
+	BestEpoch         int             `json:"best_epoch"`
+	TrainSamples      []string        `json:"train_samples"` // MD5 hashes
+	ValidationSamples []string        `json:"validation_samples"`
+	CreatedAt         time.Time       `json:"created_at"`
+}
+
+// TrainClassifier trains the classifier configured in the pipeline settings
+// on the labelled spectrograms of a project and saves it as
+// classifier/model.json. Feature extraction must have run first.
+func (a *App) TrainClassifier(projectName string) (*ClassifierModel, error) {
+	return a.trainClassifier(context.Background(), projectName, nil)
+}
+
+func (a *App) trainClassifier(ctx context.Context, projectName string, report progressFunc) (*ClassifierModel, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg := settings.Training
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid training settings: %v", err)
+	}
+
+	labels, err := a.GetSpectrogramLabels(projectName)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := loadTrainingDataset(projectDir, labels, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checkpoints of an earlier run would be pruned together with this one's
+	if err := os.RemoveAll(filepath.Join(projectDir, classifierDir, classifierCheckpointsDir)); err != nil {
+		return nil, fmt.Errorf("error clearing checkpoints: %v", err)
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	train, validation := stratifiedSplit(dataset.targets, len(dataset.classes), cfg.ValidationSplit, rng)
+	fmt.Printf("Training %s classifier on %d spectrograms (%d held out) with %d classes\n", cfg.Model, len(train), len(validation), len(dataset.classes))
+	model, err := buildClassifier(ctx, dataset, train, validation, cfg, rng, report, func(model *ClassifierModel, trainer classifierTrainer, epoch int) error {
+		return saveClassifierCheckpoint(projectDir, model, trainer, epoch)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	model.CreatedAt = time.Now()
+	if err := saveClassifierModel(projectDir, model); err != nil {
+		return nil, a.LogError(projectName, err, "error saving classifier")
+	}
+	fmt.Printf("Classifier saved; best epoch %d\n", model.BestEpoch)
+	return model, nil
+}
+
+// buildClassifier trains a model on the train rows of dataset, selecting
+// the best epoch on the validation rows. checkpoint may be nil.
+func buildClassifier(ctx context.Context, dataset *labelledDataset, train, validation []int, cfg TrainingConfig, rng *rand.Rand, report progressFunc, checkpoint func(model *ClassifierModel, trainer classifierTrainer, epoch int) error) (*ClassifierModel, error) {
+	model := &ClassifierModel{
+		Model:       cfg.Model,
+		Input:       cfg.Input,
+		LabelLevel:  cfg.LabelLevel,
+		Classes:     dataset.classes,
+		ClassCounts: make([]int, len(dataset.classes)),
+		Config:      cfg,
+	}
+	for _, row := range train {
+		model.ClassCounts[dataset.targets[row]]++
+		model.TrainSamples = append(model.TrainSamples, dataset.md5Hashes[row])
+	}
+	for _, row := range validation {
+		model.ValidationSamples = append(model.ValidationSamples, dataset.md5Hashes[row])
+	}
+
+	var trainer classifierTrainer
+	if cfg.Model == classifierCNN {
+		cnn, err := newCNNTrainer(dataset, train, cfg, rng, model)
+		if err != nil {
+			return nil, err
+		}
+		trainer = cnn
+	} else {
+		trainer = newDenseTrainer(dataset, train, cfg, rng, model)
+	}
+
+	err := fitClassifier(ctx, trainer, model, train, validation, rng, report, func(epoch int) error {
+		if checkpoint == nil {
+			return nil
+		}
+		return checkpoint(model, trainer, epoch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+// classifierTrainer trains one kind of network on the rows of a labelled
+// dataset.
+type classifierTrainer interface {
+	trainBatch(rows []int, opt *optimizer)
+	evaluate(rows []int) (loss, accuracy float64)
+	store(model *ClassifierModel) // Copies the current weights into model
+}
+
+// fitClassifier runs the training epochs, records the metrics of each in the
+// model history and keeps the weights of the epoch with the lowest
+// validation loss, or training loss without a validation split. checkpoint
+// is called after every epoch. Training fails once a loss is no longer
+// finite, rather than saving weights that never improved.
+func fitClassifier(ctx context.Context, trainer classifierTrainer, model *ClassifierModel, train, validation []int, rng *rand.Rand, report progressFunc, checkpoint func(epoch int) error) error {
+	cfg := model.Config
+	opt := newOptimizer(cfg)
+	bestLoss := math.Inf(1)
+	order := append([]int(nil), train...)
+
+	for epoch := 1; epoch <= cfg.Epochs; epoch++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+		for start := 0; start < len(order); start += cfg.BatchSize {
+			trainer.trainBatch(order[start:min(len(order), start+cfg.BatchSize)], opt)
+		}
+
+		metrics := TrainingEpoch{Epoch: epoch}
+		metrics.TrainLoss, metrics.TrainAccuracy = trainer.evaluate(train)
+		selectionLoss := metrics.TrainLoss
+		if len(validation) > 0 {
+			metrics.ValidationLoss, metrics.ValidationAccuracy = trainer.evaluate(validation)
+			selectionLoss = metrics.ValidationLoss
+		}
+		if math.IsNaN(selectionLoss) || math.IsInf(selectionLoss, 0) || math.IsNaN(metrics.TrainLoss) || math.IsInf(metrics.TrainLoss, 0) {
+			return fmt.Errorf("training diverged at epoch %d; try a lower learning rate", epoch)
+		}
+		model.History = append(model.History, metrics)
+		if selectionLoss < bestLoss {
+			bestLoss = selectionLoss
+			model.BestEpoch = epoch
+			trainer.store(model)
+		}
+
+		fmt.Printf("Epoch %d/%d: loss %.4f, accuracy %.3f, validation loss %.4f, validation accuracy %.3f\n",
+			epoch, cfg.Epochs, metrics.TrainLoss, metrics.TrainAccuracy, metrics.ValidationLoss, metrics.ValidationAccuracy)
+		if err := checkpoint(epoch); err != nil {
+			return err
+		}
+		report.update(epoch, cfg.Epochs)
+	}
+	if model.BestEpoch == 0 {
+		return fmt.Errorf("training diverged: no epoch had a finite loss")
+	}
+	return nil
+}
+
+// GetClassifier returns the last classifier trained for a project.
+func (a *App) GetClassifier(projectName string) (*ClassifierModel, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	return loadClassifierModel(projectDir)
+}
+
+func saveClassifierModel(projectDir string, model *ClassifierModel) error {
+	if err := os.MkdirAll(filepath.Join(projectDir, classifierDir), os.ModePerm); err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(projectDir, classifierDir, classifierModelFile), jsonData, os.ModePerm)
+}
+
+func loadClassifierModel(projectDir string) (*ClassifierModel, error) {
+	fileData, err := os.ReadFile(filepath.Join(projectDir, classifierDir, classifierModelFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading classifier: %v", err)
+	}
+	var model ClassifierModel
+	if err := json.Unmarshal(fileData, &model); err != nil {
+		return nil, fmt.Errorf("error unmarshalling classifier: %v", err)
+	}
+	return &model, nil
+}
+
+// saveClassifierCheckpoint saves the weights of the current epoch as
+// classifier/checkpoints/epoch-NNN.json, in the format of the model file, and
+// removes all but the newest checkpoints the training settings ask to keep.
+func saveClassifierCheckpoint(projectDir string, model *ClassifierModel, trainer classifierTrainer, epoch int) error {
+	keep := model.Config.Checkpoints
+	if keep == 0 {
+		return nil
+	}
+	checkpointsDir := filepath.Join(projectDir, classifierDir, classifierCheckpointsDir)
+	if err := os.MkdirAll(checkpointsDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	checkpoint := *model
+	trainer.store(&checkpoint)
+	checkpoint.CreatedAt = time.Now()
+	jsonData, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(checkpointsDir, fmt.Sprintf("epoch-%03d.json", epoch)), jsonData, os.ModePerm); err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(checkpointsDir, "epoch-*.json"))
+	if err != nil {
+		return err
+	}
+	// Sort by epoch number, as names stop sorting by epoch past 999
+	epochs := make(map[string]int, len(files))
+	for _, file := range files {
+		epochs[file], _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "epoch-"), ".json"))
+	}
+	sort.Slice(files, func(i, j int) bool { return epochs[files[i]] < epochs[files[j]] })
+	for _, file := range files[:max(0, len(files)-keep)] {
+		os.Remove(file)
+	}
+	return nil
+}
+
+// denseTrainer trains a denseNetwork on summary feature vectors.
+type denseTrainer struct {
+	network *denseNetwork
+	inputs  *mat.Dense
+	targets []int
+}
+
+// newDenseTrainer standardises the inputs with the statistics of the
+// training rows, which it records in the model.
+func newDenseTrainer(dataset *labelledDataset, train []int, cfg TrainingConfig, rng *rand.Rand, model *ClassifierModel) *denseTrainer {
+	trainVectors := make([][]float64, len(train))
+	for i, row := range train {
+		trainVectors[i] = dataset.vectors[row]
+	}
+	model.FeatureMean, model.FeatureScale = standardization(trainVectors)
+
+	sizes := []int{len(model.FeatureMean)}
+	if cfg.Model == classifierMLP {
+		sizes = append(sizes, cfg.HiddenUnits)
+	}
+	sizes = append(sizes, len(dataset.classes))
+	return &denseTrainer{
+		network: newDenseNetwork(sizes, rng),
+		inputs:  standardize(dataset.vectors, model.FeatureMean, model.FeatureScale),
+		targets: dataset.targets,
+	}
+}
+
+func (t *denseTrainer) trainBatch(rows []int, opt *optimizer) {
+	x, targets := selectRows(t.inputs, t.targets, rows)
+	gradients, _, _ := t.network.gradients(x, targets, false)
+	params, decay := t.network.parameters()
+	grads, _ := gradients.parameters()
+	opt.step(params, grads, decay)
+}
+
+func (t *denseTrainer) evaluate(rows []int) (loss, accuracy float64) {
+	x, targets := selectRows(t.inputs, t.targets, rows)
+	return t.network.evaluate(x, targets)
+}
+
+func (t *denseTrainer) store(model *ClassifierModel) {
+	model.Layers = t.network.layers()
+}
+
+// classifierPredictor computes class probabilities with a saved model.
+type classifierPredictor struct {
+	model *ClassifierModel
+	dense *denseNetwork // Set for the dense models
+	cnn   *cnnNetwork   // Set for the CNN
+}
+
+func newClassifierPredictor(model *ClassifierModel) (*classifierPredictor, error) {
+	if len(model.FeatureMean) == 0 || len(model.FeatureScale) != len(model.FeatureMean) {
+		return nil, fmt.Errorf("classifier has no input standardisation")
+	}
+	predictor := &classifierPredictor{model: model}
+	if model.Model == classifierCNN {
+		network, err := cnnNetworkFromModel(model)
+		if err != nil {
+			return nil, err
+		}
+		predictor.cnn = network
+		return predictor, nil
+	}
+
+	network, err := denseNetworkFromLayers(model.Layers)
+	if err != nil {
+		return nil, err
+	}
+	if network.inputs() != len(model.FeatureMean) {
+		return nil, fmt.Errorf("classifier takes %d inputs, standardisation has %d", network.inputs(), len(model.FeatureMean))
+	}
+	predictor.dense = network
+	return predictor, nil
+}
+
+// loadInput loads the model input of a saved spectrogram.
+func (p *classifierPredictor) loadInput(spectrogramsDir, md5Hash string) ([]float64, error) {
+	if p.cnn == nil {
+		input, err := loadClassifierVector(spectrogramsDir, md5Hash, p.model.Input)
+		if err != nil {
+			return nil, err
+		}
+		return input, p.checkInput(input, [2]int{})
+	}
+
+	input, shape, err := loadCNNInput(spectrogramsDir, md5Hash, p.model.Config.CNN.FrequencyPool)
+	if err != nil {
+		return nil, err
+	}
+	return input, p.checkInput(input, shape)
+}
+
+// spectrogramInput computes the model input of a spectrogram that was not
+// saved, extracting features with cfg for the dense models.
+func (p *classifierPredictor) spectrogramInput(spectrogram [][]float64, sampleRate int, stft STFTConfig, cfg FeatureConfig) ([]float64, error) {
+	if p.cnn == nil {
+		features := extractFeatures(dbToMagnitudes(spectrogram), sampleRate, stft.FFTSize, cfg)
+		input := classifierVector(features, p.model.Input)
+		return input, p.checkInput(input, [2]int{})
+	}
+
+	input, shape, err := cnnInput(spectrogram, p.model.Config.CNN.FrequencyPool)
+	if err != nil {
+		return nil, err
+	}
+	return input, p.checkInput(input, shape)
+}
+
+// checkInput checks that an input has the size the model takes; shape is
+// only used for the CNN.
+func (p *classifierPredictor) checkInput(input []float64, shape [2]int) error {
+	if p.cnn == nil {
+		if len(input) != len(p.model.FeatureMean) {
+			return fmt.Errorf("%d feature values, classifier takes %d", len(input), len(p.model.FeatureMean))
+		}
+		return nil
+	}
+	if shape[0] != p.model.InputShape[0] || shape[1] != p.model.InputShape[1] {
+		return fmt.Errorf("spectrogram of %d×%d, classifier takes %d×%d", shape[0], shape[1], p.model.InputShape[0], p.model.InputShape[1])
+	}
+	return nil
+}
+
+// predict returns the class probabilities of inputs as matrix rows.
+func (p *classifierPredictor) predict(inputs [][]float64) *mat.Dense {
+	if p.cnn == nil {
+		return p.dense.predict(standardize(inputs, p.model.FeatureMean, p.model.FeatureScale))
+	}
+
+	mean, scale := p.model.FeatureMean[0], p.model.FeatureScale[0]
+	features := make([][]float64, len(inputs))
+	parallelChunks(len(inputs), runtime.NumCPU(), func(_, start, end int) {
+		for i := start; i < end; i++ {
+			standardized := make([]float64, len(inputs[i]))
+			for j, v := range inputs[i] {
+				standardized[j] = (v - mean) / scale
+			}
+			features[i] = p.cnn.features(standardized, nil)
+		}
+	})
+	x := mat.NewDense(len(features), len(features[0]), nil)
+	for i, row := range features {
+		x.SetRow(i, row)
+	}
+	return p.cnn.head.predict(x)
+}
+
+// labelledDataset holds the inputs of every labelled spectrogram with the
+// index of its class.
+type labelledDataset struct {
+	md5Hashes []string
+	vectors   [][]float64
+	targets   []int
+	classes   []string
+	shape     [2]int // Frames and bins of CNN inputs
+}
+
+// loadTrainingDataset loads the inputs the model of cfg trains on: the
+// spectrograms themselves for the CNN, summary features otherwise.
+func loadTrainingDataset(projectDir string, labels map[string]string, cfg TrainingConfig) (*labelledDataset, error) {
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+	if cfg.Model != classifierCNN {
+		return loadLabelledDataset(spectrogramsDir, labels, cfg.LabelLevel, func(spectrogramsDir, md5Hash string) ([]float64, error) {
+			return loadClassifierVector(spectrogramsDir, md5Hash, cfg.Input)
+		})
+	}
+
+	var mu sync.Mutex
+	shapes := map[string][2]int{}
+	dataset, err := loadLabelledDataset(spectrogramsDir, labels, cfg.LabelLevel, func(spectrogramsDir, md5Hash string) ([]float64, error) {
+		input, shape, err := loadCNNInput(spectrogramsDir, md5Hash, cfg.CNN.FrequencyPool)
+		mu.Lock()
+		shapes[md5Hash] = shape
+		mu.Unlock()
+		return input, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	dataset.shape = shapes[dataset.md5Hashes[0]]
+	return dataset, nil
+}
+
+// loadLabelledDataset loads the input of every labelled spectrogram with
+// load. Labels are cut to level levels, so coarser classes can be trained
+// from finer annotations; spectrograms whose input fails to load or has an
+// unexpected size are skipped.
+func loadLabelledDataset(spectrogramsDir string, labels map[string]string, level int, load func(spectrogramsDir, md5Hash string) ([]float64, error)) (*labelledDataset, error) {
+	md5Hashes := make([]string, 0, len(labels))
+	for md5Hash := range labels {
+		md5Hashes = append(md5Hashes, md5Hash)
+	}
+	sort.Strings(md5Hashes)
+
+	vectors := make([][]float64, len(md5Hashes))
+	parallelRows(len(md5Hashes), func(i int) {
+		vector, err := load(spectrogramsDir, md5Hashes[i])
+		if err != nil {
+			fmt.Printf("skipping labelled spectrogram %s: %v\n", md5Hashes[i], err)
+			return
+		}
+		vectors[i] = vector
+	})
+
+	dataset := &labelledDataset{}
+	classIndex := map[string]int{}
+	dims := -1
+	for i, md5Hash := range md5Hashes {
+		if vectors[i] == nil {
+			continue
+		}
+		if dims < 0 {
+			dims = len(vectors[i])
+		}
+		if len(vectors[i]) != dims {
+			fmt.Printf("skipping labelled spectrogram %s: %d values, expected %d\n", md5Hash, len(vectors[i]), dims)
+			continue
+		}
+		class := truncateLabel(labels[md5Hash], level)
+		if _, ok := classIndex[class]; !ok {
+			classIndex[class] = len(dataset.classes)
+			dataset.classes = append(dataset.classes, class)
+		}
+		dataset.md5Hashes = append(dataset.md5Hashes, md5Hash)
+		dataset.vectors = append(dataset.vectors, vectors[i])
+		dataset.targets = append(dataset.targets, classIndex[class])
+	}
+
+	if len(dataset.classes) < 2 {
+		return nil, fmt.Errorf("training needs labelled spectrograms of at least two classes, found %d", len(dataset.classes))
+	}
+
+	// Number classes in sorted order so models trained on the same labels agree.
+	sorted := append([]string(nil), dataset.classes...)
+	sort.Strings(sorted)
+	remap := make([]int, len(sorted))
+	for i, class := range sorted {
+		remap[classIndex[class]] = i
+	}
+	for i := range dataset.targets {
+		dataset.targets[i] = remap[dataset.targets[i]]
+	}
+	dataset.classes = sorted
+	return dataset, nil
+}
+
+// loadClassifierVector returns the summary feature vector of a spectrogram.
+func loadClassifierVector(spectrogramsDir, md5Hash, input string) ([]float64, error) {
+	fileData, err := os.ReadFile(featuresFilePath(spectrogramsDir, md5Hash))
+	if err != nil {
+		return nil, fmt.Errorf("no features for %s, run feature extraction first: %v", md5Hash, err)
+	}
+	var features AudioFeatures
+	if err := json.Unmarshal(fileData, &features); err != nil {
+		return nil, err
+	}
+	return classifierVector(&features, input), nil
+}
+
+// classifierVector returns the summary vector of features a dense model
+// takes as input.
+func classifierVector(features *AudioFeatures, input string) []float64 {
+	if input == classifierInputMel {
+		return features.melSummaryVector()
+	}
+	return features.summaryVector()
+}
+
+// truncateLabel keeps the first level levels of a label path; level 0 keeps
+// all of them.
+func truncateLabel(label string, level int) string {
+	parts := strings.Split(label, labelSeparator)
+	if level > 0 && len(parts) > level {
+		parts = parts[:level]
+	}
+	return strings.Join(parts, labelSeparator)
+}
+
+// stratifiedSplit holds out about fraction of the rows of every class,
+// keeping at least one row of each class for training. Classes without rows
+// are skipped.
+func stratifiedSplit(targets []int, classes int, fraction float64, rng *rand.Rand) (train, validation []int) {
+	byClass := make([][]int, classes)
+	for row, target := range targets {
+		byClass[target] = append(byClass[target], row)
+	}
+	for _, rows := range byClass {
+		if len(rows) == 0 {
+			continue
+		}
+		rng.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+		held := min(int(math.Round(fraction*float64(len(rows)))), len(rows)-1)
+		validation = append(validation, rows[:held]...)
+		train = append(train, rows[held:]...)
+	}
+	sort.Ints(train)
+	sort.Ints(validation)
+	return train, validation
+}
+
+// standardization returns the per-column mean and standard deviation of
+// vectors. Constant columns get a scale of 1.
+func standardization(vectors [][]float64) (mean, scale []float64) {
+	dims := len(vectors[0])
+	mean = make([]float64, dims)
+	scale = make([]float64, dims)
+	for _, vector := range vectors {
+		for i, v := range vector {
+			mean[i] += v
+		}
+	}
+	for i := range mean {
+		mean[i] /= float64(len(vectors))
+	}
+	for _, vector := range vectors {
+		for i, v := range vector {
+			scale[i] += (v - mean[i]) * (v - mean[i])
+		}
+	}
+	for i := range scale {
+		scale[i] = math.Sqrt(scale[i] / float64(len(vectors)))
+		if scale[i] < 1e-12 {
+			scale[i] = 1
+		}
+	}
+	return mean, scale
+}
+
+// standardize returns vectors as matrix rows with mean removed and divided
+// by scale.
+func standardize(vectors [][]float64, mean, scale []float64) *mat.Dense {
+	data := mat.NewDense(len(vectors), len(mean), nil)
+	for i, vector := range vectors {
+		row := data.RawRowView(i)
+		for j, v := range vector {
+			row[j] = (v - mean[j]) / scale[j]
+		}
+	}
+	return data
+}
+
+func selectRows(data *mat.Dense, targets []int, rows []int) (*mat.Dense, []int) {
+	_, cols := data.Dims()
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	selected := mat.NewDense(len(rows), cols, nil)
+	selectedTargets := make([]int, len(rows))
+	for i, row := range rows {
+		selected.SetRow(i, data.RawRowView(row))
+		selectedTargets[i] = targets[row]
+	}
+	return selected, selectedTargets
+}
+
+// denseNetwork is a stack of fully connected layers with ReLU between them
+// and a softmax output.
+type denseNetwork struct {
+	weights []*mat.Dense // [output][input] per layer
+	biases  [][]float64
+}
+
+// newDenseNetwork creates a network with the given layer sizes, input first,
+// using He initialisation.
+func newDenseNetwork(sizes []int, rng *rand.Rand) *denseNetwork {
+	network := &denseNetwork{}
+	for l := 1; l < len(sizes); l++ {
+		std := math.Sqrt(2 / float64(sizes[l-1]))
+		weights := mat.NewDense(sizes[l], sizes[l-1], nil)
+		raw := weights.RawMatrix().Data
+		for i := range raw {
+			raw[i] = rng.NormFloat64() * std
+		}
+		network.weights = append(network.weights, weights)
+		network.biases = append(network.biases, make([]float64, sizes[l]))
+	}
+	return network
+}
+
+// denseNetworkFromLayers rebuilds a network from saved layers, checking that
+// their sizes chain.
+func denseNetworkFromLayers(layers []DenseLayer) (*denseNetwork, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("classifier has no layers")
+	}
+	network := &denseNetwork{}
+	for l, layer := range layers {
+		if len(layer.Weights) == 0 || len(layer.Bias) != len(layer.Weights) {
+			return nil, fmt.Errorf("layer %d has %d weight rows and %d biases", l, len(layer.Weights), len(layer.Bias))
+		}
+		inputs := len(layer.Weights[0])
+		if l > 0 && inputs != len(layers[l-1].Weights) {
+			return nil, fmt.Errorf("layer %d takes %d inputs, previous layer has %d outputs", l, inputs, len(layers[l-1].Weights))
+		}
+		weights := mat.NewDense(len(layer.Weights), inputs, nil)
+		for i, row := range layer.Weights {
+			if len(row) != inputs {
+				return nil, fmt.Errorf("layer %d has ragged weights", l)
+			}
+			weights.SetRow(i, row)
+		}
+		network.weights = append(network.weights, weights)
+		network.biases = append(network.biases, append([]float64(nil), layer.Bias...))
+	}
+	return network, nil
+}
+
+// inputs returns the input size of the network.
+func (n *denseNetwork) inputs() int {
+	_, cols := n.weights[0].Dims()
+	return cols
+}
+
+func (n *denseNetwork) layers() []DenseLayer {
+	layers := make([]DenseLayer, len(n.weights))
+	for l, weights := range n.weights {
+		rows, _ := weights.Dims()
+		layers[l].Weights = make([][]float64, rows)
+		for i := range layers[l].Weights {
+			layers[l].Weights[i] = append([]float64(nil), weights.RawRowView(i)...)
+		}
+		layers[l].Bias = append([]float64(nil), n.biases[l]...)
+	}
+	return layers
+}
+
+func (n *denseNetwork) zeroLike() *denseNetwork {
+	zero := &denseNetwork{}
+	for l, weights := range n.weights {
+		rows, cols := weights.Dims()
+		zero.weights = append(zero.weights, mat.NewDense(rows, cols, nil))
+		zero.biases = append(zero.biases, make([]float64, len(n.biases[l])))
+	}
+	return zero
+}
+
+// forward returns the activations of every layer for the rows of x, starting
+// with x itself and ending with the class probabilities.
+func (n *denseNetwork) forward(x *mat.Dense) []*mat.Dense {
+	activations := []*mat.Dense{x}
+	for l, weights := range n.weights {
+		rows, _ := activations[l].Dims()
+		outputs, _ := weights.Dims()
+		z := mat.NewDense(rows, outputs, nil)
+		z.Mul(activations[l], weights.T())
+		for i := 0; i < rows; i++ {
+			row := z.RawRowView(i)
+			for j := range row {
+				row[j] += n.biases[l][j]
+			}
+			if l < len(n.weights)-1 {
+				for j, v := range row {
+					row[j] = max(v, 0)
+				}
+			} else {
+				softmax(row)
+			}
+		}
+		activations = append(activations, z)
+	}
+	return activations
+}
+
+// predict returns the class probabilities of the rows of x.
+func (n *denseNetwork) predict(x *mat.Dense) *mat.Dense {
+	activations := n.forward(x)
+	return activations[len(activations)-1]
+}
+
+// gradients returns the gradient of the mean cross-entropy loss of a batch
+// with respect to every weight and bias, and the loss itself. With withInput
+// it also returns the gradient with respect to x, for layers feeding the
+// network.
+func (n *denseNetwork) gradients(x *mat.Dense, targets []int, withInput bool) (*denseNetwork, *mat.Dense, float64) {
+	activations := n.forward(x)
+	rows, _ := x.Dims()
+	probabilities := activations[len(activations)-1]
+
+	delta := mat.DenseCopyOf(probabilities)
+	loss := 0.0
+	for i, target := range targets {
+		loss -= math.Log(probabilities.At(i, target) + 1e-12)
+		delta.Set(i, target, delta.At(i, target)-1)
+	}
+	delta.Scale(1/float64(rows), delta)
+
+	gradients := n.zeroLike()
+	for l := len(n.weights) - 1; l >= 0; l-- {
+		gradients.weights[l].Mul(delta.T(), activations[l])
+		for i := 0; i < rows; i++ {
+			for j, v := range delta.RawRowView(i) {
+				gradients.biases[l][j] += v
+			}
+		}
+		if l == 0 && !withInput {
+			break
+		}
+		_, inputs := n.weights[l].Dims()
+		previous := mat.NewDense(rows, inputs, nil)
+		previous.Mul(delta, n.weights[l])
+		if l == 0 {
+			return gradients, previous, loss / float64(rows)
+		}
+		// ReLU passes gradients only where it was active
+		activated := activations[l].RawMatrix().Data
+		raw := previous.RawMatrix().Data
+		for i, a := range activated {
+			if a <= 0 {
+				raw[i] = 0
+			}
+		}
+		delta = previous
+	}
+	return gradients, nil, loss / float64(rows)
+}
+
+// parameters returns the weights and biases of every layer, and whether
+// each takes weight decay.
+func (n *denseNetwork) parameters() ([][]float64, []bool) {
+	var params [][]float64
+	var decay []bool
+	for l, weights := range n.weights {
+		params = append(params, weights.RawMatrix().Data, n.biases[l])
+		decay = append(decay, true, false)
+	}
+	return params, decay
+}
+
+// evaluate returns the mean cross-entropy loss and the accuracy on x.
+func (n *denseNetwork) evaluate(x *mat.Dense, targets []int) (loss, accuracy float64) {
+	probabilities := n.predict(x)
+	correct := 0
+	for i, target := range targets {
+		row := probabilities.RawRowView(i)
+		loss -= math.Log(row[target] + 1e-12)
+		if argmax(row) == target {
+			correct++
+		}
+	}
+	return loss / float64(len(targets)), float64(correct) / float64(len(targets))
+}
+
+// optimizer updates parameters from their gradients with SGD with momentum
+// or with Adam, adding L2 weight decay where asked.
+type optimizer struct {
+	method       string
+	learningRate float64
+	momentum     float64
+	l2           float64
+	steps        int
+	first        [][]float64 // Momentum, or Adam's first moment
+	second       [][]float64 // Adam's second moment
+}
+
+func newOptimizer(cfg TrainingConfig) *optimizer {
+	return &optimizer{method: cfg.Optimizer, learningRate: cfg.LearningRate, momentum: cfg.Momentum, l2: cfg.L2}
+}
+
+func (o *optimizer) step(params, grads [][]float64, decay []bool) {
+	if o.first == nil {
+		for _, p := range params {
+			o.first = append(o.first, make([]float64, len(p)))
+			o.second = append(o.second, make([]float64, len(p)))
+		}
+	}
+	o.steps++
+	correction1 := 1 - math.Pow(adamBeta1, float64(o.steps))
+	correction2 := 1 - math.Pow(adamBeta2, float64(o.steps))
+
+	for k, p := range params {
+		first, second := o.first[k], o.second[k]
+		for i, g := range grads[k] {
+			if decay[k] {
+				g += o.l2 * p[i]
+			}
+			if o.method == optimizerAdam {
+				first[i] = adamBeta1*first[i] + (1-adamBeta1)*g
+				second[i] = adamBeta2*second[i] + (1-adamBeta2)*g*g
+				p[i] -= o.learningRate * (first[i] / correction1) / (math.Sqrt(second[i]/correction2) + adamEpsilon)
+			} else {
+				first[i] = o.momentum*first[i] - o.learningRate*g
+				p[i] += first[i]
+			}
+		}
+	}
+}
+
+// softmax turns scores into probabilities in place.
+func softmax(scores []float64) {
+	highest := scores[argmax(scores)]
+	sum := 0.0
+	for i, v := range scores {
+		scores[i] = math.Exp(v - highest)
+		sum += scores[i]
+	}
+	for i := range scores {
+		scores[i] /= sum
+	}
+}
+
+func argmax(values []float64) int {
+	best := 0
+	for i, v := range values {
+		if v > values[best] {
+			best = i
+		}
+	}
+	return best
+}
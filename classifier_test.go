@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// gradientStep is the step of the central differences the analytic
+// gradients are checked against.
+const gradientStep = 1e-5
+
+// checkGradient compares an analytic gradient with the central difference of
+// loss around values[i], for every i.
+func checkGradient(t *testing.T, name string, values, analytic []float64, loss func() float64) {
+	t.Helper()
+	for i := range values {
+		saved := values[i]
+		values[i] = saved + gradientStep
+		plus := loss()
+		values[i] = saved - gradientStep
+		minus := loss()
+		values[i] = saved
+
+		numeric := (plus - minus) / (2 * gradientStep)
+		if math.Abs(numeric-analytic[i]) > 1e-6+1e-4*math.Abs(numeric) {
+			t.Errorf("%s[%d]: analytic gradient %g, numeric %g", name, i, analytic[i], numeric)
+		}
+	}
+}
+
+func TestDenseNetworkGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	network := newDenseNetwork([]int{4, 5, 3}, rng)
+	for _, bias := range network.biases {
+		for i := range bias {
+			bias[i] = rng.NormFloat64() * 0.1
+		}
+	}
+	x := mat.NewDense(6, 4, nil)
+	raw := x.RawMatrix().Data
+	for i := range raw {
+		raw[i] = rng.NormFloat64()
+	}
+	targets := []int{0, 1, 2, 0, 1, 2}
+
+	gradients, inputGradients, loss := network.gradients(x, targets, true)
+	lossAt := func() float64 {
+		loss, _ := network.evaluate(x, targets)
+		return loss
+	}
+	if want := lossAt(); math.Abs(loss-want) > 1e-9 {
+		t.Fatalf("loss %g, want %g", loss, want)
+	}
+
+	params, _ := network.parameters()
+	grads, _ := gradients.parameters()
+	for p := range params {
+		checkGradient(t, "parameter", params[p], grads[p], lossAt)
+	}
+	checkGradient(t, "input", raw, inputGradients.RawMatrix().Data, lossAt)
+}
+
+func TestStratifiedSplitSkipsEmptyClasses(t *testing.T) {
+	// Class 1 has no rows and class 3 a single one
+	targets := []int{0, 0, 0, 2, 2, 2, 2, 3}
+	train, validation := stratifiedSplit(targets, 4, 0.5, rand.New(rand.NewSource(1)))
+
+	seen := make([]int, len(targets))
+	trained := make([]int, 4)
+	for _, row := range train {
+		seen[row]++
+		trained[targets[row]]++
+	}
+	for _, row := range validation {
+		seen[row]++
+	}
+	for row, count := range seen {
+		if count != 1 {
+			t.Errorf("row %d is in %d splits, want 1", row, count)
+		}
+	}
+	for _, class := range []int{0, 2, 3} {
+		if trained[class] == 0 {
+			t.Errorf("class %d has no training rows", class)
+		}
+	}
+	if len(validation) != 4 {
+		t.Errorf("%d validation rows, want 4", len(validation))
+	}
+}
+
+// storeOnlyTrainer stands in for a trainer where only the weights are saved.
+type storeOnlyTrainer struct{ classifierTrainer }
+
+func (storeOnlyTrainer) store(model *ClassifierModel) {}
+
+func TestSaveClassifierCheckpointPrunesByEpoch(t *testing.T) {
+	projectDir := t.TempDir()
+	model := &ClassifierModel{Config: TrainingConfig{Checkpoints: 3}}
+	for _, epoch := range []int{998, 999, 1000, 1001} {
+		if err := saveClassifierCheckpoint(projectDir, model, storeOnlyTrainer{}, epoch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(projectDir, classifierDir, classifierCheckpointsDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, filepath.Base(file))
+	}
+	if want := []string{"epoch-1000.json", "epoch-1001.json", "epoch-999.json"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("checkpoints %v, want %v", names, want)
+	}
+
+	model.Config.Checkpoints = 0
+	if err := saveClassifierCheckpoint(projectDir, model, storeOnlyTrainer{}, 1002); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, classifierDir, classifierCheckpointsDir, "epoch-1002.json")); !os.IsNotExist(err) {
+		t.Errorf("checkpoint saved although none are kept: %v", err)
+	}
+}
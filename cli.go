@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Exit codes of headless commands.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2 // Bad command line or project name
+	exitNotFound = 3 // The project does not exist
+	exitConflict = 4 // The project exists already or is busy
+)
+
+// cliCommand is a headless command. run writes its result to out as JSON,
+// or as the format the command was asked for.
+type cliCommand struct {
+	usage string
+	about string
+	run   func(app *App, args []string, out io.Writer) error
+}
+
+var cliCommands map[string]cliCommand
+
+func init() {
+	// Assigned in init because help refers to the table itself
+	cliCommands = map[string]cliCommand{
+		"help":         {"help", "List the commands", cliHelp},
+		"project":      {"project create|list|show|config|rename|delete [<project>] [<new name>|<project type>]", "Manage projects", cliProject},
+		"import":       {"import <project> <directory>", "Select the directory whose audio files a project processes", cliImport},
+		"convert":      {"convert <project>", "Convert the audio files of the selected directory to WAV", cliConvert},
+		"spectrograms": {"spectrograms <project>", "Chunk the WAV files and compute their spectrograms", cliSpectrograms},
+		"features":     {"features <project>", "Extract audio features from the spectrograms", cliFeatures},
+		"cluster":      {"cluster [-k-range min:max] [-k k] <project>", "Find the optimal K and cluster the spectrograms; -k-range is saved in the pipeline settings", cliCluster},
+		"train":        {"train <project>", "Train the classifier on the labelled spectrograms", cliTrain},
+		"evaluate":     {"evaluate <project>", "Evaluate the classifier", cliEvaluate},
+		"predict":      {"predict [-format json|csv] [-table chunks|timeline] <project> <audio file>...", "Classify audio files with the classifier", cliPredict},
+	}
+}
+
+// usageError marks a command line that could not be parsed.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+// runCLI runs the headless command named by args[0] and returns its exit
+// code. ok is false when args are empty or start with a flag, so the GUI or
+// server should start instead; any other unknown first argument is a usage
+// error. While the command runs, progress output goes to stderr so that
+// stdout only carries the result; failures are written to stderr as a JSON
+// error body.
+func runCLI(app *App, args []string) (code int, ok bool) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return exitOK, false
+	}
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		writeJSON(os.Stderr, apiError{Error: fmt.Sprintf("unknown command: %s; run help to list the commands", args[0])})
+		return exitUsage, true
+	}
+
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	err := command.run(app, args[1:], stdout)
+	code = exitError
+	var usage usageError
+	switch {
+	case err == nil:
+		return exitOK, true
+	case errors.As(err, &usage):
+		code = exitUsage
+		err = fmt.Errorf("%v\nusage: %s", err, command.usage)
+	case errors.Is(err, errInvalidProjectName), errors.Is(err, errInvalidProjectType):
+		code = exitUsage
+	case errors.Is(err, errProjectNotFound):
+		code = exitNotFound
+	case errors.Is(err, errProjectExists), errors.Is(err, errProjectBusy):
+		code = exitConflict
+	}
+	writeJSON(os.Stderr, apiError{Error: err.Error()})
+	return code, true
+}
+
+// writeJSON writes v to out as indented JSON.
+func writeJSON(out io.Writer, v any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+// parseCLIArgs parses the flags of a command and checks that at least
+// positional arguments follow them.
+func parseCLIArgs(flags *flag.FlagSet, args []string, positional int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() < positional {
+		return usageError{fmt.Errorf("%s expects at least %d arguments, got %d", flags.Name(), positional, flags.NArg())}
+	}
+	return nil
+}
+
+// cliProjectArg parses a command that only takes a project and checks that
+// the project exists.
+func cliProjectArg(app *App, name string, args []string) (string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := parseCLIArgs(flags, args, 1); err != nil {
+		return "", err
+	}
+	return flags.Arg(0), requireProject(app, flags.Arg(0))
+}
+
+func requireProject(app *App, projectName string) error {
+	projectDir, err := app.workspace.projectDir(projectName)
+	if err != nil {
+		return err
+	}
+	if !projectExists(projectDir) {
+		return fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	return nil
+}
+
+func cliHelp(app *App, args []string, out io.Writer) error {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type commandHelp struct {
+		Usage string `json:"usage"`
+		About string `json:"about"`
+	}
+	help := make([]commandHelp, len(names))
+	for i, name := range names {
+		help[i] = commandHelp{Usage: cliCommands[name].usage, About: cliCommands[name].about}
+	}
+	return writeJSON(out, help)
+}
+
+func cliProject(app *App, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("project", flag.ContinueOnError)
+	if err := parseCLIArgs(flags, args, 1); err != nil {
+		return err
+	}
+	action := flags.Arg(0)
+	if action == "list" {
+		projects, err := app.ListProjects()
+		if os.IsNotExist(err) {
+			projects, err = []string{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, projects)
+	}
+
+	positional := 2
+	switch action {
+	case "create", "show", "config", "delete":
+	case "rename":
+		positional = 3
+	default:
+		return usageError{fmt.Errorf("unknown project action: %s", action)}
+	}
+	if flags.NArg() < positional {
+		return usageError{fmt.Errorf("project %s expects %d arguments, got %d", action, positional-1, flags.NArg()-1)}
+	}
+	projectName := flags.Arg(1)
+
+	switch action {
+	case "create":
+		// An optional third argument sets the project type
+		projectDir, err := app.CreateProjectWithType(projectName, flags.Arg(2))
+		if err != nil {
+			return err
+		}
+		info, err := loadProjectInfo(projectDir)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, map[string]string{"name": info.Name, "display_name": info.DisplayName, "path": projectDir})
+	case "show":
+		if err := requireProject(app, projectName); err != nil {
+			return err
+		}
+		projectData, err := app.GetProjectData(projectName)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, projectData)
+	case "config":
+		config, err := app.GetProjectConfig(projectName)
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, config)
+	case "rename":
+		info, err := app.RenameProject(projectName, flags.Arg(2))
+		if err != nil {
+			return err
+		}
+		return writeJSON(out, info)
+	case "delete":
+		if err := app.DeleteProject(projectName); err != nil {
+			return err
+		}
+		return writeJSON(out, map[string]string{"deleted": projectName})
+	}
+	return nil
+}
+
+func cliImport(app *App, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := parseCLIArgs(flags, args, 2); err != nil {
+		return err
+	}
+	projectName := flags.Arg(0)
+	if err := requireProject(app, projectName); err != nil {
+		return err
+	}
+	directory, err := filepath.Abs(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+		return fmt.Errorf("not a directory: %q", directory)
+	}
+
+	if err := app.SaveSelectedDirectory(directory, projectName); err != nil {
+		return err
+	}
+	projectData, err := app.GetProjectData(projectName)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, projectData)
+}
+
+func cliConvert(app *App, args []string, out io.Writer) error {
+	projectName, err := cliProjectArg(app, "convert", args)
+	if err != nil {
+		return err
+	}
+	if err := app.ConvertFilesToWAV(projectName); err != nil {
+		return err
+	}
+	manifest, err := app.GetPipelineManifest(projectName)
+	if err != nil {
+		return err
+	}
+	converted := 0
+	for _, file := range manifest.Files {
+		if _, ok := file.Stages[stageConverted]; ok {
+			converted++
+		}
+	}
+	return writeJSON(out, map[string]any{"project": projectName, "wav_files": converted})
+}
+
+func cliSpectrograms(app *App, args []string, out io.Writer) error {
+	projectName, err := cliProjectArg(app, "spectrograms", args)
+	if err != nil {
+		return err
+	}
+	md5Hashes, err := app.ProcessAudioChunksAndSpectrograms(projectName)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]any{"project": projectName, "spectrograms": len(md5Hashes)})
+}
+
+func cliFeatures(app *App, args []string, out io.Writer) error {
+	projectName, err := cliProjectArg(app, "features", args)
+	if err != nil {
+		return err
+	}
+	md5Hashes, err := app.ExtractAudioFeatures(projectName)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, map[string]any{"project": projectName, "features": len(md5Hashes)})
+}
+
+func cliCluster(app *App, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("cluster", flag.ContinueOnError)
+	kRange := flags.String("k-range", "", "range of K to search, as min:max")
+	k := flags.Int("k", 0, "number of clusters; skips the search for the optimal K")
+	if err := parseCLIArgs(flags, args, 1); err != nil {
+		return err
+	}
+	if *k < 0 {
+		return usageError{fmt.Errorf("k must not be negative")}
+	}
+	projectName := flags.Arg(0)
+	if err := requireProject(app, projectName); err != nil {
+		return err
+	}
+
+	if *kRange != "" {
+		minK, maxK, err := parseKRange(*kRange)
+		if err != nil {
+			return usageError{err}
+		}
+		settings, err := app.GetPipelineSettings(projectName)
+		if err != nil {
+			return err
+		}
+		settings.Clustering.MinK, settings.Clustering.MaxK = minK, maxK
+		if err := app.SavePipelineSettings(projectName, *settings); err != nil {
+			return err
+		}
+	}
+
+	result := map[string]any{"project": projectName}
+	if *k == 0 {
+		optimalK, err := app.CalculateOptimalClusters(projectName)
+		if err != nil {
+			return err
+		}
+		result["optimal_k"] = optimalK
+	}
+	assignments, err := app.AssignClusters(projectName, *k)
+	if err != nil {
+		return err
+	}
+	result["assignments"] = assignments
+	return writeJSON(out, result)
+}
+
+// parseKRange parses a K range written as min:max.
+func parseKRange(s string) (minK, maxK int, err error) {
+	low, high, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("K range must be min:max, got %q", s)
+	}
+	if minK, err = strconv.Atoi(low); err != nil {
+		return 0, 0, fmt.Errorf("invalid minimum K: %v", err)
+	}
+	if maxK, err = strconv.Atoi(high); err != nil {
+		return 0, 0, fmt.Errorf("invalid maximum K: %v", err)
+	}
+	if minK < 1 || maxK < minK {
+		return 0, 0, fmt.Errorf("invalid K range %d-%d", minK, maxK)
+	}
+	return minK, maxK, nil
+}
+
+func cliTrain(app *App, args []string, out io.Writer) error {
+	projectName, err := cliProjectArg(app, "train", args)
+	if err != nil {
+		return err
+	}
+	model, err := app.TrainClassifier(projectName)
+	if err != nil {
+		return err
+	}
+	// Leave out the weights, which scripts have no use for
+	return writeJSON(out, map[string]any{
+		"project":      projectName,
+		"model":        model.Model,
+		"classes":      model.Classes,
+		"class_counts": model.ClassCounts,
+		"best_epoch":   model.BestEpoch,
+		"history":      model.History,
+	})
+}
+
+func cliEvaluate(app *App, args []string, out io.Writer) error {
+	projectName, err := cliProjectArg(app, "evaluate", args)
+	if err != nil {
+		return err
+	}
+	report, err := app.EvaluateClassifier(projectName)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, report)
+}
+
+// cliPredict classifies audio files with the saved classifier of a project.
+func cliPredict(app *App, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("predict", flag.ContinueOnError)
+	format := flags.String("format", predictionFormatJSON, "output format: json or csv")
+	table := flags.String("table", predictionTableChunks, "CSV table: chunks or timeline")
+	if err := parseCLIArgs(flags, args, 2); err != nil {
+		return err
+	}
+	if *format != predictionFormatJSON && *format != predictionFormatCSV {
+		return usageError{fmt.Errorf("unknown prediction format: %s", *format)}
+	}
+	if *table != predictionTableChunks && *table != predictionTableTimeline {
+		return usageError{fmt.Errorf("unknown prediction table: %s", *table)}
+	}
+
+	result, err := app.ClassifyAudioFiles(flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if *format == predictionFormatJSON {
+		return writeJSON(out, result)
+	}
+	data, err := result.csv(*table)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, data)
+	return err
+}
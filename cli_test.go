@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runTestCLI runs a headless command and returns its exit code with what it
+// wrote to stdout and stderr.
+func runTestCLI(t *testing.T, app *App, args ...string) (int, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+
+	savedStdout, savedStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout, stderr
+	code, ok := runCLI(app, args)
+	os.Stdout, os.Stderr = savedStdout, savedStderr
+	if !ok {
+		t.Fatalf("%v did not run as a command", args)
+	}
+
+	outData, _ := os.ReadFile(stdout.Name())
+	errData, _ := os.ReadFile(stderr.Name())
+	return code, string(outData), string(errData)
+}
+
+func TestRunCLIExitCodes(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"help"}, exitOK},
+		{[]string{"project", "list"}, exitOK},
+		{[]string{"project", "show", "birds"}, exitError}, // No directory selected yet
+		{[]string{"project", "create", "frogs"}, exitOK},
+		{[]string{"project", "create", "frogs"}, exitConflict},
+		{[]string{"project", "create", "../frogs"}, exitUsage},
+		{[]string{"project", "show", "owls"}, exitNotFound},
+		{[]string{"project", "archive", "birds"}, exitUsage},
+		{[]string{"project"}, exitUsage},
+		{[]string{"import", "birds"}, exitUsage},
+		{[]string{"import", "birds", filepath.Join(t.TempDir(), "missing")}, exitError},
+		{[]string{"convert", "owls"}, exitNotFound},
+		{[]string{"cluster", "-k-range", "5", "birds"}, exitUsage},
+		{[]string{"cluster", "-k", "-1", "birds"}, exitUsage},
+		{[]string{"predict", "-format", "xml", "birds", "a.wav"}, exitUsage},
+		{[]string{"frobnicate"}, exitUsage},
+	}
+	for _, tt := range tests {
+		code, stdout, stderr := runTestCLI(t, app, tt.args...)
+		if code != tt.want {
+			t.Errorf("%v: exit code %d, want %d (stderr %s)", tt.args, code, tt.want, stderr)
+		}
+		if code == exitOK {
+			if !json.Valid([]byte(stdout)) {
+				t.Errorf("%v: stdout is not JSON: %s", tt.args, stdout)
+			}
+			continue
+		}
+		var body apiError
+		if err := json.Unmarshal([]byte(stderr), &body); err != nil || body.Error == "" {
+			t.Errorf("%v: stderr is not a JSON error: %s", tt.args, stderr)
+		}
+	}
+}
+
+func TestRunCLILeavesFlagsToTheGUI(t *testing.T) {
+	for _, args := range [][]string{nil, {"-home", "x"}, {"--help"}} {
+		if _, ok := runCLI(nil, args); ok {
+			t.Errorf("%v ran as a command", args)
+		}
+	}
+}
+
+func TestRunCLIImport(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	sourceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(sourceDir, "a.wav"), encodeWAV(make([]float64, 100), 8000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runTestCLI(t, app, "import", "birds", sourceDir)
+	if code != exitOK {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "a.wav") {
+		t.Errorf("project data does not list a.wav: %s", stdout)
+	}
+}
+
+func TestParseKRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		minK, maxK int
+		valid      bool
+	}{
+		{"2:10", 2, 10, true},
+		{"3:3", 3, 3, true},
+		{"10", 0, 0, false},
+		{"0:5", 0, 0, false},
+		{"5:2", 0, 0, false},
+		{"a:5", 0, 0, false},
+		{"2:", 0, 0, false},
+	}
+	for _, tt := range tests {
+		minK, maxK, err := parseKRange(tt.s)
+		if (err == nil) != tt.valid || minK != tt.minK || maxK != tt.maxK {
+			t.Errorf("parseKRange(%q) = %d, %d, %v; want %d, %d, valid %t", tt.s, minK, maxK, err, tt.minK, tt.maxK, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	clusterAssignmentsFile = "cluster_assignments.json"
+	clusterCentroidsFile   = "cluster_centroids.json"
+)
+
+// ClusterAssignment records which cluster a spectrogram belongs to.
+type ClusterAssignment struct {
+	MD5Hash  string  `json:"md5_hash"`
+	Cluster  int     `json:"cluster"`  // noiseCluster (-1) for outliers of density clustering
+	Distance float64 `json:"distance"` // Distance to the cluster centroid, 0 for outliers
+}
+
+// ClusterAssignments is the persisted result of a clustering run.
+type ClusterAssignments struct {
+	Algorithm    string              `json:"algorithm"`
+	K            int                 `json:"k"`
+	WCSS         float64             `json:"wcss"` // Sum of squared distances to the centroids
+	Iterations   int                 `json:"iterations,omitempty"`
+	Converged    bool                `json:"converged,omitempty"`
+	Noise        int                 `json:"noise"` // Spectrograms not assigned to any cluster
+	ClusterSizes []int               `json:"cluster_sizes"`
+	CreatedAt    time.Time           `json:"created_at"`
+	Assignments  []ClusterAssignment `json:"assignments"`
+}
+
+// ClusterCentroids holds the centroid vectors of a clustering run. They are
+// stored apart from the assignments because they can be very large.
+type ClusterCentroids struct {
+	K         int         `json:"k"`
+	Centroids [][]float64 `json:"centroids"`
+}
+
+// AssignClusters runs the project's clustering algorithm and saves the
+// cluster of every spectrogram along with the centroids. k is the number of
+// clusters for k-means and for agglomerative clustering without a distance
+// threshold; a k of 0 uses the optimal K from the last
+// CalculateOptimalClusters run. Density clustering finds the number of
+// clusters itself and ignores k.
+func (a *App) AssignClusters(projectName string, k int) (*ClusterAssignments, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.Clustering.validate(); err != nil {
+		return nil, fmt.Errorf("invalid clustering settings: %v", err)
+	}
+	algorithm := settings.Clustering.Algorithm
+
+	needsK := algorithm == clusteringKMeans || (algorithm == clusteringAgglomerative && settings.Clustering.Agglomerative.DistanceThreshold == 0)
+	if needsK && k == 0 {
+		elbowResult, err := loadElbowResults(projectDir)
+		if err != nil {
+			return nil, fmt.Errorf("no K given and no elbow results available: %v", err)
+		}
+		k = elbowResult.OptimalK
+	}
+	if needsK && k < 1 {
+		return nil, fmt.Errorf("invalid number of clusters: %d", k)
+	}
+
+	files, err := listSpectrogramFiles(spectrogramsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spectrogram JSON files: %v", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no spectrogram files found in %s", spectrogramsDir)
+	}
+
+	md5Hashes, dataMatrix, err := loadReducedClusteringData(projectDir, files, settings.Clustering)
+	if err != nil {
+		return nil, err
+	}
+	if rows, _ := dataMatrix.Dims(); needsK && k > rows {
+		return nil, fmt.Errorf("cannot form %d clusters from %d spectrograms", k, rows)
+	}
+
+	result := &ClusterAssignments{Algorithm: algorithm, CreatedAt: time.Now()}
+	var labels []int
+	var centroids *mat.Dense
+
+	switch algorithm {
+	case clusteringKMeans:
+		kmeans, err := kMeans(context.Background(), dataMatrix, k, settings.Clustering.KMeans)
+		if err != nil {
+			return nil, fmt.Errorf("error running k-means: %v", err)
+		}
+		labels, centroids = kmeans.Labels, kmeans.Centroids
+		result.Iterations = kmeans.Iterations
+		result.Converged = kmeans.Converged
+	case clusteringDBSCAN:
+		labels, _ = dbscan(dataMatrix, settings.Clustering.DBSCAN)
+	case clusteringHDBSCAN:
+		labels = hdbscan(dataMatrix, settings.Clustering.HDBSCAN)
+	case clusteringAgglomerative:
+		cfg := settings.Clustering.Agglomerative
+		nodes, err := agglomerative(dataMatrix, cfg.Linkage)
+		if err != nil {
+			return nil, fmt.Errorf("error running agglomerative clustering: %v", err)
+		}
+		if err := saveClusterDendrogram(projectDir, cfg.Linkage, md5Hashes, nodes); err != nil {
+			return nil, fmt.Errorf("error saving cluster dendrogram: %v", err)
+		}
+		labels = cutTree(nodes, len(md5Hashes), k, cfg.DistanceThreshold)
+	}
+	if centroids == nil {
+		centroids = clusterMeans(dataMatrix, labels)
+	}
+
+	result.K, _ = centroids.Dims()
+	result.ClusterSizes = make([]int, result.K)
+	result.Assignments = make([]ClusterAssignment, len(md5Hashes))
+	for i, md5Hash := range md5Hashes {
+		assignment := ClusterAssignment{MD5Hash: md5Hash, Cluster: labels[i]}
+		if labels[i] == noiseCluster {
+			result.Noise++
+		} else {
+			assignment.Distance = distance(dataMatrix.RowView(i), centroids.RowView(labels[i]))
+			result.WCSS += assignment.Distance * assignment.Distance
+			result.ClusterSizes[labels[i]]++
+		}
+		result.Assignments[i] = assignment
+	}
+
+	if err := saveClusterCentroids(projectDir, centroids); err != nil {
+		return nil, fmt.Errorf("error saving cluster centroids: %v", err)
+	}
+	if err := saveClusterAssignments(projectDir, result); err != nil {
+		return nil, fmt.Errorf("error saving cluster assignments: %v", err)
+	}
+
+	if manifest, err := openManifest(projectDir); err == nil {
+		clustered := make(map[string]bool, len(md5Hashes))
+		for _, md5Hash := range md5Hashes {
+			clustered[md5Hash] = true
+		}
+		if err := manifest.markStage(stageClustered, paramsHash(settings.Clustering, k), clustered); err != nil {
+			a.LogError(projectName, err, "error saving manifest")
+		}
+	}
+
+	fmt.Printf("Assigned %d spectrograms to %d clusters with %s (%d outliers)\n", len(md5Hashes), result.K, algorithm, result.Noise)
+	return result, nil
+}
+
+// clusterMeans returns the mean of the rows of every cluster, leaving out
+// noise. Labels must run from 0 to the number of clusters minus one.
+func clusterMeans(data *mat.Dense, labels []int) *mat.Dense {
+	_, cols := data.Dims()
+	k := 0
+	for _, label := range labels {
+		k = max(k, label+1)
+	}
+	if k == 0 {
+		return &mat.Dense{}
+	}
+
+	means := mat.NewDense(k, cols, nil)
+	sizes := make([]int, k)
+	for i, label := range labels {
+		if label != noiseCluster {
+			floats.Add(means.RawRowView(label), data.RawRowView(i))
+			sizes[label]++
+		}
+	}
+	for c, size := range sizes {
+		if size > 0 {
+			floats.Scale(1/float64(size), means.RawRowView(c))
+		}
+	}
+	return means
+}
+
+// GetClusterAssignments returns the assignments saved by the last clustering run.
+func (a *App) GetClusterAssignments(projectName string) (*ClusterAssignments, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadClusterAssignments(projectDir)
+}
+
+func saveClusterAssignments(projectDir string, result *ClusterAssignments) error {
+	fileData, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(projectDir, clusterAssignmentsFile), fileData, os.ModePerm)
+}
+
+func loadClusterAssignments(projectDir string) (*ClusterAssignments, error) {
+	fileData, err := ioutil.ReadFile(filepath.Join(projectDir, clusterAssignmentsFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster assignments: %v", err)
+	}
+
+	var result ClusterAssignments
+	if err := json.Unmarshal(fileData, &result); err != nil {
+		return nil, fmt.Errorf("error unmarshalling cluster assignments: %v", err)
+	}
+	return &result, nil
+}
+
+func saveClusterCentroids(projectDir string, centroids *mat.Dense) error {
+	k, _ := centroids.Dims()
+	result := ClusterCentroids{K: k, Centroids: make([][]float64, k)}
+	for i := 0; i < k; i++ {
+		result.Centroids[i] = mat.Row(nil, i, centroids)
+	}
+
+	fileData, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(projectDir, clusterCentroidsFile), fileData, os.ModePerm)
+}
+
+func loadElbowResults(projectDir string) (*ElbowResult, error) {
+	fileData, err := ioutil.ReadFile(filepath.Join(projectDir, "elbow_results.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result ElbowResult
+	if err := json.Unmarshal(fileData, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAssignClustersSavesAssignments(t *testing.T) {
+	app, projectDir := newTestProject(t, "test")
+	md5Hashes := writeTestClusters(t, projectDir, 4)
+
+	result, err := app.AssignClusters("test", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.K != 2 || len(result.Assignments) != len(md5Hashes) {
+		t.Fatalf("K %d with %d assignments, want 2 with %d", result.K, len(result.Assignments), len(md5Hashes))
+	}
+	clusters := make(map[string]int)
+	for _, assignment := range result.Assignments {
+		clusters[assignment.MD5Hash] = assignment.Cluster
+	}
+	for i, md5Hash := range md5Hashes {
+		if same := clusters[md5Hash] == clusters[md5Hashes[0]]; same != (i < 4) {
+			t.Errorf("%s is in cluster %d, %s in %d", md5Hash, clusters[md5Hash], md5Hashes[0], clusters[md5Hashes[0]])
+		}
+	}
+	if result.ClusterSizes[0] != 4 || result.ClusterSizes[1] != 4 {
+		t.Errorf("cluster sizes %v, want [4 4]", result.ClusterSizes)
+	}
+
+	saved, err := app.GetClusterAssignments("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.K != result.K || len(saved.Assignments) != len(result.Assignments) || saved.Assignments[0] != result.Assignments[0] {
+		t.Errorf("saved assignments %+v differ from the result %+v", saved, result)
+	}
+}
+
+func TestAssignClustersRejectsInvalidK(t *testing.T) {
+	app, projectDir := newTestProject(t, "test")
+	writeTestClusters(t, projectDir, 2)
+
+	tests := []struct {
+		name string
+		k    int
+	}{
+		{"no K and no elbow results", 0},
+		{"negative", -1},
+		{"more clusters than spectrograms", 5},
+	}
+	for _, tt := range tests {
+		if _, err := app.AssignClusters("test", tt.k); err == nil {
+			t.Errorf("%s: AssignClusters(%d) succeeded", tt.name, tt.k)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Model-selection criteria for choosing the number of clusters.
+const (
+	criterionElbow            = "elbow" // Kneedle knee of the WCSS curve
+	criterionSilhouette       = "silhouette"
+	criterionGap              = "gap"
+	criterionDaviesBouldin    = "davies_bouldin"
+	criterionCalinskiHarabasz = "calinski_harabasz"
+	silhouetteSampleSize      = 2000 // Rows used for the O(n^2) silhouette score
+	maxGapReferenceRestarts   = 3    // k-means restarts per reference data set of the gap statistic
+)
+
+var clusterCriteria = []string{criterionElbow, criterionSilhouette, criterionGap, criterionDaviesBouldin, criterionCalinskiHarabasz}
+
+// CriterionCurve is the value of one criterion for every K that was tried.
+type CriterionCurve struct {
+	KValues        []int     `json:"k_values"`
+	Values         []float64 `json:"values"`
+	StdErrors      []float64 `json:"std_errors,omitempty"`
+	HigherIsBetter bool      `json:"higher_is_better"`
+	OptimalK       int       `json:"optimal_k"`
+	Restarts       int       `json:"restarts,omitempty"` // k-means restarts per reference data set, for the gap statistic
+}
+
+// evaluateClusterCounts runs k-means for every K in the configured range and
+// scores each K with all criteria. OptimalK is the choice of cfg.Criterion.
+// Progress is reported per K, with the gap statistic counted as one more step.
+func evaluateClusterCounts(ctx context.Context, data *mat.Dense, cfg ClusteringConfig, report progressFunc) (*ElbowResult, error) {
+	rows, _ := data.Dims()
+	maxK := min(cfg.MaxK, rows)
+	if maxK < cfg.MinK {
+		return nil, fmt.Errorf("K range %d-%d is not possible with %d spectrograms", cfg.MinK, cfg.MaxK, rows)
+	}
+
+	result := &ElbowResult{
+		Criterion: cfg.Criterion,
+		Criteria:  map[string]CriterionCurve{},
+	}
+	sample := silhouetteSample(rows, cfg.KMeans.Seed)
+	sampleDistances := pairwiseDistances(data, sample)
+
+	silhouette := CriterionCurve{HigherIsBetter: true}
+	daviesBouldin := CriterionCurve{}
+	calinskiHarabasz := CriterionCurve{HigherIsBetter: true}
+	steps := maxK - cfg.MinK + 1
+	if cfg.GapReferences > 0 {
+		steps++
+	}
+
+	for k := cfg.MinK; k <= maxK; k++ {
+		kmeans, err := kMeans(ctx, data, k, cfg.KMeans)
+		if err != nil {
+			return nil, err
+		}
+		result.KValues = append(result.KValues, k)
+		result.WCSSValues = append(result.WCSSValues, kmeans.Inertia)
+		fmt.Printf("k=%d: WCSS %.4g after %d iterations\n", k, kmeans.Inertia, kmeans.Iterations)
+		report.update(k-cfg.MinK+1, steps)
+
+		if k < 2 || k >= rows {
+			continue
+		}
+		silhouette.KValues = append(silhouette.KValues, k)
+		silhouette.Values = append(silhouette.Values, silhouetteScore(sampleDistances, sample, kmeans.Labels))
+		daviesBouldin.KValues = append(daviesBouldin.KValues, k)
+		daviesBouldin.Values = append(daviesBouldin.Values, daviesBouldinIndex(data, kmeans))
+		calinskiHarabasz.KValues = append(calinskiHarabasz.KValues, k)
+		calinskiHarabasz.Values = append(calinskiHarabasz.Values, calinskiHarabaszIndex(data, kmeans))
+	}
+
+	result.Criteria[criterionElbow] = kneedleCurve(result.KValues, result.WCSSValues)
+	for name, curve := range map[string]CriterionCurve{
+		criterionSilhouette:       silhouette,
+		criterionDaviesBouldin:    daviesBouldin,
+		criterionCalinskiHarabasz: calinskiHarabasz,
+	} {
+		curve.OptimalK = bestK(curve)
+		result.Criteria[name] = curve
+	}
+
+	if cfg.GapReferences > 0 {
+		gap, err := gapStatistic(ctx, data, result.KValues, result.WCSSValues, cfg)
+		if err != nil {
+			return nil, err
+		}
+		result.Criteria[criterionGap] = gap
+		report.update(steps, steps)
+	}
+
+	chosen, ok := result.Criteria[cfg.Criterion]
+	if !ok || chosen.OptimalK == 0 {
+		return nil, fmt.Errorf("criterion %s could not be evaluated for K range %d-%d", cfg.Criterion, cfg.MinK, maxK)
+	}
+	result.OptimalK = chosen.OptimalK
+	return result, nil
+}
+
+// bestK returns the K with the best value of a criterion, or 0 if it is empty.
+func bestK(curve CriterionCurve) int {
+	best := -1
+	for i, v := range curve.Values {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		if best < 0 || (curve.HigherIsBetter && v > curve.Values[best]) || (!curve.HigherIsBetter && v < curve.Values[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return curve.KValues[best]
+}
+
+// kneedleCurve finds the knee of the decreasing WCSS curve with the Kneedle
+// algorithm: after normalising both axes to [0, 1], the knee is the point
+// farthest above the straight line between the first and last K.
+func kneedleCurve(kValues []int, wcss []float64) CriterionCurve {
+	curve := CriterionCurve{KValues: kValues, Values: make([]float64, len(kValues)), HigherIsBetter: true}
+	if len(kValues) == 0 {
+		return curve
+	}
+	curve.OptimalK = kValues[0]
+	if len(kValues) < 3 {
+		return curve
+	}
+
+	minW, maxW := floats.Min(wcss), floats.Max(wcss)
+	if maxW == minW {
+		return curve
+	}
+	span := float64(kValues[len(kValues)-1] - kValues[0])
+	for i, k := range kValues {
+		x := float64(k-kValues[0]) / span
+		y := (wcss[i] - minW) / (maxW - minW)
+		curve.Values[i] = (1 - y) - x
+	}
+	curve.OptimalK = bestK(curve)
+	return curve
+}
+
+func silhouetteSample(rows int, seed int64) []int {
+	if rows <= silhouetteSampleSize {
+		sample := make([]int, rows)
+		for i := range sample {
+			sample[i] = i
+		}
+		return sample
+	}
+	return rand.New(rand.NewSource(seed)).Perm(rows)[:silhouetteSampleSize]
+}
+
+// pairwiseDistances computes the Euclidean distances between the given rows.
+func pairwiseDistances(data *mat.Dense, rows []int) [][]float64 {
+	distances := make([][]float64, len(rows))
+	for i := range distances {
+		distances[i] = make([]float64, len(rows))
+	}
+
+	var wg sync.WaitGroup
+	next := make(chan int, len(rows))
+	for i := range rows {
+		next <- i
+	}
+	close(next)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				for j := i + 1; j < len(rows); j++ {
+					d := math.Sqrt(squaredDistance(data.RawRowView(rows[i]), data.RawRowView(rows[j])))
+					distances[i][j] = d
+					distances[j][i] = d
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return distances
+}
+
+// silhouetteScore is the mean silhouette coefficient of the sampled rows.
+func silhouetteScore(distances [][]float64, sample []int, labels []int) float64 {
+	var total float64
+	for i, row := range sample {
+		sums := map[int]float64{}
+		counts := map[int]int{}
+		for j, other := range sample {
+			if i == j {
+				continue
+			}
+			sums[labels[other]] += distances[i][j]
+			counts[labels[other]]++
+		}
+
+		own := labels[row]
+		if counts[own] == 0 {
+			continue // Singleton clusters score 0
+		}
+		a := sums[own] / float64(counts[own])
+		b := math.Inf(1)
+		for label, sum := range sums {
+			if label != own {
+				b = math.Min(b, sum/float64(counts[label]))
+			}
+		}
+		if math.IsInf(b, 1) || math.Max(a, b) == 0 {
+			continue
+		}
+		total += (b - a) / math.Max(a, b)
+	}
+	return total / float64(len(sample))
+}
+
+// daviesBouldinIndex averages, over clusters, the worst ratio of combined
+// scatter to centroid separation. Lower is better.
+func daviesBouldinIndex(data *mat.Dense, kmeans *kMeansResult) float64 {
+	k, _ := kmeans.Centroids.Dims()
+	scatter := make([]float64, k)
+	sizes := make([]int, k)
+	for i, label := range kmeans.Labels {
+		scatter[label] += math.Sqrt(squaredDistance(data.RawRowView(i), kmeans.Centroids.RawRowView(label)))
+		sizes[label]++
+	}
+	for c := range scatter {
+		if sizes[c] > 0 {
+			scatter[c] /= float64(sizes[c])
+		}
+	}
+
+	var total float64
+	for i := 0; i < k; i++ {
+		worst := 0.0
+		for j := 0; j < k; j++ {
+			if i == j {
+				continue
+			}
+			separation := math.Sqrt(squaredDistance(kmeans.Centroids.RawRowView(i), kmeans.Centroids.RawRowView(j)))
+			if separation == 0 {
+				continue
+			}
+			worst = math.Max(worst, (scatter[i]+scatter[j])/separation)
+		}
+		total += worst
+	}
+	return total / float64(k)
+}
+
+// calinskiHarabaszIndex is the ratio of between- to within-cluster dispersion,
+// each normalised by its degrees of freedom. Higher is better.
+func calinskiHarabaszIndex(data *mat.Dense, kmeans *kMeansResult) float64 {
+	rows, cols := data.Dims()
+	k, _ := kmeans.Centroids.Dims()
+	if kmeans.Inertia == 0 {
+		return math.Inf(1)
+	}
+
+	mean := make([]float64, cols)
+	for i := 0; i < rows; i++ {
+		floats.Add(mean, data.RawRowView(i))
+	}
+	floats.Scale(1/float64(rows), mean)
+
+	sizes := make([]int, k)
+	for _, label := range kmeans.Labels {
+		sizes[label]++
+	}
+	var between float64
+	for c := 0; c < k; c++ {
+		between += float64(sizes[c]) * squaredDistance(kmeans.Centroids.RawRowView(c), mean)
+	}
+
+	return (between / float64(k-1)) / (kmeans.Inertia / float64(rows-k))
+}
+
+// gapStatistic compares log(WCSS) with its expectation under uniform reference
+// data drawn from the bounding box of the features (Tibshirani et al.). The
+// chosen K is the smallest with Gap(k) >= Gap(k+1) - s(k+1).
+func gapStatistic(ctx context.Context, data *mat.Dense, kValues []int, wcss []float64, cfg ClusteringConfig) (CriterionCurve, error) {
+	rows, cols := data.Dims()
+	curve := CriterionCurve{
+		KValues:        kValues,
+		Values:         make([]float64, len(kValues)),
+		StdErrors:      make([]float64, len(kValues)),
+		HigherIsBetter: true,
+	}
+
+	lower := make([]float64, cols)
+	upper := make([]float64, cols)
+	for j := 0; j < cols; j++ {
+		column := mat.Col(nil, j, data)
+		lower[j], upper[j] = floats.Min(column), floats.Max(column)
+	}
+
+	refConfig := cfg.KMeans
+	refConfig.Restarts = min(refConfig.Restarts, maxGapReferenceRestarts)
+	curve.Restarts = refConfig.Restarts
+	rng := rand.New(rand.NewSource(cfg.KMeans.Seed))
+
+	refLogW := make([][]float64, len(kValues))
+	reference := mat.NewDense(rows, cols, nil)
+	for b := 0; b < cfg.GapReferences; b++ {
+		for i := 0; i < rows; i++ {
+			row := reference.RawRowView(i)
+			for j := range row {
+				row[j] = lower[j] + rng.Float64()*(upper[j]-lower[j])
+			}
+		}
+		for i, k := range kValues {
+			kmeans, err := kMeans(ctx, reference, k, refConfig)
+			if err != nil {
+				return curve, err
+			}
+			refLogW[i] = append(refLogW[i], math.Log(math.Max(kmeans.Inertia, 1e-300)))
+		}
+	}
+
+	for i := range kValues {
+		mean := floats.Sum(refLogW[i]) / float64(len(refLogW[i]))
+		var variance float64
+		for _, v := range refLogW[i] {
+			variance += (v - mean) * (v - mean)
+		}
+		variance /= float64(len(refLogW[i]))
+		curve.Values[i] = mean - math.Log(math.Max(wcss[i], 1e-300))
+		curve.StdErrors[i] = math.Sqrt(variance) * math.Sqrt(1+1/float64(cfg.GapReferences))
+	}
+
+	curve.OptimalK = bestK(curve)
+	for i := 0; i+1 < len(kValues); i++ {
+		if curve.Values[i] >= curve.Values[i+1]-curve.StdErrors[i+1] {
+			curve.OptimalK = kValues[i]
+			break
+		}
+	}
+	return curve, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// twoPairs is the points 0, 2, 10 and 12 clustered as {0, 2} and {10, 12}.
+func twoPairs() (*mat.Dense, *kMeansResult) {
+	data := mat.NewDense(4, 1, []float64{0, 2, 10, 12})
+	return data, &kMeansResult{
+		Labels:    []int{0, 0, 1, 1},
+		Centroids: mat.NewDense(2, 1, []float64{1, 11}),
+		Inertia:   4,
+	}
+}
+
+func TestClusterScores(t *testing.T) {
+	data, kmeans := twoPairs()
+	sample := silhouetteSample(4, 1)
+	silhouette := silhouetteScore(pairwiseDistances(data, sample), sample, kmeans.Labels)
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{"silhouette", silhouette, (9.0/11 + 7.0/9) / 2},
+		{"Davies-Bouldin", daviesBouldinIndex(data, kmeans), 0.2},
+		{"Calinski-Harabasz", calinskiHarabaszIndex(data, kmeans), 50},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s: got %g, want %g", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestBestK(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve CriterionCurve
+		want  int
+	}{
+		{"higher is better", CriterionCurve{KValues: []int{2, 3, 4}, Values: []float64{0.2, 0.7, 0.5}, HigherIsBetter: true}, 3},
+		{"lower is better", CriterionCurve{KValues: []int{2, 3, 4}, Values: []float64{0.2, 0.7, 0.5}}, 2},
+		{"skips NaN and infinity", CriterionCurve{KValues: []int{2, 3, 4}, Values: []float64{math.NaN(), math.Inf(1), 1}, HigherIsBetter: true}, 4},
+		{"empty", CriterionCurve{}, 0},
+	}
+	for _, tt := range tests {
+		if got := bestK(tt.curve); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKneedleCurve(t *testing.T) {
+	tests := []struct {
+		name    string
+		kValues []int
+		wcss    []float64
+		want    int
+	}{
+		{"knee", []int{1, 2, 3, 4, 5}, []float64{100, 30, 20, 15, 12}, 2},
+		{"late knee", []int{1, 2, 3, 4, 5}, []float64{100, 90, 80, 10, 8}, 4},
+		{"too few points", []int{3, 4}, []float64{10, 5}, 3},
+		{"flat", []int{1, 2, 3}, []float64{5, 5, 5}, 1},
+	}
+	for _, tt := range tests {
+		if got := kneedleCurve(tt.kValues, tt.wcss).OptimalK; got != tt.want {
+			t.Errorf("%s: got K %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateClusterCountsFindsBlobs(t *testing.T) {
+	data := blobs([][]float64{{0, 0}, {10, 0}, {0, 10}}, 15, 0.5, rand.New(rand.NewSource(2)))
+	for _, criterion := range clusterCriteria {
+		t.Run(criterion, func(t *testing.T) {
+			cfg := defaultClusteringConfig()
+			cfg.MinK, cfg.MaxK = 1, 6
+			cfg.Criterion = criterion
+			result, err := evaluateClusterCounts(context.Background(), data, cfg, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.OptimalK != 3 {
+				t.Errorf("optimal K %d, want 3", result.OptimalK)
+			}
+			if len(result.KValues) != 6 || len(result.WCSSValues) != 6 {
+				t.Errorf("%d K values and %d WCSS values, want 6", len(result.KValues), len(result.WCSSValues))
+			}
+		})
+	}
+}
+
+func TestGapStatisticRecordsRestarts(t *testing.T) {
+	data := blobs([][]float64{{0}, {10}}, 10, 0.5, rand.New(rand.NewSource(3)))
+	cfg := defaultClusteringConfig()
+	cfg.GapReferences = 3
+	kValues := []int{1, 2, 3}
+	wcss := make([]float64, len(kValues))
+	for i, k := range kValues {
+		kmeans, err := kMeans(context.Background(), data, k, cfg.KMeans)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wcss[i] = kmeans.Inertia
+	}
+
+	tests := []struct {
+		restarts int
+		want     int
+	}{
+		{10, maxGapReferenceRestarts},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		cfg.KMeans.Restarts = tt.restarts
+		curve, err := gapStatistic(context.Background(), data, kValues, wcss, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if curve.Restarts != tt.want {
+			t.Errorf("%d restarts configured: %d recorded, want %d", tt.restarts, curve.Restarts, tt.want)
+		}
+		if curve.OptimalK != 2 {
+			t.Errorf("%d restarts configured: optimal K %d, want 2", tt.restarts, curve.OptimalK)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// CNNConfig controls the convolutional classifier. Every convolution is
+// followed by ReLU and max pooling; the pooled maps feed a dense head.
+type CNNConfig struct {
+	Filters       []int `json:"filters"`        // Output channels of each convolution
+	KernelSize    int   `json:"kernel_size"`    // Odd, so the convolutions keep the map size
+	PoolSize      int   `json:"pool_size"`      // Max pooling window and stride
+	DenseUnits    int   `json:"dense_units"`    // Hidden units of the head, 0 for none
+	FrequencyPool int   `json:"frequency_pool"` // Adjacent frequency bins averaged before the network
+	Workers       int   `json:"workers"`        // Goroutines per batch, 0 for one per CPU
+}
+
+func defaultCNNConfig() CNNConfig {
+	return CNNConfig{
+		Filters:       []int{8, 16},
+		KernelSize:    3,
+		PoolSize:      2,
+		DenseUnits:    32,
+		FrequencyPool: 4,
+	}
+}
+
+func (cfg CNNConfig) validate() error {
+	if len(cfg.Filters) == 0 {
+		return fmt.Errorf("the CNN needs at least one convolution")
+	}
+	for _, filters := range cfg.Filters {
+		if filters <= 0 {
+			return fmt.Errorf("convolution filter counts must be positive")
+		}
+	}
+	if cfg.KernelSize < 1 || cfg.KernelSize%2 == 0 {
+		return fmt.Errorf("kernel size must be a positive odd number")
+	}
+	if cfg.PoolSize < 1 {
+		return fmt.Errorf("pool size must be positive")
+	}
+	if cfg.DenseUnits < 0 {
+		return fmt.Errorf("dense unit count must not be negative")
+	}
+	if cfg.FrequencyPool < 1 {
+		return fmt.Errorf("frequency pool must be positive")
+	}
+	if cfg.Workers < 0 {
+		return fmt.Errorf("worker count must not be negative")
+	}
+	return nil
+}
+
+// ConvLayer is a saved convolution. Weights are flattened as
+// [output channel][input channel][row][column].
+type ConvLayer struct {
+	InChannels  int       `json:"in_channels"`
+	OutChannels int       `json:"out_channels"`
+	KernelSize  int       `json:"kernel_size"`
+	Weights     []float64 `json:"weights"`
+	Bias        []float64 `json:"bias"`
+}
+
+// loadCNNInput returns the CNN input of a saved spectrogram and its shape.
+func loadCNNInput(spectrogramsDir, md5Hash string, frequencyPool int) ([]float64, [2]int, error) {
+	spectrogram, err := loadSpectrogramFile(filepath.Join(spectrogramsDir, md5Hash+".json"))
+	if err != nil {
+		return nil, [2]int{}, err
+	}
+	return cnnInput(spectrogram.Spectrogram, frequencyPool)
+}
+
+// cnnInput flattens a spectrogram as [frame][bin], with every frequencyPool
+// adjacent bins averaged, and returns it with its shape.
+func cnnInput(spectrogram [][]float64, frequencyPool int) ([]float64, [2]int, error) {
+	frames := len(spectrogram)
+	if frames == 0 {
+		return nil, [2]int{}, fmt.Errorf("empty spectrogram")
+	}
+	bins := (len(spectrogram[0]) + frequencyPool - 1) / frequencyPool
+
+	input := make([]float64, frames*bins)
+	for t, frame := range spectrogram {
+		if len(frame) != len(spectrogram[0]) {
+			return nil, [2]int{}, fmt.Errorf("frame %d has %d bins, expected %d", t, len(frame), len(spectrogram[0]))
+		}
+		for b := 0; b < bins; b++ {
+			group := frame[b*frequencyPool : min(len(frame), (b+1)*frequencyPool)]
+			sum := 0.0
+			for _, v := range group {
+				sum += v
+			}
+			input[t*bins+b] = sum / float64(len(group))
+		}
+	}
+	return input, [2]int{frames, bins}, nil
+}
+
+// convLayer is a same-padded 2-D convolution followed by ReLU.
+type convLayer struct {
+	in, out, kernel int
+	weights         []float64 // [out][in][row][column]
+	bias            []float64
+}
+
+func newConvLayer(in, out, kernel int, rng *rand.Rand) *convLayer {
+	layer := &convLayer{in: in, out: out, kernel: kernel, weights: make([]float64, out*in*kernel*kernel), bias: make([]float64, out)}
+	std := math.Sqrt(2 / float64(in*kernel*kernel))
+	for i := range layer.weights {
+		layer.weights[i] = rng.NormFloat64() * std
+	}
+	return layer
+}
+
+// forward convolves a [in][height][width] input and returns the activated
+// [out][height][width] output.
+func (l *convLayer) forward(input []float64, height, width int) []float64 {
+	pad := l.kernel / 2
+	output := make([]float64, l.out*height*width)
+	for o := 0; o < l.out; o++ {
+		plane := output[o*height*width : (o+1)*height*width]
+		for i := range plane {
+			plane[i] = l.bias[o]
+		}
+		for c := 0; c < l.in; c++ {
+			source := input[c*height*width : (c+1)*height*width]
+			kernel := l.weights[(o*l.in+c)*l.kernel*l.kernel:]
+			for ky := 0; ky < l.kernel; ky++ {
+				for kx := 0; kx < l.kernel; kx++ {
+					w := kernel[ky*l.kernel+kx]
+					dy, dx := ky-pad, kx-pad
+					for y := max(0, -dy); y < min(height, height-dy); y++ {
+						row := plane[y*width : (y+1)*width]
+						sourceRow := source[(y+dy)*width : (y+dy+1)*width]
+						for x := max(0, -dx); x < min(width, width-dx); x++ {
+							row[x] += w * sourceRow[x+dx]
+						}
+					}
+				}
+			}
+		}
+		for i, v := range plane {
+			plane[i] = max(v, 0)
+		}
+	}
+	return output
+}
+
+// backward adds the weight and bias gradients for one sample to
+// weightGrads and biasGrads, given the gradient of the loss with respect to
+// the activated output. It returns the gradient with respect to the input
+// when withInput is set.
+func (l *convLayer) backward(input, output, outputGrads []float64, height, width int, weightGrads, biasGrads []float64, withInput bool) []float64 {
+	pad := l.kernel / 2
+	var inputGrads []float64
+	if withInput {
+		inputGrads = make([]float64, l.in*height*width)
+	}
+	for o := 0; o < l.out; o++ {
+		delta := outputGrads[o*height*width : (o+1)*height*width]
+		activated := output[o*height*width : (o+1)*height*width]
+		for i, a := range activated {
+			if a <= 0 {
+				delta[i] = 0
+			}
+			biasGrads[o] += delta[i]
+		}
+		for c := 0; c < l.in; c++ {
+			source := input[c*height*width : (c+1)*height*width]
+			offset := (o*l.in + c) * l.kernel * l.kernel
+			for ky := 0; ky < l.kernel; ky++ {
+				for kx := 0; kx < l.kernel; kx++ {
+					k := offset + ky*l.kernel + kx
+					dy, dx := ky-pad, kx-pad
+					w, grad := l.weights[k], 0.0
+					for y := max(0, -dy); y < min(height, height-dy); y++ {
+						row := delta[y*width : (y+1)*width]
+						sourceRow := source[(y+dy)*width : (y+dy+1)*width]
+						for x := max(0, -dx); x < min(width, width-dx); x++ {
+							grad += row[x] * sourceRow[x+dx]
+						}
+						if withInput {
+							targetRow := inputGrads[c*height*width+(y+dy)*width:]
+							for x := max(0, -dx); x < min(width, width-dx); x++ {
+								targetRow[x+dx] += w * row[x]
+							}
+						}
+					}
+					weightGrads[k] += grad
+				}
+			}
+		}
+	}
+	return inputGrads
+}
+
+// maxPool pools every channel of a [channels][height][width] map over
+// size×size windows, dropping incomplete windows at the edges. switches
+// records the input index each output came from.
+func maxPool(input []float64, channels, height, width, size int) (output []float64, switches []int) {
+	pooledHeight, pooledWidth := height/size, width/size
+	output = make([]float64, channels*pooledHeight*pooledWidth)
+	switches = make([]int, len(output))
+	for c := 0; c < channels; c++ {
+		for py := 0; py < pooledHeight; py++ {
+			for px := 0; px < pooledWidth; px++ {
+				best := -1
+				for y := py * size; y < (py+1)*size; y++ {
+					for x := px * size; x < (px+1)*size; x++ {
+						i := (c*height+y)*width + x
+						if best < 0 || input[i] > input[best] {
+							best = i
+						}
+					}
+				}
+				j := (c*pooledHeight+py)*pooledWidth + px
+				output[j] = input[best]
+				switches[j] = best
+			}
+		}
+	}
+	return output, switches
+}
+
+// cnnNetwork is a stack of convolution and pooling stages feeding a dense
+// head.
+type cnnNetwork struct {
+	conv   []*convLayer
+	shapes [][2]int // Height and width at the input of each convolution
+	pool   int
+	head   *denseNetwork
+}
+
+// newCNNNetwork creates a network for inputs of the given shape. It fails
+// if pooling would shrink the maps to nothing.
+func newCNNNetwork(shape [2]int, cfg CNNConfig, classes int, rng *rand.Rand) (*cnnNetwork, error) {
+	network := &cnnNetwork{pool: cfg.PoolSize}
+	height, width, channels := shape[0], shape[1], 1
+	for _, filters := range cfg.Filters {
+		if height/cfg.PoolSize < 1 || width/cfg.PoolSize < 1 {
+			return nil, fmt.Errorf("spectrograms of %d×%d are too small for %d pooling stages of %d", shape[0], shape[1], len(cfg.Filters), cfg.PoolSize)
+		}
+		network.conv = append(network.conv, newConvLayer(channels, filters, cfg.KernelSize, rng))
+		network.shapes = append(network.shapes, [2]int{height, width})
+		height, width, channels = height/cfg.PoolSize, width/cfg.PoolSize, filters
+	}
+
+	sizes := []int{channels * height * width}
+	if cfg.DenseUnits > 0 {
+		sizes = append(sizes, cfg.DenseUnits)
+	}
+	network.head = newDenseNetwork(append(sizes, classes), rng)
+	return network, nil
+}
+
+// cnnNetworkFromModel rebuilds the network of a saved CNN classifier.
+func cnnNetworkFromModel(model *ClassifierModel) (*cnnNetwork, error) {
+	if len(model.InputShape) != 2 || len(model.ConvLayers) == 0 {
+		return nil, fmt.Errorf("classifier has no convolutions")
+	}
+	network := &cnnNetwork{pool: model.Config.CNN.PoolSize}
+	if network.pool < 1 {
+		return nil, fmt.Errorf("classifier has invalid pool size %d", network.pool)
+	}
+	height, width, channels := model.InputShape[0], model.InputShape[1], 1
+	for i, saved := range model.ConvLayers {
+		if saved.InChannels != channels || len(saved.Weights) != saved.OutChannels*saved.InChannels*saved.KernelSize*saved.KernelSize || len(saved.Bias) != saved.OutChannels {
+			return nil, fmt.Errorf("convolution %d has inconsistent sizes", i)
+		}
+		network.conv = append(network.conv, &convLayer{
+			in:      saved.InChannels,
+			out:     saved.OutChannels,
+			kernel:  saved.KernelSize,
+			weights: saved.Weights,
+			bias:    saved.Bias,
+		})
+		network.shapes = append(network.shapes, [2]int{height, width})
+		height, width, channels = height/network.pool, width/network.pool, saved.OutChannels
+	}
+
+	head, err := denseNetworkFromLayers(model.Layers)
+	if err != nil {
+		return nil, err
+	}
+	if head.inputs() != channels*height*width {
+		return nil, fmt.Errorf("classifier head takes %d inputs, convolutions give %d", head.inputs(), channels*height*width)
+	}
+	network.head = head
+	return network, nil
+}
+
+// cnnCache keeps what backward needs of one sample's forward pass.
+type cnnCache struct {
+	inputs   [][]float64 // Input of each convolution
+	outputs  [][]float64 // Activated output of each convolution
+	switches [][]int
+}
+
+// features runs the convolution stages on one standardised input and
+// returns the flattened maps fed to the head. cache may be nil.
+func (n *cnnNetwork) features(input []float64, cache *cnnCache) []float64 {
+	for i, layer := range n.conv {
+		height, width := n.shapes[i][0], n.shapes[i][1]
+		output := layer.forward(input, height, width)
+		pooled, switches := maxPool(output, layer.out, height, width, n.pool)
+		if cache != nil {
+			cache.inputs = append(cache.inputs, input)
+			cache.outputs = append(cache.outputs, output)
+			cache.switches = append(cache.switches, switches)
+		}
+		input = pooled
+	}
+	return input
+}
+
+// backward adds the convolution gradients of one sample to grads, given the
+// gradient of the loss with respect to its features.
+func (n *cnnNetwork) backward(cache *cnnCache, featureGrads []float64, grads *cnnGradients) {
+	pooledGrads := featureGrads
+	for i := len(n.conv) - 1; i >= 0; i-- {
+		outputGrads := make([]float64, len(cache.outputs[i]))
+		for j, source := range cache.switches[i] {
+			outputGrads[source] += pooledGrads[j]
+		}
+		height, width := n.shapes[i][0], n.shapes[i][1]
+		pooledGrads = n.conv[i].backward(cache.inputs[i], cache.outputs[i], outputGrads, height, width, grads.weights[i], grads.biases[i], i > 0)
+	}
+}
+
+// parameters returns the convolution weights and biases followed by those of
+// the head, and whether each takes weight decay.
+func (n *cnnNetwork) parameters() ([][]float64, []bool) {
+	var params [][]float64
+	var decay []bool
+	for _, layer := range n.conv {
+		params = append(params, layer.weights, layer.bias)
+		decay = append(decay, true, false)
+	}
+	headParams, headDecay := n.head.parameters()
+	return append(params, headParams...), append(decay, headDecay...)
+}
+
+func (n *cnnNetwork) convLayers() []ConvLayer {
+	layers := make([]ConvLayer, len(n.conv))
+	for i, layer := range n.conv {
+		layers[i] = ConvLayer{
+			InChannels:  layer.in,
+			OutChannels: layer.out,
+			KernelSize:  layer.kernel,
+			Weights:     append([]float64(nil), layer.weights...),
+			Bias:        append([]float64(nil), layer.bias...),
+		}
+	}
+	return layers
+}
+
+// cnnGradients accumulates the convolution gradients of one worker.
+type cnnGradients struct {
+	weights [][]float64
+	biases  [][]float64
+}
+
+func newCNNGradients(n *cnnNetwork) *cnnGradients {
+	grads := &cnnGradients{}
+	for _, layer := range n.conv {
+		grads.weights = append(grads.weights, make([]float64, len(layer.weights)))
+		grads.biases = append(grads.biases, make([]float64, len(layer.bias)))
+	}
+	return grads
+}
+
+// cnnTrainer trains a cnnNetwork on standardised spectrograms, spreading
+// each batch across workers.
+type cnnTrainer struct {
+	network *cnnNetwork
+	inputs  [][]float64
+	targets []int
+	workers int
+}
+
+// newCNNTrainer standardises the inputs with the mean and standard
+// deviation of all training values, which it records in the model.
+func newCNNTrainer(dataset *labelledDataset, train []int, cfg TrainingConfig, rng *rand.Rand, model *ClassifierModel) (*cnnTrainer, error) {
+	shape := dataset.shape
+	network, err := newCNNNetwork(shape, cfg.CNN, len(dataset.classes), rng)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, sumSquares, count := 0.0, 0.0, 0
+	for _, row := range train {
+		for _, v := range dataset.vectors[row] {
+			sum += v
+			sumSquares += v * v
+		}
+		count += len(dataset.vectors[row])
+	}
+	mean := sum / float64(count)
+	scale := math.Sqrt(max(sumSquares/float64(count)-mean*mean, 0))
+	if scale < 1e-12 {
+		scale = 1
+	}
+	model.FeatureMean, model.FeatureScale = []float64{mean}, []float64{scale}
+	model.InputShape = []int{shape[0], shape[1]}
+
+	inputs := make([][]float64, len(dataset.vectors))
+	for i, vector := range dataset.vectors {
+		inputs[i] = make([]float64, len(vector))
+		for j, v := range vector {
+			inputs[i][j] = (v - mean) / scale
+		}
+	}
+
+	workers := cfg.CNN.Workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+	return &cnnTrainer{network: network, inputs: inputs, targets: dataset.targets, workers: workers}, nil
+}
+
+// batchFeatures runs the convolution stages on rows in parallel and returns
+// the features as matrix rows, with the caches if asked.
+func (t *cnnTrainer) batchFeatures(rows []int, withCache bool) (*mat.Dense, []*cnnCache, []int) {
+	features := make([][]float64, len(rows))
+	var caches []*cnnCache
+	if withCache {
+		caches = make([]*cnnCache, len(rows))
+	}
+	parallelChunks(len(rows), t.workers, func(_, start, end int) {
+		for i := start; i < end; i++ {
+			var cache *cnnCache
+			if withCache {
+				cache = &cnnCache{}
+				caches[i] = cache
+			}
+			features[i] = t.network.features(t.inputs[rows[i]], cache)
+		}
+	})
+
+	x := mat.NewDense(len(rows), len(features[0]), nil)
+	targets := make([]int, len(rows))
+	for i, row := range rows {
+		x.SetRow(i, features[i])
+		targets[i] = t.targets[row]
+	}
+	return x, caches, targets
+}
+
+func (t *cnnTrainer) trainBatch(rows []int, opt *optimizer) {
+	x, caches, targets := t.batchFeatures(rows, true)
+	headGradients, featureGrads, _ := t.network.head.gradients(x, targets, true)
+
+	workerGrads := make([]*cnnGradients, t.workers)
+	parallelChunks(len(rows), t.workers, func(worker, start, end int) {
+		grads := newCNNGradients(t.network)
+		for i := start; i < end; i++ {
+			t.network.backward(caches[i], featureGrads.RawRowView(i), grads)
+		}
+		workerGrads[worker] = grads
+	})
+
+	grads := newCNNGradients(t.network)
+	for _, worker := range workerGrads {
+		if worker == nil {
+			continue
+		}
+		for l := range grads.weights {
+			for i, v := range worker.weights[l] {
+				grads.weights[l][i] += v
+			}
+			for i, v := range worker.biases[l] {
+				grads.biases[l][i] += v
+			}
+		}
+	}
+
+	var flat [][]float64
+	for l := range grads.weights {
+		flat = append(flat, grads.weights[l], grads.biases[l])
+	}
+	headGrads, _ := headGradients.parameters()
+	params, decay := t.network.parameters()
+	opt.step(params, append(flat, headGrads...), decay)
+}
+
+func (t *cnnTrainer) evaluate(rows []int) (loss, accuracy float64) {
+	x, _, targets := t.batchFeatures(rows, false)
+	return t.network.head.evaluate(x, targets)
+}
+
+func (t *cnnTrainer) store(model *ClassifierModel) {
+	model.ConvLayers = t.network.convLayers()
+	model.Layers = t.network.head.layers()
+}
+
+// parallelChunks splits n items into at most workers contiguous chunks and
+// calls fn for each in its own goroutine.
+func parallelChunks(n, workers int, fn func(worker, start, end int)) {
+	workers = max(1, min(workers, n))
+	size := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := 0; w*size < n; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			fn(w, w*size, min(n, (w+1)*size))
+		}(w)
+	}
+	wg.Wait()
+}
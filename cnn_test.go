@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestConvLayerBackward(t *testing.T) {
+	const height, width = 4, 5
+	rng := rand.New(rand.NewSource(1))
+	layer := newConvLayer(2, 3, 3, rng)
+	for i := range layer.bias {
+		layer.bias[i] = rng.NormFloat64() * 0.1
+	}
+	input := make([]float64, layer.in*height*width)
+	for i := range input {
+		input[i] = rng.NormFloat64()
+	}
+	// The loss weighs every output by a fixed coefficient, so its gradient
+	// with respect to the output is the coefficients
+	coefficients := make([]float64, layer.out*height*width)
+	for i := range coefficients {
+		coefficients[i] = rng.NormFloat64()
+	}
+	lossAt := func() float64 {
+		loss := 0.0
+		for i, v := range layer.forward(input, height, width) {
+			loss += coefficients[i] * v
+		}
+		return loss
+	}
+
+	output := layer.forward(input, height, width)
+	outputGrads := append([]float64(nil), coefficients...)
+	weightGrads := make([]float64, len(layer.weights))
+	biasGrads := make([]float64, len(layer.bias))
+	inputGrads := layer.backward(input, output, outputGrads, height, width, weightGrads, biasGrads, true)
+
+	checkGradient(t, "weight", layer.weights, weightGrads, lossAt)
+	checkGradient(t, "bias", layer.bias, biasGrads, lossAt)
+	checkGradient(t, "input", input, inputGrads, lossAt)
+}
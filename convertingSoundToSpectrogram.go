@@ -1,36 +1,51 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"image"
-	_ "image/png"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// SpectrogramData is the saved spectrogram of one audio chunk. Spectrogram is
+// indexed as [frame][frequency bin] and holds STFT magnitudes in dB.
 type SpectrogramData struct {
 	FileName    string      `json:"file_name"`
 	MD5Hash     string      `json:"md5_hash"`
-	ChunkPath   string      `json:"chunk_path"`
+	ChunkPath   string      `json:"chunk_path"`  // Project-relative chunk WAV, empty unless chunk files are retained
+	SourcePath  string      `json:"source_path"` // Project-relative source WAV, empty if it was deleted
+	SourceFile  string      `json:"source_file"`
+	ChunkIndex  int         `json:"chunk_index"`
+	StartTime   float64     `json:"start_time"` // Offset of the chunk in the source file, in seconds
+	EndTime     float64     `json:"end_time"`   // End of the real (unpadded) audio, in seconds
+	SampleRate  int         `json:"sample_rate"`
+	Duration    float64     `json:"duration"`
+	STFT        STFTConfig  `json:"stft"`
 	Spectrogram [][]float64 `json:"spectrogram"`
 }
 
 const spectrogramChunkBatch = 10 // Number of files to process concurrently
 
 func (a *App) ProcessAudioChunksAndSpectrograms(projectName string) ([]string, error) {
-	homeDir, err := os.UserHomeDir()
+	return a.processAudioChunksAndSpectrograms(context.Background(), projectName, nil)
+}
+
+// processAudioChunksAndSpectrograms turns every WAV file of the project into
+// chunk spectrograms. Cancelling ctx stops the run after discarding the
+// outputs of the files that were still in progress.
+func (a *App) processAudioChunksAndSpectrograms(ctx context.Context, projectName string, report progressFunc) ([]string, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
 	if err != nil {
-		fmt.Println("Error getting user home directory:", err)
 		return nil, err
 	}
-	projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
 	soundsDir := filepath.Join(projectDir, "sounds")
 	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
 
@@ -40,11 +55,41 @@ func (a *App) ProcessAudioChunksAndSpectrograms(projectName string) ([]string, e
 		return nil, a.LogError(projectName, err, "error creating spectrograms directory")
 	}
 
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, a.LogError(projectName, err, "error loading pipeline settings")
+	}
+	if err := settings.validate(); err != nil {
+		return nil, a.LogError(projectName, err, "invalid pipeline settings")
+	}
+
+	manifest, err := openManifest(projectDir)
+	if err != nil {
+		return nil, a.LogError(projectName, err, "error loading manifest")
+	}
+	defer manifest.save()
+
+	// List the WAV files up front so progress can be reported against a total
+	var wavFiles []string
+	err = filepath.Walk(soundsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".wav") {
+			wavFiles = append(wavFiles, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	var duplicates []string
 	var wg sync.WaitGroup
 	var fileCounter int32
 	fileChan := make(chan string, spectrogramChunkBatch)
 	duplicateChan := make(chan string, spectrogramChunkBatch)
+	collected := make(chan struct{})
 
 	// Worker to process files
 	for i := 0; i < spectrogramChunkBatch; i++ {
@@ -52,14 +97,22 @@ func (a *App) ProcessAudioChunksAndSpectrograms(projectName string) ([]string, e
 		go func() {
 			defer wg.Done()
 			for filePath := range fileChan {
-				md5Hash, err := a.processWAVFile(filePath, spectrogramsDir)
+				if ctx.Err() != nil {
+					continue
+				}
+				md5Hashes, err := a.processSoundFile(ctx, manifest, filePath, projectDir, settings)
+				if ctx.Err() != nil {
+					continue
+				}
 				if err != nil {
 					a.LogError(projectName, err, fmt.Sprintf("error processing WAV file: %s", filePath))
-				} else {
+				}
+				for _, md5Hash := range md5Hashes {
 					duplicateChan <- md5Hash
 				}
-				atomic.AddInt32(&fileCounter, 1)
-				fmt.Printf("Processed file %d: %s\n", fileCounter, filePath)
+				processed := atomic.AddInt32(&fileCounter, 1)
+				report.update(int(processed), len(wavFiles))
+				fmt.Printf("Processed file %d/%d (%d chunks): %s\n", processed, len(wavFiles), len(md5Hashes), filePath)
 			}
 		}()
 	}
@@ -69,138 +122,285 @@ func (a *App) ProcessAudioChunksAndSpectrograms(projectName string) ([]string, e
 		for md5Hash := range duplicateChan {
 			duplicates = append(duplicates, md5Hash)
 		}
+		close(collected)
 	}()
 
-	// Walk through all .wav files and send them to the workers
-	err = filepath.Walk(soundsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	// Send all .wav files to the workers
+	for _, path := range wavFiles {
+		if ctx.Err() != nil {
+			break
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".wav") {
-			fileChan <- path
-		}
-		return nil
-	})
-
-	if err != nil {
-		close(fileChan)
-		close(duplicateChan)
-		wg.Wait()
-		return nil, err
+		fileChan <- path
 	}
 
 	// Close channels and wait for all workers to finish
 	close(fileChan)
 	wg.Wait()
 	close(duplicateChan)
+	<-collected
+
+	if ctx.Err() != nil {
+		fmt.Println("Audio processing cancelled.")
+		return nil, ctx.Err()
+	}
 
 	fmt.Println("Audio processing completed with spectrogram generation.")
 	return duplicates, nil
 }
 
-func (a *App) processWAVFile(filePath, spectrogramsDir string) (string, error) {
-	chunkFilePath := filePath
-
-	md5Hash, err := generateAndSaveSpectrogramData(chunkFilePath, spectrogramsDir)
+// processSoundFile runs processWAVFile unless the manifest shows that the WAV
+// was already chunked and turned into spectrograms with the current settings,
+// and records the result. Spectrograms that a changed WAV no longer produces
+// are removed.
+func (a *App) processSoundFile(ctx context.Context, manifest *manifestStore, filePath, projectDir string, settings PipelineSettings) ([]string, error) {
+	key := manifestKey(projectDir, filePath)
+	chunkParams := paramsHash(settings.Activity, settings.Chunking, settings.Retention)
+	spectrogramParams := paramsHash(settings.Spectrogram)
+
+	entry, known := manifest.lookup(key)
+	var previous *FileFingerprint
+	if known && entry.WAV.ContentHash != "" {
+		previous = &entry.WAV
+	}
+	wav, changed, err := fingerprintFile(filePath, key, previous)
 	if err != nil {
-		return "", fmt.Errorf("error processing spectrogram for chunk: %s", chunkFilePath)
+		return nil, err
+	}
+	if !changed && entry.stageDone(stageChunked, chunkParams) && entry.stageDone(stageSpectrogram, spectrogramParams) {
+		// Keep the new modification time so the file is not hashed again.
+		return entry.Chunks, manifest.update(key, func(f *ManifestFile) { f.WAV = wav })
 	}
 
-	// Delete the chunked .wav file after processing
-	err = os.Remove(chunkFilePath)
+	md5Hashes, err := a.processWAVFile(ctx, filePath, projectDir, settings)
 	if err != nil {
-		return "", fmt.Errorf("error deleting chunk file: %s", chunkFilePath)
+		return md5Hashes, err
 	}
 
-	return md5Hash, nil
+	removeStaleChunks(projectDir, manifest, key, entry.Chunks, md5Hashes)
+	return md5Hashes, manifest.update(key, func(f *ManifestFile) {
+		now := time.Now()
+		f.WAV = wav
+		f.Chunks = md5Hashes
+		f.Stages[stageChunked] = StageRecord{ParamsHash: chunkParams, CompletedAt: now}
+		f.Stages[stageSpectrogram] = StageRecord{ParamsHash: spectrogramParams, CompletedAt: now}
+		// Later stages have to be redone for the new spectrograms.
+		delete(f.Stages, stageFeatures)
+		delete(f.Stages, stageClustered)
+	})
 }
 
-func generateAndSaveSpectrogramData(chunkFilePath, spectrogramsDir string) (string, error) {
-	md5Hash, err := calculateMD5FromFile(chunkFilePath)
-	if err != nil {
-		return "", fmt.Errorf("error calculating MD5 hash for chunk: %v", err)
+// removeStaleChunks deletes the outputs of chunks that a WAV produced before
+// but not anymore, unless another WAV also produced them.
+func removeStaleChunks(projectDir string, manifest *manifestStore, key string, previous, current []string) {
+	keep := map[string]bool{}
+	for _, md5Hash := range current {
+		keep[md5Hash] = true
+	}
+	owners := manifest.chunkOwners()
+	for _, md5Hash := range previous {
+		if keep[md5Hash] || len(owners[md5Hash]) > 1 {
+			continue
+		}
+		spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+		os.Remove(filepath.Join(spectrogramsDir, md5Hash+".json"))
+		os.Remove(featuresFilePath(spectrogramsDir, md5Hash))
+		os.Remove(filepath.Join(projectDir, "chunks", md5Hash+".wav"))
 	}
+}
 
-	jsonFilePath := filepath.Join(spectrogramsDir, md5Hash+".json")
+// processWAVFile detects the active regions of a source WAV, splits them into
+// chunks and saves a spectrogram for each chunk. It returns the MD5 hashes of
+// the chunks that were processed. If ctx is cancelled midway, the files
+// written for this source are removed again so that it is redone as a whole.
+func (a *App) processWAVFile(ctx context.Context, filePath, projectDir string, settings PipelineSettings) ([]string, error) {
+	r, cleanup, err := openAudio(ctx, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	defer r.Close()
 
-	if _, err := os.Stat(jsonFilePath); err == nil {
-		return md5Hash, nil
+	regions := []frameRange{{Start: 0, End: r.Info().NumFrames}}
+	if settings.Activity.Enabled {
+		report, err := detectActivity(r, settings.Activity)
+		if err != nil {
+			return nil, fmt.Errorf("error detecting activity in %s: %v", filePath, err)
+		}
+		report.SourceFile = filepath.Base(filePath)
+		if err := saveActivityReport(filepath.Join(projectDir, "activity"), report); err != nil {
+			return nil, fmt.Errorf("error saving activity report: %v", err)
+		}
+		regions = report.frameRanges()
 	}
 
-	spectrogramData, err := generateSpectrogramData(chunkFilePath)
+	var md5Hashes, created []string
+	err = forEachChunk(r, filePath, regions, settings.Chunking, func(chunk audioChunk) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		md5Hash, isNew, err := generateAndSaveSpectrogramData(chunk, projectDir, settings)
+		if err != nil {
+			return fmt.Errorf("error processing spectrogram for chunk %d of %s: %v", chunk.Index, filePath, err)
+		}
+		md5Hashes = append(md5Hashes, md5Hash)
+		if isNew {
+			created = append(created, md5Hash)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		for _, md5Hash := range created {
+			os.Remove(filepath.Join(projectDir, "spectrograms", md5Hash+".json"))
+			os.Remove(filepath.Join(projectDir, "chunks", md5Hash+".wav"))
+		}
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		return "", fmt.Errorf("error generating spectrogram data: %v", err)
+		return md5Hashes, err
 	}
 
-	spectrogramJSON := SpectrogramData{
-		FileName:    filepath.Base(chunkFilePath),
-		MD5Hash:     md5Hash,
-		ChunkPath:   chunkFilePath,
-		Spectrogram: spectrogramData,
+	if settings.Retention.Policy != retentionDelete {
+		return md5Hashes, nil
 	}
 
-	err = saveJSON(jsonFilePath, spectrogramJSON)
+	// Release the file before deleting it; Windows refuses to remove open files.
+	r.Close()
+	cleanup()
+
+	// Delete the source .wav file after processing
+	err = os.Remove(filePath)
 	if err != nil {
-		return "", fmt.Errorf("error saving spectrogram data: %v", err)
+		return md5Hashes, fmt.Errorf("error deleting WAV file: %s", filePath)
 	}
 
-	return md5Hash, nil
+	return md5Hashes, nil
 }
 
-func generateSpectrogramData(src string) ([][]float64, error) {
-	tempFile, err := os.CreateTemp("", "spectrogram-*.png")
-	if err != nil {
-		return nil, fmt.Errorf("error creating temp file: %v", err)
+// generateAndSaveSpectrogramData saves the spectrogram of a chunk unless it
+// already exists, reporting whether a new spectrogram was written.
+func generateAndSaveSpectrogramData(chunk audioChunk, projectDir string, settings PipelineSettings) (string, bool, error) {
+	md5Hash := chunk.MD5()
+	jsonFilePath := filepath.Join(projectDir, "spectrograms", md5Hash+".json")
+
+	chunkPath := ""
+	if settings.Retention.Policy == retentionKeepChunks {
+		chunkPath = filepath.Join("chunks", md5Hash+".wav")
+		if err := writeChunkFile(filepath.Join(projectDir, chunkPath), chunk); err != nil {
+			return "", false, fmt.Errorf("error saving chunk file: %v", err)
+		}
+	}
+
+	sourcePath := ""
+	if settings.Retention.Policy != retentionDelete {
+		if rel, err := filepath.Rel(projectDir, chunk.SourcePath); err == nil {
+			sourcePath = rel
+		}
 	}
-	defer os.Remove(tempFile.Name())
 
-	cmd := exec.Command("ffmpeg", "-y", "-i", src, "-lavfi", "showspectrumpic=s=1024x1024:legend=disabled:scale=cbrt", tempFile.Name())
+	if _, err := os.Stat(jsonFilePath); err == nil {
+		// The spectrogram stays, but the retention policy may have changed
+		// which audio is kept for it
+		if err := updateSpectrogramAudio(projectDir, jsonFilePath, chunkPath, sourcePath); err != nil {
+			return "", false, fmt.Errorf("error updating spectrogram audio: %v", err)
+		}
+		return md5Hash, false, nil
+	}
 
-	cmdOutput, err := cmd.CombinedOutput()
+	stftConfig := settings.Spectrogram
+	spectrogramData, err := generateSpectrogramData(chunk.Samples, stftConfig)
 	if err != nil {
-		fmt.Printf("FFmpeg error output:\n%s\n", string(cmdOutput))
-		return nil, fmt.Errorf("error generating spectrogram with FFmpeg: %v", err)
+		return "", false, fmt.Errorf("error generating spectrogram data: %v", err)
 	}
 
-	return extractSpectrogramDataFromImage(tempFile.Name())
-}
+	spectrogramJSON := SpectrogramData{
+		FileName:    filepath.Base(chunk.SourcePath),
+		MD5Hash:     md5Hash,
+		ChunkPath:   chunkPath,
+		SourcePath:  sourcePath,
+		SourceFile:  filepath.Base(chunk.SourcePath),
+		ChunkIndex:  chunk.Index,
+		StartTime:   chunk.StartTime(),
+		EndTime:     chunk.EndTime(),
+		SampleRate:  chunk.SampleRate,
+		Duration:    float64(len(chunk.Samples)) / float64(chunk.SampleRate),
+		STFT:        stftConfig,
+		Spectrogram: spectrogramData,
+	}
 
-func extractSpectrogramDataFromImage(imagePath string) ([][]float64, error) {
-	file, err := os.Open(imagePath)
+	err = saveJSON(jsonFilePath, spectrogramJSON)
 	if err != nil {
-		return nil, fmt.Errorf("error opening spectrogram image: %v", err)
+		return "", false, fmt.Errorf("error saving spectrogram data: %v", err)
 	}
-	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	return md5Hash, true, nil
+}
+
+// updateSpectrogramAudio points a saved spectrogram at the audio retained for
+// it, rewriting the file only when a chunk or source file was kept before and
+// is not anymore, or the other way round. A chunk file that is no longer
+// retained is removed. A chunk shared by several sources keeps the source it
+// was first saved with.
+func updateSpectrogramAudio(projectDir, jsonFilePath, chunkPath, sourcePath string) error {
+	fileData, err := os.ReadFile(jsonFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding spectrogram image: %v", err)
+		return err
+	}
+	var audio struct {
+		ChunkPath  string `json:"chunk_path"`
+		SourcePath string `json:"source_path"`
+	}
+	if err := json.Unmarshal(fileData, &audio); err != nil {
+		return err
+	}
+	if audio.ChunkPath == chunkPath && (audio.SourcePath == "") == (sourcePath == "") {
+		return nil
 	}
 
-	bounds := img.Bounds()
-	width, height := bounds.Max.X, bounds.Max.Y
-	spectrogramData := make([][]float64, height)
+	var spectrogram SpectrogramData
+	if err := json.Unmarshal(fileData, &spectrogram); err != nil {
+		return err
+	}
+	if audio.ChunkPath != "" && chunkPath == "" {
+		os.Remove(filepath.Join(projectDir, audio.ChunkPath))
+	}
+	spectrogram.ChunkPath = chunkPath
+	if (audio.SourcePath == "") != (sourcePath == "") {
+		spectrogram.SourcePath = sourcePath
+	}
+	return saveJSON(jsonFilePath, spectrogram)
+}
 
-	for y := 0; y < height; y++ {
-		row := make([]float64, width)
-		for x := 0; x < width; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			intensity := float64(r+g+b) / (3 * 65535.0)
-			row[x] = intensity
-		}
-		spectrogramData[y] = row
+func writeChunkFile(path string, chunk audioChunk) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
 	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(path, encodeWAV(chunk.Samples, chunk.SampleRate), os.ModePerm)
+}
 
-	return spectrogramData, nil
+// generateSpectrogramData computes the dB magnitude spectrogram of mono samples.
+func generateSpectrogramData(samples []float64, stftConfig STFTConfig) ([][]float64, error) {
+	magnitudes, err := computeSTFT(samples, stftConfig)
+	if err != nil {
+		return nil, err
+	}
+	return magnitudesToDB(magnitudes, stftConfig.MinDB), nil
 }
 
 func calculateMD5FromFile(filePath string) (string, error) {
-	fileData, err := os.ReadFile(filePath)
+	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
 	}
-	hash := md5.Sum(fileData)
-	return hex.EncodeToString(hash[:]), nil
+	defer file.Close()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func saveJSON(filePath string, data SpectrogramData) error {
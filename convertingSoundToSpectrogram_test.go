@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateAndSaveSpectrogramDataFollowsRetention(t *testing.T) {
+	projectDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(projectDir, "spectrograms"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	samples := make([]float64, 4000)
+	for i := range samples {
+		samples[i] = float64(i%100)/100 - 0.5
+	}
+	chunk := audioChunk{
+		SourcePath: filepath.Join(projectDir, "sounds", "a.wav"),
+		SampleRate: 8000,
+		Span:       chunkSpan{Start: 0, End: len(samples), Length: len(samples)},
+		Samples:    samples,
+	}
+	chunkFile := filepath.Join(projectDir, "chunks", chunk.MD5()+".wav")
+
+	// Each step reruns the chunk with a new retention policy over the
+	// spectrogram saved before
+	steps := []struct {
+		policy     string
+		chunkPath  string
+		sourcePath string
+		isNew      bool
+	}{
+		{retentionKeepChunks, filepath.Join("chunks", chunk.MD5()+".wav"), filepath.Join("sounds", "a.wav"), true},
+		{retentionKeep, "", filepath.Join("sounds", "a.wav"), false},
+		{retentionDelete, "", "", false},
+		{retentionKeepChunks, filepath.Join("chunks", chunk.MD5()+".wav"), filepath.Join("sounds", "a.wav"), false},
+	}
+	for _, step := range steps {
+		settings := defaultPipelineSettings()
+		settings.Retention.Policy = step.policy
+		md5Hash, isNew, err := generateAndSaveSpectrogramData(chunk, projectDir, settings)
+		if err != nil {
+			t.Fatalf("%s: %v", step.policy, err)
+		}
+		if isNew != step.isNew {
+			t.Errorf("%s: new spectrogram %t, want %t", step.policy, isNew, step.isNew)
+		}
+		spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", md5Hash+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spectrogram.ChunkPath != step.chunkPath || spectrogram.SourcePath != step.sourcePath {
+			t.Errorf("%s: chunk path %q and source path %q, want %q and %q", step.policy, spectrogram.ChunkPath, spectrogram.SourcePath, step.chunkPath, step.sourcePath)
+		}
+		if _, err := os.Stat(chunkFile); (err == nil) != (step.chunkPath != "") {
+			t.Errorf("%s: chunk file exists %t, want %t", step.policy, err == nil, step.chunkPath != "")
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// noiseCluster is the label of points that density clustering leaves out.
+const noiseCluster = -1
+
+// DBSCANConfig controls DBSCAN. An Eps of 0 estimates the radius from the
+// knee of the sorted MinPoints-nearest-neighbour distances.
+type DBSCANConfig struct {
+	Eps       float64 `json:"eps"`
+	MinPoints int     `json:"min_points"` // Neighbours, including the point itself, that make a core point
+}
+
+func defaultDBSCANConfig() DBSCANConfig {
+	return DBSCANConfig{Eps: 0, MinPoints: 5}
+}
+
+func (cfg DBSCANConfig) validate() error {
+	if cfg.Eps < 0 {
+		return fmt.Errorf("eps cannot be negative")
+	}
+	if cfg.MinPoints < 1 {
+		return fmt.Errorf("min points must be positive")
+	}
+	return nil
+}
+
+// HDBSCANConfig controls HDBSCAN.
+type HDBSCANConfig struct {
+	MinClusterSize int `json:"min_cluster_size"`
+	MinSamples     int `json:"min_samples"` // Neighbourhood size of the core distance
+}
+
+func defaultHDBSCANConfig() HDBSCANConfig {
+	return HDBSCANConfig{MinClusterSize: 5, MinSamples: 5}
+}
+
+func (cfg HDBSCANConfig) validate() error {
+	if cfg.MinClusterSize < 2 {
+		return fmt.Errorf("min cluster size must be at least 2")
+	}
+	if cfg.MinSamples < 1 {
+		return fmt.Errorf("min samples must be positive")
+	}
+	return nil
+}
+
+// dbscan labels every row with its cluster, or noiseCluster if it is not
+// density-reachable from any core point. It returns the labels and the eps
+// that was used.
+func dbscan(data *mat.Dense, cfg DBSCANConfig) ([]int, float64) {
+	rows, _ := data.Dims()
+	eps := cfg.Eps
+	if eps == 0 {
+		eps = estimateEps(data, cfg.MinPoints)
+		fmt.Printf("DBSCAN: estimated eps %.4g\n", eps)
+	}
+
+	neighbours := make([][]int, rows)
+	parallelRows(rows, func(i int) {
+		row := data.RawRowView(i)
+		for j := 0; j < rows; j++ {
+			if squaredDistance(row, data.RawRowView(j)) <= eps*eps {
+				neighbours[i] = append(neighbours[i], j)
+			}
+		}
+	})
+
+	labels := make([]int, rows)
+	visited := make([]bool, rows)
+	for i := range labels {
+		labels[i] = noiseCluster
+	}
+
+	cluster := 0
+	for i := 0; i < rows; i++ {
+		if visited[i] || len(neighbours[i]) < cfg.MinPoints {
+			continue
+		}
+		// Grow a new cluster from core point i.
+		queue := []int{i}
+		visited[i] = true
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+			labels[p] = cluster
+			if len(neighbours[p]) < cfg.MinPoints {
+				continue // Border point
+			}
+			for _, q := range neighbours[p] {
+				if !visited[q] {
+					visited[q] = true
+					queue = append(queue, q)
+				}
+			}
+		}
+		cluster++
+	}
+	return labels, eps
+}
+
+// estimateEps returns the knee of the ascending curve of distances to each
+// row's minPoints-th nearest neighbour.
+func estimateEps(data *mat.Dense, minPoints int) float64 {
+	coreDistances := coreDistances(data, minPoints)
+	sorted := append([]float64(nil), coreDistances...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n < 3 || sorted[n-1] == sorted[0] {
+		return sorted[n-1]
+	}
+	best, bestGap := n-1, 0.0
+	for i, d := range sorted {
+		x := float64(i) / float64(n-1)
+		y := (d - sorted[0]) / (sorted[n-1] - sorted[0])
+		if gap := x - y; gap > bestGap {
+			best, bestGap = i, gap
+		}
+	}
+	return sorted[best]
+}
+
+// coreDistances returns the distance of every row to its k-th nearest
+// neighbour, counting the row itself as the first.
+func coreDistances(data *mat.Dense, k int) []float64 {
+	rows, _ := data.Dims()
+	k = min(k, rows)
+	core := make([]float64, rows)
+	parallelRows(rows, func(i int) {
+		distances := make([]float64, rows)
+		row := data.RawRowView(i)
+		for j := range distances {
+			distances[j] = squaredDistance(row, data.RawRowView(j))
+		}
+		sort.Float64s(distances)
+		core[i] = math.Sqrt(distances[k-1])
+	})
+	return core
+}
+
+// hdbscan clusters rows with HDBSCAN (Campello et al.): it builds the minimum
+// spanning tree of the mutual reachability distances, condenses the resulting
+// single-linkage hierarchy with the minimum cluster size and keeps the most
+// stable clusters. Rows outside every selected cluster are noise.
+func hdbscan(data *mat.Dense, cfg HDBSCANConfig) []int {
+	rows, _ := data.Dims()
+	labels := make([]int, rows)
+	for i := range labels {
+		labels[i] = noiseCluster
+	}
+	if rows < 2 {
+		return labels
+	}
+
+	hierarchy := buildLinkageTree(mutualReachabilityMST(data, coreDistances(data, cfg.MinSamples)), rows)
+	condensed := condenseTree(hierarchy, rows, cfg.MinClusterSize)
+	selected := selectStableClusters(condensed, rows)
+
+	// Map every selected cluster to a dense label, then label each point with
+	// the selected cluster it belongs to, if any.
+	clusterParent := map[int]int{}
+	for _, edge := range condensed {
+		if edge.Child >= rows {
+			clusterParent[edge.Child] = edge.Parent
+		}
+	}
+	var selectedIDs []int
+	for id := range selected {
+		selectedIDs = append(selectedIDs, id)
+	}
+	sort.Ints(selectedIDs)
+	label := map[int]int{}
+	for i, id := range selectedIDs {
+		label[id] = i
+	}
+
+	for _, edge := range condensed {
+		if edge.Child >= rows {
+			continue
+		}
+		for cluster, ok := edge.Parent, true; ok; cluster, ok = clusterParent[cluster] {
+			if selected[cluster] {
+				labels[edge.Child] = label[cluster]
+				break
+			}
+		}
+	}
+	return labels
+}
+
+// mergeEdge joins the clusters that contain points A and B.
+type mergeEdge struct {
+	A, B     int
+	Distance float64
+}
+
+// mutualReachabilityMST runs Prim's algorithm over the complete graph of
+// mutual reachability distances max(core[a], core[b], d(a, b)) without
+// storing the distance matrix.
+func mutualReachabilityMST(data *mat.Dense, core []float64) []mergeEdge {
+	rows, _ := data.Dims()
+	inTree := make([]bool, rows)
+	best := make([]float64, rows)
+	from := make([]int, rows)
+	for i := range best {
+		best[i] = math.Inf(1)
+	}
+
+	edges := make([]mergeEdge, 0, rows-1)
+	current := 0
+	for len(edges) < rows-1 {
+		inTree[current] = true
+		row := data.RawRowView(current)
+		next := -1
+		for j := 0; j < rows; j++ {
+			if inTree[j] {
+				continue
+			}
+			d := math.Max(math.Sqrt(squaredDistance(row, data.RawRowView(j))), math.Max(core[current], core[j]))
+			if d < best[j] {
+				best[j] = d
+				from[j] = current
+			}
+			if next < 0 || best[j] < best[next] {
+				next = j
+			}
+		}
+		edges = append(edges, mergeEdge{A: from[next], B: next, Distance: best[next]})
+		current = next
+	}
+	return edges
+}
+
+// linkageNode is an internal node of a cluster hierarchy. Node ids
+// below the number of points are leaves; node rows+i is the i-th merge.
+type linkageNode struct {
+	Left, Right int
+	Distance    float64
+	Size        int
+}
+
+// buildLinkageTree turns merge edges into a hierarchy by applying them in
+// order of increasing distance. For the edges of a spanning tree this is the
+// single-linkage hierarchy.
+func buildLinkageTree(edges []mergeEdge, rows int) []linkageNode {
+	sort.SliceStable(edges, func(i, j int) bool { return edges[i].Distance < edges[j].Distance })
+
+	parent := make([]int, 2*rows-1)
+	size := make([]int, 2*rows-1)
+	for i := range parent {
+		parent[i] = i
+		size[i] = 1
+	}
+	find := func(x int) int {
+		for parent[x] != x {
+			parent[x] = parent[parent[x]]
+			x = parent[x]
+		}
+		return x
+	}
+
+	nodes := make([]linkageNode, 0, rows-1)
+	for _, edge := range edges {
+		a, b := find(edge.A), find(edge.B)
+		id := rows + len(nodes)
+		parent[a], parent[b] = id, id
+		size[id] = size[a] + size[b]
+		nodes = append(nodes, linkageNode{Left: a, Right: b, Distance: edge.Distance, Size: size[id]})
+	}
+	return nodes
+}
+
+// condensedEdge links a cluster of the condensed tree to a child cluster or
+// to a point that falls out of it at the given lambda (1 / distance).
+type condensedEdge struct {
+	Parent, Child int
+	Lambda        float64
+	Size          int
+}
+
+// condenseTree walks the hierarchy from the root. A split where both sides
+// have at least minClusterSize points creates two new clusters; otherwise
+// the smaller side's points fall out of the current cluster. Cluster ids
+// start at rows, the root.
+func condenseTree(nodes []linkageNode, rows, minClusterSize int) []condensedEdge {
+	nodeSize := func(id int) int {
+		if id < rows {
+			return 1
+		}
+		return nodes[id-rows].Size
+	}
+	leaves := func(id int) []int {
+		var out []int
+		stack := []int{id}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if top < rows {
+				out = append(out, top)
+			} else {
+				stack = append(stack, nodes[top-rows].Left, nodes[top-rows].Right)
+			}
+		}
+		return out
+	}
+
+	root := rows + len(nodes) - 1
+	relabel := map[int]int{root: rows}
+	nextCluster := rows + 1
+	var edges []condensedEdge
+
+	stack := []int{root}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := nodes[id-rows]
+		lambda := math.Inf(1)
+		if node.Distance > 0 {
+			lambda = 1 / node.Distance
+		}
+		cluster := relabel[id]
+
+		leftBig := nodeSize(node.Left) >= minClusterSize
+		rightBig := nodeSize(node.Right) >= minClusterSize
+		for _, side := range []struct {
+			child int
+			big   bool
+		}{{node.Left, leftBig}, {node.Right, rightBig}} {
+			switch {
+			case side.big && (leftBig && rightBig):
+				relabel[side.child] = nextCluster
+				edges = append(edges, condensedEdge{Parent: cluster, Child: nextCluster, Lambda: lambda, Size: nodeSize(side.child)})
+				nextCluster++
+				stack = append(stack, side.child)
+			case side.big:
+				// The cluster carries on under the larger side.
+				relabel[side.child] = cluster
+				stack = append(stack, side.child)
+			default:
+				for _, point := range leaves(side.child) {
+					edges = append(edges, condensedEdge{Parent: cluster, Child: point, Lambda: lambda, Size: 1})
+				}
+			}
+		}
+	}
+	return edges
+}
+
+// selectStableClusters picks clusters of the condensed tree by excess of
+// mass: a cluster is kept unless its children together are more stable. The
+// root is never selected, so data without structure ends up as noise.
+func selectStableClusters(edges []condensedEdge, rows int) map[int]bool {
+	birth := map[int]float64{rows: 0}
+	children := map[int][]int{}
+	maxCluster := rows
+	for _, edge := range edges {
+		if edge.Child >= rows {
+			birth[edge.Child] = edge.Lambda
+			children[edge.Parent] = append(children[edge.Parent], edge.Child)
+			maxCluster = max(maxCluster, edge.Child)
+		}
+	}
+
+	stability := map[int]float64{}
+	for _, edge := range edges {
+		lambda := edge.Lambda
+		if math.IsInf(lambda, 1) {
+			lambda = birth[edge.Parent] // Duplicate points add no stability
+		}
+		stability[edge.Parent] += (lambda - birth[edge.Parent]) * float64(edge.Size)
+	}
+
+	selected := map[int]bool{}
+	// Child clusters always have larger ids than their parents.
+	for cluster := maxCluster; cluster > rows; cluster-- {
+		var childStability float64
+		for _, child := range children[cluster] {
+			childStability += stability[child]
+		}
+		if len(children[cluster]) > 0 && childStability > stability[cluster] {
+			stability[cluster] = childStability
+			continue
+		}
+		selected[cluster] = true
+		stack := append([]int(nil), children[cluster]...)
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			delete(selected, top)
+			stack = append(stack, children[top]...)
+		}
+	}
+	return selected
+}
+
+// parallelRows calls fn for every row index, spread across CPU cores.
+func parallelRows(rows int, fn func(i int)) {
+	var wg sync.WaitGroup
+	next := make(chan int, rows)
+	for i := 0; i < rows; i++ {
+		next <- i
+	}
+	close(next)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
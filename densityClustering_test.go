@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// blobsWithOutlier returns two blobs of 20 points and one far away point.
+func blobsWithOutlier() *mat.Dense {
+	data := blobs([][]float64{{0, 0}, {10, 10}}, 20, 0.3, rand.New(rand.NewSource(4)))
+	rows, cols := data.Dims()
+	withOutlier := mat.NewDense(rows+1, cols, nil)
+	withOutlier.Slice(0, rows, 0, cols).(*mat.Dense).Copy(data)
+	withOutlier.SetRow(rows, []float64{50, -50})
+	return withOutlier
+}
+
+// checkTwoBlobs checks that labels put each blob in its own cluster and the
+// outlier in none.
+func checkTwoBlobs(t *testing.T, labels []int) {
+	t.Helper()
+	if labels[0] == noiseCluster || labels[20] == noiseCluster || labels[0] == labels[20] {
+		t.Fatalf("blobs labelled %d and %d", labels[0], labels[20])
+	}
+	for i := 0; i < 40; i++ {
+		want := labels[0]
+		if i >= 20 {
+			want = labels[20]
+		}
+		if labels[i] != want {
+			t.Errorf("row %d labelled %d, want %d", i, labels[i], want)
+		}
+	}
+	if labels[40] != noiseCluster {
+		t.Errorf("outlier labelled %d, want noise", labels[40])
+	}
+}
+
+func TestDBSCAN(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DBSCANConfig
+	}{
+		{"given eps", DBSCANConfig{Eps: 2, MinPoints: 4}},
+		{"estimated eps", DBSCANConfig{MinPoints: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, eps := dbscan(blobsWithOutlier(), tt.cfg)
+			if eps <= 0 {
+				t.Errorf("eps %g", eps)
+			}
+			checkTwoBlobs(t, labels)
+		})
+	}
+}
+
+func TestHDBSCAN(t *testing.T) {
+	checkTwoBlobs(t, hdbscan(blobsWithOutlier(), HDBSCANConfig{MinClusterSize: 5, MinSamples: 3}))
+}
+
+func TestDensityConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		valid bool
+	}{
+		{"default DBSCAN", defaultDBSCANConfig().validate(), true},
+		{"negative eps", DBSCANConfig{Eps: -1, MinPoints: 5}.validate(), false},
+		{"no min points", DBSCANConfig{MinPoints: 0}.validate(), false},
+		{"default HDBSCAN", defaultHDBSCANConfig().validate(), true},
+		{"min cluster size of 1", HDBSCANConfig{MinClusterSize: 1, MinSamples: 1}.validate(), false},
+		{"no min samples", HDBSCANConfig{MinClusterSize: 5}.validate(), false},
+	}
+	for _, tt := range tests {
+		if (tt.err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, tt.err, tt.valid)
+		}
+	}
+}
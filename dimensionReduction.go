@@ -0,0 +1,573 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// Dimensionality reduction methods applied before clustering.
+const (
+	reductionNone             = "none"
+	reductionPCA              = "pca"               // Exact PCA via SVD; needs the full data set in memory
+	reductionIncrementalPCA   = "incremental_pca"   // PCA fitted batch by batch
+	reductionRandomProjection = "random_projection" // Sparse sign projection; streams the data once
+)
+
+const (
+	reductionDir            = "reduction"
+	reductionModelFile      = "model.json"
+	reductionComponentsFile = "components.bin"
+	reductionMeanFile       = "mean.bin"
+)
+
+// ReductionConfig controls the projection of feature vectors to a smaller
+// number of components before clustering.
+type ReductionConfig struct {
+	Method     string `json:"method"`
+	Components int    `json:"components"`
+	BatchSize  int    `json:"batch_size"` // Rows per batch for incremental PCA
+	Seed       int64  `json:"seed"`       // Seed of the random projection
+}
+
+func defaultReductionConfig() ReductionConfig {
+	return ReductionConfig{
+		Method:     reductionIncrementalPCA,
+		Components: 32,
+		BatchSize:  64,
+		Seed:       42,
+	}
+}
+
+func (cfg ReductionConfig) validate() error {
+	switch cfg.Method {
+	case reductionNone:
+		return nil
+	case reductionPCA, reductionIncrementalPCA, reductionRandomProjection:
+	default:
+		return fmt.Errorf("unknown reduction method: %s", cfg.Method)
+	}
+	if cfg.Components <= 0 {
+		return fmt.Errorf("component count must be positive")
+	}
+	if cfg.Method == reductionIncrementalPCA && cfg.BatchSize < cfg.Components {
+		return fmt.Errorf("incremental PCA batch size must be at least the component count")
+	}
+	return nil
+}
+
+// ReductionModel describes a fitted projection. The PCA components and mean
+// are stored next to it in binary form since they span the full input size.
+// InputHash identifies the settings and files the model was fitted on, so
+// later steps project with the saved model instead of fitting it again.
+type ReductionModel struct {
+	Method                  string    `json:"method"`
+	Input                   string    `json:"input"`
+	InputDims               int       `json:"input_dims"`
+	Components              int       `json:"components"`
+	Samples                 int       `json:"samples"`
+	Seed                    int64     `json:"seed,omitempty"`
+	InputHash               string    `json:"input_hash"`
+	ExplainedVariance       []float64 `json:"explained_variance,omitempty"`
+	ExplainedVarianceRatio  []float64 `json:"explained_variance_ratio,omitempty"`
+	CumulativeVarianceRatio []float64 `json:"cumulative_variance_ratio,omitempty"`
+	CreatedAt               time.Time `json:"created_at"`
+	components              *mat.Dense
+	mean                    []float64
+}
+
+// FitDimensionReduction fits the configured reduction on the project's
+// feature vectors, saves it, and returns it with its explained variance so
+// the component count can be chosen.
+func (a *App) FitDimensionReduction(projectName string) (*ReductionModel, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.Clustering.validate(); err != nil {
+		return nil, fmt.Errorf("invalid clustering settings: %v", err)
+	}
+	if settings.Clustering.Reduction.Method == reductionNone {
+		return nil, fmt.Errorf("dimensionality reduction is disabled")
+	}
+
+	files, err := listSpectrogramFiles(filepath.Join(projectDir, "spectrograms"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing spectrogram JSON files: %v", err)
+	}
+
+	_, _, model, err := reduceClusteringData(projectDir, files, settings.Clustering)
+	return model, err
+}
+
+// GetDimensionReduction returns the last fitted reduction model of a project.
+func (a *App) GetDimensionReduction(projectName string) (*ReductionModel, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadReductionModel(projectDir)
+}
+
+// loadReducedClusteringData loads the clustering input of the given files and
+// projects it with the configured reduction. The saved model is used when it
+// was fitted on the same files with the same settings; otherwise the
+// reduction is fitted and saved.
+func loadReducedClusteringData(projectDir string, files []string, cfg ClusteringConfig) ([]string, *mat.Dense, error) {
+	if cfg.Reduction.Method == reductionNone {
+		return loadClusteringData(files, cfg.Input)
+	}
+	if model, err := loadReductionModel(projectDir); err == nil && model.InputHash == reductionInputHash(files, cfg) {
+		if err := model.loadProjection(projectDir); err == nil {
+			fmt.Printf("Projecting with the saved %s model\n", model.Method)
+			return projectFiles(files, cfg, model)
+		}
+	}
+	md5Hashes, data, _, err := reduceClusteringData(projectDir, files, cfg)
+	return md5Hashes, data, err
+}
+
+// reductionInputHash fingerprints the reduction settings and the input files
+// by name, size and modification time. It is empty when a file cannot be
+// read, so no saved model matches.
+func reductionInputHash(files []string, cfg ClusteringConfig) string {
+	settings, err := json.Marshal([]any{cfg.Input, cfg.Reduction})
+	if err != nil {
+		return ""
+	}
+	hash := md5.New()
+	hash.Write(settings)
+	for _, file := range files {
+		path := file
+		if cfg.Input == clusteringInputMFCC {
+			path = featuresFilePath(filepath.Dir(file), strings.TrimSuffix(filepath.Base(file), ".json"))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(hash, "%s %d %d\n", filepath.Base(path), info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func reduceClusteringData(projectDir string, files []string, cfg ClusteringConfig) ([]string, *mat.Dense, *ReductionModel, error) {
+	if len(files) == 0 {
+		return nil, nil, nil, fmt.Errorf("no spectrogram files to reduce")
+	}
+
+	var md5Hashes []string
+	var data *mat.Dense
+	var model *ReductionModel
+	var err error
+
+	switch cfg.Reduction.Method {
+	case reductionPCA:
+		md5Hashes, data, model, err = fitPCA(files, cfg)
+	case reductionIncrementalPCA:
+		md5Hashes, data, model, err = fitIncrementalPCA(files, cfg)
+	case reductionRandomProjection:
+		md5Hashes, data, model, err = randomProjection(files, cfg)
+	default:
+		err = fmt.Errorf("unknown reduction method: %s", cfg.Reduction.Method)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	model.Input = cfg.Input
+	model.InputHash = reductionInputHash(files, cfg)
+	model.CreatedAt = time.Now()
+	if err := saveReductionModel(projectDir, model); err != nil {
+		return nil, nil, nil, fmt.Errorf("error saving reduction model: %v", err)
+	}
+
+	if n := len(model.CumulativeVarianceRatio); n > 0 {
+		fmt.Printf("Reduced %d dims to %d components (%.1f%% of variance)\n", model.InputDims, model.Components, 100*model.CumulativeVarianceRatio[n-1])
+	} else {
+		fmt.Printf("Reduced %d dims to %d components\n", model.InputDims, model.Components)
+	}
+	return md5Hashes, data, model, nil
+}
+
+// fitPCA centres the full data matrix and takes its thin SVD. The principal
+// axes are the leading right singular vectors.
+func fitPCA(files []string, cfg ClusteringConfig) ([]string, *mat.Dense, *ReductionModel, error) {
+	md5Hashes, data, err := loadClusteringData(files, cfg.Input)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	rows, cols := data.Dims()
+	components := min(cfg.Reduction.Components, rows, cols)
+
+	mean := make([]float64, cols)
+	for i := 0; i < rows; i++ {
+		floats.Add(mean, data.RawRowView(i))
+	}
+	floats.Scale(1/float64(rows), mean)
+	for i := 0; i < rows; i++ {
+		floats.Sub(data.RawRowView(i), mean)
+	}
+
+	singular, axes, err := principalAxes(data, components)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	components, _ = axes.Dims()
+
+	model := &ReductionModel{
+		Method:     reductionPCA,
+		InputDims:  cols,
+		Components: components,
+		Samples:    rows,
+		components: axes,
+		mean:       mean,
+	}
+	model.setExplainedVariance(singular, rows, -1)
+
+	// Data is already centred, so project it directly.
+	reduced := mat.NewDense(rows, components, nil)
+	reduced.Mul(data, axes.T())
+	return md5Hashes, reduced, model, nil
+}
+
+// fitIncrementalPCA fits PCA batch by batch (Ross et al., as in scikit-learn's
+// IncrementalPCA) so only one batch is ever held in memory, then streams the
+// files a second time to project them.
+func fitIncrementalPCA(files []string, cfg ClusteringConfig) ([]string, *mat.Dense, *ReductionModel, error) {
+	var (
+		seen         int
+		mean         []float64
+		axes         *mat.Dense
+		singular     []float64
+		totalSquares float64
+		allSingular  []float64
+	)
+
+	err := forEachVectorBatch(files, cfg.Input, cfg.Reduction.BatchSize, func(_ []string, vectors [][]float64) error {
+		batchRows, cols := len(vectors), len(vectors[0])
+		batchMean := make([]float64, cols)
+		for _, v := range vectors {
+			floats.Add(batchMean, v)
+		}
+		floats.Scale(1/float64(batchRows), batchMean)
+
+		var stacked [][]float64
+		if axes != nil {
+			for c := range singular {
+				row := append([]float64(nil), axes.RawRowView(c)...)
+				floats.Scale(singular[c], row)
+				stacked = append(stacked, row)
+			}
+		}
+
+		var batchSquares float64
+		for _, v := range vectors {
+			row := make([]float64, cols)
+			floats.SubTo(row, v, batchMean)
+			batchSquares += floats.Dot(row, row)
+			stacked = append(stacked, row)
+		}
+
+		if seen > 0 {
+			correction := make([]float64, cols)
+			floats.SubTo(correction, mean, batchMean)
+			scale := math.Sqrt(float64(seen*batchRows) / float64(seen+batchRows))
+			totalSquares += batchSquares + scale*scale*floats.Dot(correction, correction)
+			floats.Scale(scale, correction)
+			stacked = append(stacked, correction)
+
+			floats.Scale(float64(seen), mean)
+			floats.AddScaled(mean, float64(batchRows), batchMean)
+			floats.Scale(1/float64(seen+batchRows), mean)
+		} else {
+			totalSquares = batchSquares
+			mean = batchMean
+		}
+		seen += batchRows
+
+		matrix := mat.NewDense(len(stacked), cols, nil)
+		for i, row := range stacked {
+			matrix.SetRow(i, row)
+		}
+		var err error
+		allSingular, axes, err = principalAxes(matrix, cfg.Reduction.Components)
+		if err != nil {
+			return err
+		}
+		components, _ := axes.Dims()
+		singular = allSingular[:components]
+		fmt.Printf("Incremental PCA: fitted %d/%d spectrograms\n", seen, len(files))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	components, cols := axes.Dims()
+	model := &ReductionModel{
+		Method:     reductionIncrementalPCA,
+		InputDims:  cols,
+		Components: components,
+		Samples:    seen,
+		components: axes,
+		mean:       mean,
+	}
+	model.setExplainedVariance(allSingular, seen, totalSquares)
+
+	md5Hashes, reduced, err := projectFiles(files, cfg, model)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return md5Hashes, reduced, model, nil
+}
+
+// randomProjection maps every input dimension onto one output component with
+// a pseudo-random sign (a sparse Johnson-Lindenstrauss projection). The
+// projection is fully determined by the seed, so nothing but the seed needs
+// to be stored and the data is streamed in a single pass.
+func randomProjection(files []string, cfg ClusteringConfig) ([]string, *mat.Dense, *ReductionModel, error) {
+	model := &ReductionModel{
+		Method:     reductionRandomProjection,
+		Components: cfg.Reduction.Components,
+		Seed:       cfg.Reduction.Seed,
+	}
+
+	md5Hashes, reduced, err := projectFiles(files, cfg, model)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	model.Samples = len(md5Hashes)
+	return md5Hashes, reduced, model, nil
+}
+
+// projectFiles streams the files through a fitted model and returns the
+// projected rows.
+func projectFiles(files []string, cfg ClusteringConfig, model *ReductionModel) ([]string, *mat.Dense, error) {
+	var md5Hashes []string
+	var rows [][]float64
+	err := forEachVectorBatch(files, cfg.Input, max(cfg.Reduction.BatchSize, elbowBatchSize), func(batchHashes []string, vectors [][]float64) error {
+		for _, v := range vectors {
+			model.InputDims = len(v)
+			rows = append(rows, model.transform(v))
+		}
+		md5Hashes = append(md5Hashes, batchHashes...)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reduced := mat.NewDense(len(rows), model.Components, nil)
+	for i, row := range rows {
+		reduced.SetRow(i, row)
+	}
+	return md5Hashes, reduced, nil
+}
+
+// transform projects one feature vector into the reduced space.
+func (model *ReductionModel) transform(v []float64) []float64 {
+	out := make([]float64, model.Components)
+	if model.Method == reductionRandomProjection {
+		for j, x := range v {
+			h := splitMix64(uint64(model.Seed) ^ uint64(j))
+			c := int(h % uint64(model.Components))
+			if h&(1<<63) != 0 {
+				out[c] -= x
+			} else {
+				out[c] += x
+			}
+		}
+		return out
+	}
+
+	centred := make([]float64, len(v))
+	floats.SubTo(centred, v, model.mean)
+	for c := range out {
+		out[c] = floats.Dot(model.components.RawRowView(c), centred)
+	}
+	return out
+}
+
+// principalAxes returns all singular values of x in decreasing order and up to
+// components right singular vectors as rows. Spectrogram inputs are far wider
+// than they are tall, so for wide matrices the vectors are recovered from the
+// eigendecomposition of the small Gram matrix x*x^T instead of a full SVD.
+func principalAxes(x *mat.Dense, components int) ([]float64, *mat.Dense, error) {
+	rows, cols := x.Dims()
+	if rows >= cols {
+		var svd mat.SVD
+		if !svd.Factorize(x, mat.SVDThin) {
+			return nil, nil, fmt.Errorf("SVD failed to converge")
+		}
+		var v mat.Dense
+		svd.VTo(&v)
+		components = min(components, cols)
+		return svd.Values(nil), mat.DenseCopyOf(v.Slice(0, cols, 0, components).T()), nil
+	}
+
+	gram := mat.NewSymDense(rows, nil)
+	gram.SymOuterK(1, x)
+	var eigen mat.EigenSym
+	if !eigen.Factorize(gram, true) {
+		return nil, nil, fmt.Errorf("eigendecomposition failed to converge")
+	}
+	var vectors mat.Dense
+	eigen.VectorsTo(&vectors)
+	values := eigen.Values(nil)
+
+	// Eigenvalues come in increasing order.
+	singular := make([]float64, rows)
+	for i := range singular {
+		singular[i] = math.Sqrt(math.Max(values[rows-1-i], 0))
+	}
+	tolerance := singular[0] * 1e-10
+	for components > 0 && (components > rows || singular[components-1] <= tolerance) {
+		components--
+	}
+	if components == 0 {
+		return nil, nil, fmt.Errorf("data has no variance to reduce")
+	}
+
+	axes := mat.NewDense(components, cols, nil)
+	for c := 0; c < components; c++ {
+		u := vectors.ColView(rows - 1 - c)
+		axis := axes.RawRowView(c)
+		for i := 0; i < rows; i++ {
+			floats.AddScaled(axis, u.AtVec(i)/singular[c], x.RawRowView(i))
+		}
+	}
+	return singular, axes, nil
+}
+
+// setExplainedVariance fills the variance fields from the singular values of
+// the centred data. totalSquares is the total sum of squares of the data, or
+// negative to derive it from the singular values.
+func (model *ReductionModel) setExplainedVariance(singular []float64, samples int, totalSquares float64) {
+	if totalSquares < 0 {
+		totalSquares = 0
+		for _, s := range singular {
+			totalSquares += s * s
+		}
+	}
+	denominator := float64(max(samples-1, 1))
+
+	var cumulative float64
+	for c := 0; c < model.Components; c++ {
+		variance := singular[c] * singular[c] / denominator
+		ratio := 0.0
+		if totalSquares > 0 {
+			ratio = singular[c] * singular[c] / totalSquares
+		}
+		cumulative += ratio
+		model.ExplainedVariance = append(model.ExplainedVariance, variance)
+		model.ExplainedVarianceRatio = append(model.ExplainedVarianceRatio, ratio)
+		model.CumulativeVarianceRatio = append(model.CumulativeVarianceRatio, cumulative)
+	}
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9E3779B97F4A7C15
+	x = (x ^ (x >> 30)) * 0xBF58476D1CE4E5B9
+	x = (x ^ (x >> 27)) * 0x94D049BB133111EB
+	return x ^ (x >> 31)
+}
+
+// saveReductionModel saves a fitted model. The binary files of a PCA model
+// saved before are removed when the new model has none.
+func saveReductionModel(projectDir string, model *ReductionModel) error {
+	dir := filepath.Join(projectDir, reductionDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	if model.components == nil {
+		for _, name := range []string{reductionComponentsFile, reductionMeanFile} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		componentData, err := model.components.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, reductionComponentsFile), componentData, os.ModePerm); err != nil {
+			return err
+		}
+		meanData, err := mat.NewVecDense(len(model.mean), model.mean).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, reductionMeanFile), meanData, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	jsonData, err := json.MarshalIndent(model, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, reductionModelFile), jsonData, os.ModePerm)
+}
+
+// loadReductionModel reads the description of the saved model. The
+// projection of a PCA model is loaded separately by loadProjection.
+func loadReductionModel(projectDir string) (*ReductionModel, error) {
+	fileData, err := os.ReadFile(filepath.Join(projectDir, reductionDir, reductionModelFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading reduction model: %v", err)
+	}
+	var model ReductionModel
+	if err := json.Unmarshal(fileData, &model); err != nil {
+		return nil, fmt.Errorf("error unmarshalling reduction model: %v", err)
+	}
+	return &model, nil
+}
+
+// loadProjection reads the components and mean of a saved PCA model. Random
+// projections need nothing but their seed.
+func (model *ReductionModel) loadProjection(projectDir string) error {
+	if model.Method == reductionRandomProjection {
+		return nil
+	}
+	dir := filepath.Join(projectDir, reductionDir)
+	componentData, err := os.ReadFile(filepath.Join(dir, reductionComponentsFile))
+	if err != nil {
+		return err
+	}
+	var components mat.Dense
+	if err := components.UnmarshalBinary(componentData); err != nil {
+		return err
+	}
+	meanData, err := os.ReadFile(filepath.Join(dir, reductionMeanFile))
+	if err != nil {
+		return err
+	}
+	var mean mat.VecDense
+	if err := mean.UnmarshalBinary(meanData); err != nil {
+		return err
+	}
+	rows, cols := components.Dims()
+	if rows != model.Components || cols != mean.Len() {
+		return fmt.Errorf("saved reduction has %dx%d components and a mean of %d", rows, cols, mean.Len())
+	}
+	model.components = &components
+	model.mean = mat.Col(nil, 0, &mean)
+	return nil
+}
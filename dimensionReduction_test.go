@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// writeLineSpectrograms saves spectrograms that lie close to one line through
+// the feature space, so a single principal component explains them.
+func writeLineSpectrograms(t *testing.T, projectDir string, n int) []string {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		position := rng.NormFloat64()
+		spectrogram := [][]float64{make([]float64, 4), make([]float64, 4)}
+		for f, row := range spectrogram {
+			for j := range row {
+				row[j] = position*float64(f*4+j+1) + 0.01*rng.NormFloat64()
+			}
+		}
+		writeTestSpectrogram(t, projectDir, fmt.Sprintf("%032x", i), spectrogram)
+	}
+	files, err := listSpectrogramFiles(filepath.Join(projectDir, "spectrograms"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestReduceClusteringData(t *testing.T) {
+	tests := []struct {
+		method   string
+		variance bool
+	}{
+		{reductionPCA, true},
+		{reductionIncrementalPCA, true},
+		{reductionRandomProjection, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			_, projectDir := newTestProject(t, "test")
+			files := writeLineSpectrograms(t, projectDir, 30)
+			cfg := defaultClusteringConfig()
+			cfg.Reduction = ReductionConfig{Method: tt.method, Components: 3, BatchSize: 8, Seed: 7}
+
+			md5Hashes, data, model, err := reduceClusteringData(projectDir, files, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rows, cols := data.Dims(); rows != 30 || cols != 3 || len(md5Hashes) != 30 {
+				t.Fatalf("reduced to %dx%d for %d hashes, want 30x3", rows, cols, len(md5Hashes))
+			}
+			if model.InputDims != 8 || model.Samples != 30 {
+				t.Errorf("model of %d dims and %d samples, want 8 and 30", model.InputDims, model.Samples)
+			}
+			if tt.variance {
+				if ratio := model.ExplainedVarianceRatio[0]; ratio < 0.99 {
+					t.Errorf("first component explains %g of the variance, want over 0.99", ratio)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(projectDir, reductionDir, reductionComponentsFile)); (err == nil) != tt.variance {
+				t.Errorf("components file exists %t, want %t", err == nil, tt.variance)
+			}
+		})
+	}
+}
+
+func TestLoadReducedClusteringDataReusesModel(t *testing.T) {
+	_, projectDir := newTestProject(t, "test")
+	files := writeLineSpectrograms(t, projectDir, 20)
+	cfg := defaultClusteringConfig()
+	cfg.Reduction = ReductionConfig{Method: reductionPCA, Components: 2}
+	modelPath := filepath.Join(projectDir, reductionDir, reductionModelFile)
+	readModel := func() []byte {
+		data, err := os.ReadFile(modelPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	_, fitted, err := loadReducedClusteringData(projectDir, files, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := readModel()
+
+	// The same files and settings are projected with the saved model
+	_, projected, err := loadReducedClusteringData(projectDir, files, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readModel(), saved) {
+		t.Error("the model was fitted again for unchanged input")
+	}
+	if !mat.EqualApprox(fitted, projected, 1e-9) {
+		t.Error("projecting with the saved model differs from the fit")
+	}
+
+	// A changed file makes the model stale
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(files[0], later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadReducedClusteringData(projectDir, files, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(readModel(), saved) {
+		t.Error("the model was not fitted again after a file changed")
+	}
+
+	// A random projection has no components, so those of the PCA go
+	cfg.Reduction = ReductionConfig{Method: reductionRandomProjection, Components: 2, Seed: 1}
+	if _, _, err := loadReducedClusteringData(projectDir, files, cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{reductionComponentsFile, reductionMeanFile} {
+		if _, err := os.Stat(filepath.Join(projectDir, reductionDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was left behind by the PCA model", name)
+		}
+	}
+	if model, err := loadReductionModel(projectDir); err != nil || model.Method != reductionRandomProjection {
+		t.Errorf("saved model %+v, error %v", model, err)
+	}
+}
+
+func TestReductionConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   ReductionConfig
+		valid bool
+	}{
+		{"default", defaultReductionConfig(), true},
+		{"none", ReductionConfig{Method: reductionNone}, true},
+		{"unknown method", ReductionConfig{Method: "umap", Components: 2}, false},
+		{"no components", ReductionConfig{Method: reductionPCA}, false},
+		{"batch smaller than components", ReductionConfig{Method: reductionIncrementalPCA, Components: 8, BatchSize: 4}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestRandomProjectionIsDeterministic(t *testing.T) {
+	model := &ReductionModel{Method: reductionRandomProjection, Components: 3, Seed: 5}
+	v := []float64{1, -2, 3, 0.5, 4}
+	first, second := model.transform(v), model.transform(v)
+	var total float64
+	for c := range first {
+		if first[c] != second[c] {
+			t.Fatalf("component %d: %g, then %g", c, first[c], second[c])
+		}
+		total += math.Abs(first[c])
+	}
+	if total == 0 {
+		t.Error("the projection is all zero")
+	}
+}
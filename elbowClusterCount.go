@@ -1,38 +1,129 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math"
-	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
-	"time"
 
 	"gonum.org/v1/gonum/mat"
 )
 
 const (
-	maxK          = 10 // Maximum number of clusters to try
 	elbowBatchSize = 100 // Number of spectrograms to process concurrently
 )
 
+// Feature vectors the clustering stage can work on.
+const (
+	clusteringInputSpectrogram = "spectrogram" // Flattened dB spectrogram
+	clusteringInputMFCC        = "mfcc"        // Mean and deviation of MFCCs and deltas
+)
+
+// Algorithms that AssignClusters can run.
+const (
+	clusteringKMeans        = "kmeans"
+	clusteringDBSCAN        = "dbscan"
+	clusteringHDBSCAN       = "hdbscan"
+	clusteringAgglomerative = "agglomerative"
+)
+
+// ClusteringConfig holds the settings of the clustering stage.
+type ClusteringConfig struct {
+	Input         string              `json:"input"`
+	Reduction     ReductionConfig     `json:"reduction"`
+	Algorithm     string              `json:"algorithm"`
+	MinK          int                 `json:"min_k"`
+	MaxK          int                 `json:"max_k"`
+	Criterion     string              `json:"criterion"`      // Criterion that picks the optimal K
+	GapReferences int                 `json:"gap_references"` // Reference datasets for the gap statistic, 0 skips it
+	KMeans        KMeansConfig        `json:"kmeans"`
+	DBSCAN        DBSCANConfig        `json:"dbscan"`
+	HDBSCAN       HDBSCANConfig       `json:"hdbscan"`
+	Agglomerative AgglomerativeConfig `json:"agglomerative"`
+}
+
+func defaultClusteringConfig() ClusteringConfig {
+	return ClusteringConfig{
+		Input:         clusteringInputSpectrogram,
+		Reduction:     defaultReductionConfig(),
+		Algorithm:     clusteringKMeans,
+		MinK:          1,
+		MaxK:          10,
+		Criterion:     criterionElbow,
+		GapReferences: 5,
+		KMeans:        defaultKMeansConfig(),
+		DBSCAN:        defaultDBSCANConfig(),
+		HDBSCAN:       defaultHDBSCANConfig(),
+		Agglomerative: defaultAgglomerativeConfig(),
+	}
+}
+
+func (cfg ClusteringConfig) validate() error {
+	if cfg.Input != clusteringInputSpectrogram && cfg.Input != clusteringInputMFCC {
+		return fmt.Errorf("unknown clustering input: %s", cfg.Input)
+	}
+	if err := cfg.Reduction.validate(); err != nil {
+		return fmt.Errorf("invalid reduction settings: %v", err)
+	}
+	if cfg.MinK < 1 || cfg.MaxK < cfg.MinK {
+		return fmt.Errorf("invalid K range %d-%d", cfg.MinK, cfg.MaxK)
+	}
+	if cfg.GapReferences < 0 {
+		return fmt.Errorf("gap references cannot be negative")
+	}
+	known := false
+	for _, criterion := range clusterCriteria {
+		known = known || criterion == cfg.Criterion
+	}
+	if !known {
+		return fmt.Errorf("unknown criterion: %s", cfg.Criterion)
+	}
+	if cfg.Criterion == criterionGap && cfg.GapReferences == 0 {
+		return fmt.Errorf("the gap criterion needs at least one reference dataset")
+	}
+	if err := cfg.KMeans.validate(); err != nil {
+		return err
+	}
+	switch cfg.Algorithm {
+	case clusteringKMeans:
+		return nil
+	case clusteringDBSCAN:
+		return cfg.DBSCAN.validate()
+	case clusteringHDBSCAN:
+		return cfg.HDBSCAN.validate()
+	case clusteringAgglomerative:
+		return cfg.Agglomerative.validate()
+	}
+	return fmt.Errorf("unknown clustering algorithm: %s", cfg.Algorithm)
+}
+
+// ElbowResult holds the WCSS curve and the score of every model-selection
+// criterion for each K that was tried. WCSSValues[i] belongs to KValues[i].
 type ElbowResult struct {
-	WCSSValues []float64 `json:"wcss_values"`
-	OptimalK   int        `json:"optimal_k"`
+	WCSSValues []float64                 `json:"wcss_values"`
+	OptimalK   int                       `json:"optimal_k"`
+	KValues    []int                     `json:"k_values"`
+	Criterion  string                    `json:"criterion"`
+	Criteria   map[string]CriterionCurve `json:"criteria"`
 }
 
 func (a *App) CalculateOptimalClusters(projectName string) (int, error) {
-	homeDir, err := os.UserHomeDir()
+	return a.calculateOptimalClusters(context.Background(), projectName, nil)
+}
+
+func (a *App) calculateOptimalClusters(ctx context.Context, projectName string, report progressFunc) (int, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
 	if err != nil {
 		return 0, err
 	}
-	projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
 	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
 
-	files, err := filepath.Glob(filepath.Join(spectrogramsDir, "*.json"))
+	files, err := listSpectrogramFiles(spectrogramsDir)
 	if err != nil {
 		return 0, fmt.Errorf("error listing spectrogram JSON files: %v", err)
 	}
@@ -41,80 +132,39 @@ func (a *App) CalculateOptimalClusters(projectName string) (int, error) {
 		return 0, fmt.Errorf("no spectrogram files found in %s", spectrogramsDir)
 	}
 
-	// Load spectrogram data
-	spectrograms := [][]float64{}
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	fileChan := make(chan string, elbowBatchSize)
-	resultChan := make(chan []float64, elbowBatchSize)
-
-	// Worker to load spectrogram data
-	for i := 0; i < elbowBatchSize; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for filePath := range fileChan {
-				data, err := loadSpectrogramData(filePath)
-				if err != nil {
-					fmt.Printf("error loading spectrogram data: %v\n", err)
-					continue
-				}
-				resultChan <- data
-			}
-		}()
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return 0, err
 	}
-
-	// Send files to the worker
-	go func() {
-		for _, file := range files {
-			fileChan <- file
-		}
-		close(fileChan)
-	}()
-
-	// Collect results
-	go func() {
-		for result := range resultChan {
-			mu.Lock()
-			spectrograms = append(spectrograms, result)
-			mu.Unlock()
-		}
-	}()
-
-	wg.Wait()
-	close(resultChan)
-
-	if len(spectrograms) == 0 {
-		return 0, fmt.Errorf("no valid spectrogram data found")
+	if err := settings.Clustering.validate(); err != nil {
+		return 0, fmt.Errorf("invalid clustering settings: %v", err)
 	}
 
-	// Convert spectrograms to a matrix
-	dataMatrix := mat.NewDense(len(spectrograms), len(spectrograms[0]), nil)
-	for i, s := range spectrograms {
-		dataMatrix.SetRow(i, s)
+	_, dataMatrix, err := loadReducedClusteringData(projectDir, files, settings.Clustering)
+	if err != nil {
+		return 0, err
 	}
 
-	// Use the elbow method to determine the optimal number of clusters
-	wcss, optimalK, err := elbowMethod(dataMatrix)
+	// Score every K in the range to determine the optimal number of clusters
+	elbowResult, err := evaluateClusterCounts(ctx, dataMatrix, settings.Clustering, report)
 	if err != nil {
 		return 0, fmt.Errorf("error calculating optimal number of clusters: %v", err)
 	}
 
-	fmt.Printf("Optimal number of clusters determined by elbow method: %d\n", optimalK)
-
-	// Save the elbow results
-	elbowResult := ElbowResult{
-		WCSSValues: wcss,
-		OptimalK:   optimalK,
+	for _, name := range clusterCriteria {
+		if curve, ok := elbowResult.Criteria[name]; ok {
+			fmt.Printf("Optimal number of clusters by %s: %d\n", name, curve.OptimalK)
+		}
 	}
+	fmt.Printf("Optimal number of clusters determined by %s: %d\n", elbowResult.Criterion, elbowResult.OptimalK)
 
-	err = saveElbowResults(projectDir, elbowResult)
+	// Save the elbow results
+	err = saveElbowResults(projectDir, *elbowResult)
 	if err != nil {
 		return 0, fmt.Errorf("error saving elbow results: %v", err)
 	}
 
-	return optimalK, nil
+	return elbowResult.OptimalK, nil
 }
 
 func saveElbowResults(projectDir string, result ElbowResult) error {
@@ -126,111 +176,134 @@ func saveElbowResults(projectDir string, result ElbowResult) error {
 	return ioutil.WriteFile(filePath, fileData, os.ModePerm)
 }
 
-func loadSpectrogramData(filePath string) ([]float64, error) {
-	fileData, err := ioutil.ReadFile(filePath)
+// loadClusteringData loads the feature vectors of the given spectrogram files
+// as the rows of a matrix. Rows keep the order of files; the MD5 hash of each
+// row is returned alongside. Files that fail to load are skipped.
+func loadClusteringData(files []string, input string) ([]string, *mat.Dense, error) {
+	var rowHashes []string
+	var rows [][]float64
+	err := forEachVectorBatch(files, input, len(files), func(md5Hashes []string, vectors [][]float64) error {
+		rowHashes = append(rowHashes, md5Hashes...)
+		rows = append(rows, vectors...)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	var spectrogram SpectrogramData
-	err = json.Unmarshal(fileData, &spectrogram)
-	if err != nil {
-		return nil, err
-	}
-
-	flattened := []float64{}
-	for _, row := range spectrogram.Spectrogram {
-		flattened = append(flattened, row...)
+	// Convert spectrograms to a matrix
+	dataMatrix := mat.NewDense(len(rows), len(rows[0]), nil)
+	for i, row := range rows {
+		dataMatrix.SetRow(i, row)
 	}
 
-	return flattened, nil
+	return rowHashes, dataMatrix, nil
 }
 
-func elbowMethod(data *mat.Dense) ([]float64, int, error) {
-	wcss := make([]float64, maxK)
-	rows, _ := data.Dims()
+// forEachVectorBatch loads the feature vectors of files batchSize at a time,
+// so callers can process data sets that do not fit in memory. Vectors whose
+// length differs from the first one are skipped.
+func forEachVectorBatch(files []string, input string, batchSize int, fn func(md5Hashes []string, vectors [][]float64) error) error {
+	dims := -1
+	for start := 0; start < len(files); start += batchSize {
+		batch := files[start:min(len(files), start+batchSize)]
+		vectors := make([][]float64, len(batch))
+		var wg sync.WaitGroup
+
+		fileChan := make(chan int, elbowBatchSize)
+
+		// Worker to load spectrogram data
+		for i := 0; i < elbowBatchSize; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for index := range fileChan {
+					data, err := loadFeatureVector(batch[index], input)
+					if err != nil {
+						fmt.Printf("error loading spectrogram data: %v\n", err)
+						continue
+					}
+					vectors[index] = data
+				}
+			}()
+		}
 
-	for k := 1; k <= maxK; k++ {
-		centroids, err := initializeCentroids(data, k)
-		if err != nil {
-			return nil, 0, err
+		for index := range batch {
+			fileChan <- index
 		}
+		close(fileChan)
+		wg.Wait()
 
-		clusters := make([]int, rows)
-		for i := 0; i < 100; i++ { // Run k-means for a fixed number of iterations
-			for j := 0; j < rows; j++ {
-				clusters[j] = closestCentroid(data.RowView(j), centroids)
+		var rows [][]float64
+		var md5Hashes []string
+		for i, vector := range vectors {
+			if vector == nil {
+				continue
+			}
+			md5Hash := strings.TrimSuffix(filepath.Base(batch[i]), ".json")
+			if dims < 0 {
+				dims = len(vector)
+			}
+			if len(vector) != dims {
+				fmt.Printf("skipping spectrogram %s: %d values, expected %d\n", md5Hash, len(vector), dims)
+				continue
 			}
-			centroids = updateCentroids(data, clusters, k)
+			rows = append(rows, vector)
+			md5Hashes = append(md5Hashes, md5Hash)
 		}
 
-		// Calculate the Within-Cluster-Sum of Squares (WCSS)
-		for j := 0; j < rows; j++ {
-			centroid := centroids.RowView(clusters[j])
-			wcss[k-1] += distance(data.RowView(j), centroid)
+		if len(rows) == 0 {
+			continue
 		}
-	}
-
-	// Find the elbow point
-	optimalK := 1
-	for i := 1; i < maxK-1; i++ {
-		angle := math.Abs(wcss[i+1]-wcss[i]) - math.Abs(wcss[i]-wcss[i-1])
-		if angle > 0 {
-			optimalK = i + 1
+		if err := fn(md5Hashes, rows); err != nil {
+			return err
 		}
 	}
 
-	return wcss, optimalK, nil
-}
-
-func initializeCentroids(data *mat.Dense, k int) (*mat.Dense, error) {
-	rows, cols := data.Dims()
-	centroids := mat.NewDense(k, cols, nil)
-
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < k; i++ {
-		randIndex := rand.Intn(rows)
-		centroids.SetRow(i, data.RawRowView(randIndex))
+	if dims < 0 {
+		return fmt.Errorf("no valid spectrogram data found")
 	}
-
-	return centroids, nil
+	return nil
 }
 
-func closestCentroid(point mat.Vector, centroids *mat.Dense) int {
-	closest := 0
-	minDist := distance(point, centroids.RowView(0))
+// loadFeatureVector returns the clustering input of one spectrogram file:
+// the flattened spectrogram, or the MFCC summary from its feature file.
+func loadFeatureVector(filePath, input string) ([]float64, error) {
+	if input != clusteringInputMFCC {
+		return loadSpectrogramData(filePath)
+	}
 
-	for i := 1; i < centroids.RawMatrix().Rows; i++ {
-		d := distance(point, centroids.RowView(i))
-		if d < minDist {
-			closest = i
-			minDist = d
-		}
+	md5Hash := strings.TrimSuffix(filepath.Base(filePath), ".json")
+	fileData, err := ioutil.ReadFile(featuresFilePath(filepath.Dir(filePath), md5Hash))
+	if err != nil {
+		return nil, fmt.Errorf("no features for %s, run feature extraction first: %v", md5Hash, err)
 	}
 
-	return closest
+	var features AudioFeatures
+	if err := json.Unmarshal(fileData, &features); err != nil {
+		return nil, err
+	}
+	return features.summaryVector(), nil
 }
 
-func updateCentroids(data *mat.Dense, clusters []int, k int) *mat.Dense {
-	_, cols := data.Dims()
-	newCentroids := mat.NewDense(k, cols, nil)
-	clusterSizes := make([]int, k)
+func loadSpectrogramData(filePath string) ([]float64, error) {
+	fileData, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, cluster := range clusters {
-		row := data.RowView(i)
-		for j := 0; j < cols; j++ {
-			newCentroids.Set(cluster, j, newCentroids.At(cluster, j)+row.AtVec(j))
-		}
-		clusterSizes[cluster]++
+	var spectrogram SpectrogramData
+	err = json.Unmarshal(fileData, &spectrogram)
+	if err != nil {
+		return nil, err
 	}
 
-	for i := 0; i < k; i++ {
-		for j := 0; j < cols; j++ {
-			newCentroids.Set(i, j, newCentroids.At(i, j)/float64(clusterSizes[i]))
-		}
+	flattened := []float64{}
+	for _, row := range spectrogram.Spectrogram {
+		flattened = append(flattened, row...)
 	}
 
-	return newCentroids
+	return flattened, nil
 }
 
 func distance(a, b mat.Vector) float64 {
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+const embeddingFile = "embedding.json"
+
+// Methods for the 2-D embedding of the clustering input.
+const (
+	embeddingTSNE = "tsne" // Barnes-Hut t-SNE
+	embeddingPCA  = "pca"  // First two principal components; fast but linear
+)
+
+// EmbeddingConfig controls the 2-D embedding used to visualise clusters.
+type EmbeddingConfig struct {
+	Method       string  `json:"method"`
+	Perplexity   float64 `json:"perplexity"`
+	Iterations   int     `json:"iterations"`
+	LearningRate float64 `json:"learning_rate"`
+	Theta        float64 `json:"theta"` // Barnes-Hut accuracy, 0 is exact
+	Seed         int64   `json:"seed"`
+}
+
+func defaultEmbeddingConfig() EmbeddingConfig {
+	return EmbeddingConfig{
+		Method:       embeddingTSNE,
+		Perplexity:   30,
+		Iterations:   1000,
+		LearningRate: 200,
+		Theta:        0.5,
+		Seed:         42,
+	}
+}
+
+func (cfg EmbeddingConfig) validate() error {
+	if cfg.Method != embeddingTSNE && cfg.Method != embeddingPCA {
+		return fmt.Errorf("unknown embedding method: %s", cfg.Method)
+	}
+	if cfg.Method != embeddingTSNE {
+		// PCA has no parameters
+		return nil
+	}
+	if cfg.Perplexity < 1 {
+		return fmt.Errorf("perplexity must be at least 1")
+	}
+	if cfg.Iterations < tsneExaggerationIterations {
+		return fmt.Errorf("t-SNE needs at least %d iterations", tsneExaggerationIterations)
+	}
+	if cfg.LearningRate <= 0 {
+		return fmt.Errorf("learning rate must be positive")
+	}
+	if cfg.Theta < 0 || cfg.Theta >= 1 {
+		return fmt.Errorf("theta must be in [0, 1)")
+	}
+	return nil
+}
+
+// EmbeddingPoint is the 2-D position of one spectrogram. Cluster is nil if
+// the project has not been clustered yet.
+type EmbeddingPoint struct {
+	MD5Hash string  `json:"md5_hash"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Cluster *int    `json:"cluster"`
+}
+
+// Embedding is the persisted 2-D layout of a project's spectrograms.
+type Embedding struct {
+	Method    string           `json:"method"`
+	Config    EmbeddingConfig  `json:"config"`
+	CreatedAt time.Time        `json:"created_at"`
+	Points    []EmbeddingPoint `json:"points"`
+}
+
+// ComputeEmbedding lays out the clustering input of every spectrogram in two
+// dimensions and saves the coordinates with the current cluster assignments.
+func (a *App) ComputeEmbedding(projectName string) (*Embedding, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := settings.Clustering.validate(); err != nil {
+		return nil, fmt.Errorf("invalid clustering settings: %v", err)
+	}
+	if err := settings.Embedding.validate(); err != nil {
+		return nil, fmt.Errorf("invalid embedding settings: %v", err)
+	}
+
+	files, err := listSpectrogramFiles(spectrogramsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing spectrogram JSON files: %v", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no spectrogram files found in %s", spectrogramsDir)
+	}
+
+	md5Hashes, dataMatrix, err := loadReducedClusteringData(projectDir, files, settings.Clustering)
+	if err != nil {
+		return nil, err
+	}
+
+	var coordinates *mat.Dense
+	switch settings.Embedding.Method {
+	case embeddingTSNE:
+		coordinates, err = tsne(dataMatrix, settings.Embedding)
+	case embeddingPCA:
+		coordinates, err = pcaEmbedding(dataMatrix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error computing embedding: %v", err)
+	}
+
+	clusters := map[string]int{}
+	if assignments, err := loadClusterAssignments(projectDir); err == nil {
+		for _, assignment := range assignments.Assignments {
+			clusters[assignment.MD5Hash] = assignment.Cluster
+		}
+	}
+
+	embedding := &Embedding{
+		Method:    settings.Embedding.Method,
+		Config:    settings.Embedding,
+		CreatedAt: time.Now(),
+		Points:    make([]EmbeddingPoint, len(md5Hashes)),
+	}
+	for i, md5Hash := range md5Hashes {
+		point := EmbeddingPoint{MD5Hash: md5Hash, X: coordinates.At(i, 0), Y: coordinates.At(i, 1)}
+		if cluster, ok := clusters[md5Hash]; ok {
+			point.Cluster = &cluster
+		}
+		embedding.Points[i] = point
+	}
+
+	fileData, err := json.Marshal(embedding)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, embeddingFile), fileData, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error saving embedding: %v", err)
+	}
+
+	fmt.Printf("Embedded %d spectrograms with %s\n", len(md5Hashes), embedding.Method)
+	return embedding, nil
+}
+
+// GetEmbedding returns the last embedding computed for a project.
+func (a *App) GetEmbedding(projectName string) (*Embedding, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileData, err := os.ReadFile(filepath.Join(projectDir, embeddingFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading embedding: %v", err)
+	}
+	var embedding Embedding
+	if err := json.Unmarshal(fileData, &embedding); err != nil {
+		return nil, fmt.Errorf("error unmarshalling embedding: %v", err)
+	}
+	return &embedding, nil
+}
+
+// pcaEmbedding projects the rows onto their first two principal components.
+func pcaEmbedding(data *mat.Dense) (*mat.Dense, error) {
+	rows, cols := data.Dims()
+	centred := mat.DenseCopyOf(data)
+	mean := make([]float64, cols)
+	for i := 0; i < rows; i++ {
+		floats.Add(mean, centred.RawRowView(i))
+	}
+	floats.Scale(1/float64(rows), mean)
+	for i := 0; i < rows; i++ {
+		floats.Sub(centred.RawRowView(i), mean)
+	}
+
+	_, axes, err := principalAxes(centred, 2)
+	if err != nil {
+		return nil, err
+	}
+	components, _ := axes.Dims()
+
+	coordinates := mat.NewDense(rows, 2, nil)
+	coordinates.Slice(0, rows, 0, components).(*mat.Dense).Mul(centred, axes.T())
+	return coordinates, nil
+}
+
+const (
+	tsneExaggeration           = 12.0
+	tsneExaggerationIterations = 250
+	tsneMinGain                = 0.01
+)
+
+// tsne embeds the rows in two dimensions with Barnes-Hut t-SNE (van der
+// Maaten, 2014): input affinities are computed over the 3*perplexity nearest
+// neighbours and repulsive forces are approximated with a quadtree.
+func tsne(data *mat.Dense, cfg EmbeddingConfig) (*mat.Dense, error) {
+	rows, _ := data.Dims()
+	if rows < 4 {
+		return nil, fmt.Errorf("t-SNE needs at least 4 spectrograms, got %d", rows)
+	}
+	perplexity := math.Min(cfg.Perplexity, float64(rows-1)/3)
+	affinities := tsneAffinities(data, perplexity)
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	y := make([][2]float64, rows)
+	for i := range y {
+		y[i] = [2]float64{rng.NormFloat64() * 1e-4, rng.NormFloat64() * 1e-4}
+	}
+	update := make([][2]float64, rows)
+	gains := make([][2]float64, rows)
+	for i := range gains {
+		gains[i] = [2]float64{1, 1}
+	}
+
+	gradient := make([][2]float64, rows)
+	repulsion := make([][2]float64, rows)
+	normalisers := make([]float64, rows)
+
+	for iteration := 0; iteration < cfg.Iterations; iteration++ {
+		exaggeration, momentum := 1.0, 0.8
+		if iteration < tsneExaggerationIterations {
+			exaggeration, momentum = tsneExaggeration, 0.5
+		}
+
+		tree := newQuadTree(y)
+		parallelRows(rows, func(i int) {
+			repulsion[i] = [2]float64{}
+			normalisers[i] = tree.repulsion(y, i, cfg.Theta, &repulsion[i])
+		})
+		z := floats.Sum(normalisers)
+
+		for i, neighbours := range affinities {
+			var attraction [2]float64
+			for _, n := range neighbours {
+				dx, dy := y[i][0]-y[n.index][0], y[i][1]-y[n.index][1]
+				q := 1 / (1 + dx*dx + dy*dy)
+				attraction[0] += n.p * q * dx
+				attraction[1] += n.p * q * dy
+			}
+			for d := 0; d < 2; d++ {
+				gradient[i][d] = 4 * (exaggeration*attraction[d] - repulsion[i][d]/z)
+			}
+		}
+
+		// Gradient descent with momentum and per-parameter gains.
+		var mean [2]float64
+		for i := range y {
+			for d := 0; d < 2; d++ {
+				if (gradient[i][d] > 0) != (update[i][d] > 0) {
+					gains[i][d] += 0.2
+				} else {
+					gains[i][d] = math.Max(gains[i][d]*0.8, tsneMinGain)
+				}
+				update[i][d] = momentum*update[i][d] - cfg.LearningRate*gains[i][d]*gradient[i][d]
+				y[i][d] += update[i][d]
+				mean[d] += y[i][d]
+			}
+		}
+		for i := range y {
+			y[i][0] -= mean[0] / float64(rows)
+			y[i][1] -= mean[1] / float64(rows)
+		}
+
+		if (iteration+1)%100 == 0 {
+			fmt.Printf("t-SNE: iteration %d/%d\n", iteration+1, cfg.Iterations)
+		}
+	}
+
+	coordinates := mat.NewDense(rows, 2, nil)
+	for i, point := range y {
+		coordinates.SetRow(i, point[:])
+	}
+	return coordinates, nil
+}
+
+type tsneNeighbour struct {
+	index int
+	p     float64
+}
+
+// tsneAffinities returns the symmetric joint probabilities p_ij, each row
+// listing its non-zero entries.
+func tsneAffinities(data *mat.Dense, perplexity float64) [][]tsneNeighbour {
+	rows, _ := data.Dims()
+	k := min(rows-1, max(1, int(3*perplexity)))
+	conditional := make([][]tsneNeighbour, rows)
+
+	parallelRows(rows, func(i int) {
+		row := data.RawRowView(i)
+		candidates := make([]tsneNeighbour, 0, rows-1)
+		for j := 0; j < rows; j++ {
+			if j != i {
+				candidates = append(candidates, tsneNeighbour{index: j, p: squaredDistance(row, data.RawRowView(j))})
+			}
+		}
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].p < candidates[b].p })
+		neighbours := candidates[:k]
+
+		distances := make([]float64, k)
+		for n, neighbour := range neighbours {
+			distances[n] = neighbour.p
+		}
+		probabilities := conditionalProbabilities(distances, perplexity)
+		for n := range neighbours {
+			neighbours[n].p = probabilities[n]
+		}
+		conditional[i] = append([]tsneNeighbour(nil), neighbours...)
+	})
+
+	joint := make([]map[int]float64, rows)
+	for i := range joint {
+		joint[i] = map[int]float64{}
+	}
+	for i, neighbours := range conditional {
+		for _, n := range neighbours {
+			p := n.p / (2 * float64(rows))
+			joint[i][n.index] += p
+			joint[n.index][i] += p
+		}
+	}
+
+	affinities := make([][]tsneNeighbour, rows)
+	for i, row := range joint {
+		for j, p := range row {
+			affinities[i] = append(affinities[i], tsneNeighbour{index: j, p: p})
+		}
+		sort.Slice(affinities[i], func(a, b int) bool { return affinities[i][a].index < affinities[i][b].index })
+	}
+	return affinities
+}
+
+// conditionalProbabilities finds by bisection the Gaussian precision whose
+// distribution over the given squared distances has the target perplexity.
+func conditionalProbabilities(distances []float64, perplexity float64) []float64 {
+	target := math.Log(perplexity)
+	probabilities := make([]float64, len(distances))
+	beta, low, high := 1.0, 0.0, math.Inf(1)
+
+	// Distances are relative to the nearest neighbour for numerical stability.
+	nearest := distances[0]
+	for step := 0; step < 200; step++ {
+		var sum, weighted float64
+		for n, d := range distances {
+			probabilities[n] = math.Exp(-beta * (d - nearest))
+			sum += probabilities[n]
+			weighted += probabilities[n] * (d - nearest)
+		}
+		entropy := math.Log(sum) + beta*weighted/sum
+		for n := range probabilities {
+			probabilities[n] /= sum
+		}
+
+		if math.Abs(entropy-target) < 1e-5 {
+			break
+		}
+		if entropy > target {
+			low = beta
+			if math.IsInf(high, 1) {
+				beta *= 2
+			} else {
+				beta = (beta + high) / 2
+			}
+		} else {
+			high = beta
+			beta = (beta + low) / 2
+		}
+	}
+	return probabilities
+}
+
+// quadTree summarises the embedding for Barnes-Hut: each node holds the
+// centre of mass and number of the points inside its square.
+type quadTree struct {
+	centreX, centreY, halfWidth float64
+	massX, massY                float64
+	count                       int
+	points                      []int // Only set on leaves
+	children                    *[4]quadTree
+}
+
+const quadTreeMinWidth = 1e-10
+
+func newQuadTree(y [][2]float64) *quadTree {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range y {
+		minX, maxX = math.Min(minX, p[0]), math.Max(maxX, p[0])
+		minY, maxY = math.Min(minY, p[1]), math.Max(maxY, p[1])
+	}
+	tree := &quadTree{
+		centreX:   (minX + maxX) / 2,
+		centreY:   (minY + maxY) / 2,
+		halfWidth: math.Max(maxX-minX, maxY-minY)/2 + 1e-5,
+	}
+	for i := range y {
+		tree.insert(y, i)
+	}
+	return tree
+}
+
+func (node *quadTree) insert(y [][2]float64, i int) {
+	node.massX = (node.massX*float64(node.count) + y[i][0]) / float64(node.count+1)
+	node.massY = (node.massY*float64(node.count) + y[i][1]) / float64(node.count+1)
+	node.count++
+
+	if node.children == nil {
+		if len(node.points) == 0 || node.halfWidth < quadTreeMinWidth {
+			node.points = append(node.points, i)
+			return
+		}
+		// Split the leaf and push its points down.
+		node.children = &[4]quadTree{}
+		for q := range node.children {
+			child := &node.children[q]
+			child.halfWidth = node.halfWidth / 2
+			child.centreX = node.centreX + child.halfWidth*float64(2*(q&1)-1)
+			child.centreY = node.centreY + child.halfWidth*float64(2*(q>>1)-1)
+		}
+		for _, p := range node.points {
+			node.child(y[p]).insert(y, p)
+		}
+		node.points = nil
+	}
+	node.child(y[i]).insert(y, i)
+}
+
+func (node *quadTree) child(p [2]float64) *quadTree {
+	q := 0
+	if p[0] > node.centreX {
+		q |= 1
+	}
+	if p[1] > node.centreY {
+		q |= 2
+	}
+	return &node.children[q]
+}
+
+// repulsion adds the unnormalised repulsive force on point i to force and
+// returns its contribution to the normalisation term Z.
+func (node *quadTree) repulsion(y [][2]float64, i int, theta float64, force *[2]float64) float64 {
+	if node.count == 0 {
+		return 0
+	}
+	count := float64(node.count)
+	if node.children == nil {
+		for _, p := range node.points {
+			if p == i {
+				count--
+			}
+		}
+		if count == 0 {
+			return 0
+		}
+	}
+
+	dx, dy := y[i][0]-node.massX, y[i][1]-node.massY
+	squared := dx*dx + dy*dy
+	if node.children == nil || 2*node.halfWidth < theta*math.Sqrt(squared) {
+		q := 1 / (1 + squared)
+		force[0] += count * q * q * dx
+		force[1] += count * q * q * dy
+		return count * q
+	}
+
+	var z float64
+	for c := range node.children {
+		z += node.children[c].repulsion(y, i, theta, force)
+	}
+	return z
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestTSNESeparatesBlobs(t *testing.T) {
+	data := blobs([][]float64{{0, 0, 0, 0}, {20, 20, 20, 20}}, 15, 1, rand.New(rand.NewSource(5)))
+	cfg := defaultEmbeddingConfig()
+	cfg.Perplexity = 5
+	cfg.Iterations = 400
+	cfg.LearningRate = 10 // The default suits thousands of points, not 30
+	coordinates, err := tsne(data, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The nearest neighbour of every point in the embedding is from its own blob
+	for i := 0; i < 30; i++ {
+		nearest, nearestDistance := -1, math.Inf(1)
+		for j := 0; j < 30; j++ {
+			d := math.Hypot(coordinates.At(i, 0)-coordinates.At(j, 0), coordinates.At(i, 1)-coordinates.At(j, 1))
+			if j != i && d < nearestDistance {
+				nearest, nearestDistance = j, d
+			}
+		}
+		if nearest/15 != i/15 {
+			t.Errorf("the nearest neighbour of point %d is point %d of the other blob", i, nearest)
+		}
+	}
+}
+
+func TestTSNERejectsTooFewRows(t *testing.T) {
+	if _, err := tsne(mat.NewDense(3, 2, nil), defaultEmbeddingConfig()); err == nil {
+		t.Error("3 rows were embedded")
+	}
+}
+
+func TestConditionalProbabilitiesMatchPerplexity(t *testing.T) {
+	distances := []float64{0.5, 1, 1.5, 2, 4, 8, 9, 12, 20, 30}
+	for _, perplexity := range []float64{1.5, 3, 6} {
+		probabilities := conditionalProbabilities(distances, perplexity)
+		var sum, entropy float64
+		for _, p := range probabilities {
+			sum += p
+			if p > 0 {
+				entropy -= p * math.Log(p)
+			}
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("perplexity %g: probabilities sum to %g", perplexity, sum)
+		}
+		if got := math.Exp(entropy); math.Abs(got-perplexity) > 1e-3 {
+			t.Errorf("perplexity %g: got %g", perplexity, got)
+		}
+	}
+}
+
+func TestPCAEmbeddingKeepsLine(t *testing.T) {
+	// Points on a line in 3-D land on the first axis
+	data := mat.NewDense(4, 3, []float64{0, 0, 0, 1, 2, 3, 2, 4, 6, 3, 6, 9})
+	coordinates, err := pcaEmbedding(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := math.Sqrt(14)
+	for i := 0; i < 4; i++ {
+		if got := math.Abs(coordinates.At(i, 0) - coordinates.At(0, 0)); math.Abs(got-float64(i)*step) > 1e-9 {
+			t.Errorf("point %d is %g from the first, want %g", i, got, float64(i)*step)
+		}
+		if math.Abs(coordinates.At(i, 1)) > 1e-9 {
+			t.Errorf("point %d has second coordinate %g", i, coordinates.At(i, 1))
+		}
+	}
+}
+
+func TestEmbeddingConfigValidate(t *testing.T) {
+	tsneConfig := func(change func(cfg *EmbeddingConfig)) EmbeddingConfig {
+		cfg := defaultEmbeddingConfig()
+		change(&cfg)
+		return cfg
+	}
+	tests := []struct {
+		name  string
+		cfg   EmbeddingConfig
+		valid bool
+	}{
+		{"default", defaultEmbeddingConfig(), true},
+		{"unknown method", tsneConfig(func(cfg *EmbeddingConfig) { cfg.Method = "umap" }), false},
+		{"perplexity below 1", tsneConfig(func(cfg *EmbeddingConfig) { cfg.Perplexity = 0.5 }), false},
+		{"too few iterations", tsneConfig(func(cfg *EmbeddingConfig) { cfg.Iterations = 100 }), false},
+		{"no learning rate", tsneConfig(func(cfg *EmbeddingConfig) { cfg.LearningRate = 0 }), false},
+		{"theta of 1", tsneConfig(func(cfg *EmbeddingConfig) { cfg.Theta = 1 }), false},
+		{"PCA ignores t-SNE parameters", EmbeddingConfig{Method: embeddingPCA}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	evaluationFile        = "evaluation.json" // In the classifier directory
+	evaluationCurvePoints = 200               // Maximum points kept of each ROC and PR curve
+)
+
+// Evaluation methods.
+const (
+	evaluationHoldout = "holdout" // Score the saved model on the spectrograms it held out
+	evaluationKFold   = "kfold"   // Retrain the saved model's configuration on k folds
+)
+
+// EvaluationConfig selects how the classifier is evaluated.
+type EvaluationConfig struct {
+	Method string `json:"method"`
+	Folds  int    `json:"folds"` // k of k-fold cross-validation
+}
+
+func defaultEvaluationConfig() EvaluationConfig {
+	return EvaluationConfig{Method: evaluationHoldout, Folds: 5}
+}
+
+func (cfg EvaluationConfig) validate() error {
+	switch cfg.Method {
+	case evaluationHoldout:
+	case evaluationKFold:
+		if cfg.Folds < 2 {
+			return fmt.Errorf("k-fold cross-validation needs at least 2 folds")
+		}
+	default:
+		return fmt.Errorf("unknown evaluation method: %s", cfg.Method)
+	}
+	return nil
+}
+
+// EvaluationReport describes how well a classifier predicts the labels of
+// spectrograms it was not trained on.
+type EvaluationReport struct {
+	Method          string              `json:"method"`
+	Folds           int                 `json:"folds,omitempty"`
+	Model           string              `json:"model"`
+	LabelLevel      int                 `json:"label_level"`
+	Classes         []string            `json:"classes"`
+	Samples         int                 `json:"samples"`
+	Accuracy        float64             `json:"accuracy"`
+	ConfusionMatrix [][]int             `json:"confusion_matrix"` // [actual][predicted], in class order
+	PerClass        []ClassEvaluation   `json:"per_class"`
+	MacroAverage    AveragedMetrics     `json:"macro_average"` // Over classes that occur or are predicted
+	MicroAverage    AveragedMetrics     `json:"micro_average"`
+	Misclassified   []MisclassifiedItem `json:"misclassified"`
+	CreatedAt       time.Time           `json:"created_at"`
+}
+
+// ClassEvaluation holds the one-vs-rest metrics of a class. The curves and
+// areas are empty when the class has no positive or no negative samples.
+type ClassEvaluation struct {
+	Class            string     `json:"class"`
+	Support          int        `json:"support"` // Samples of the class
+	Precision        float64    `json:"precision"`
+	Recall           float64    `json:"recall"`
+	F1               float64    `json:"f1"`
+	ROCAUC           float64    `json:"roc_auc"`
+	AveragePrecision float64    `json:"average_precision"`
+	ROC              []ROCPoint `json:"roc"`
+	PR               []PRPoint  `json:"pr"`
+}
+
+// ROCPoint is the operating point when probabilities of at least Threshold
+// count as the class.
+type ROCPoint struct {
+	Threshold         float64 `json:"threshold"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+	TruePositiveRate  float64 `json:"true_positive_rate"`
+}
+
+// PRPoint is the precision and recall when probabilities of at least
+// Threshold count as the class.
+type PRPoint struct {
+	Threshold float64 `json:"threshold"`
+	Recall    float64 `json:"recall"`
+	Precision float64 `json:"precision"`
+}
+
+// AveragedMetrics combines the metrics of all classes.
+type AveragedMetrics struct {
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+}
+
+// MisclassifiedItem is a spectrogram whose most probable class is wrong.
+type MisclassifiedItem struct {
+	MD5Hash    string  `json:"md5_hash"`
+	SourceFile string  `json:"source_file"`
+	StartTime  float64 `json:"start_time"`
+	EndTime    float64 `json:"end_time"`
+	Actual     string  `json:"actual"`
+	Predicted  string  `json:"predicted"`
+	Confidence float64 `json:"confidence"` // Probability of the predicted class
+	Fold       int     `json:"fold,omitempty"`
+}
+
+// evaluationPrediction is the prediction for one evaluated spectrogram.
+type evaluationPrediction struct {
+	md5Hash       string
+	actual        int
+	probabilities []float64
+	fold          int // 1-based, 0 for the held-out split
+}
+
+// EvaluateClassifier evaluates the saved classifier of a project as the
+// evaluation settings ask and saves the report as
+// classifier/evaluation.json.
+func (a *App) EvaluateClassifier(projectName string) (*EvaluationReport, error) {
+	return a.evaluateClassifier(context.Background(), projectName, nil)
+}
+
+func (a *App) evaluateClassifier(ctx context.Context, projectName string, report progressFunc) (*EvaluationReport, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	cfg := settings.Evaluation
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid evaluation settings: %v", err)
+	}
+	model, err := loadClassifierModel(projectDir)
+	if err != nil {
+		return nil, fmt.Errorf("train a classifier first: %v", err)
+	}
+	labels, err := a.GetSpectrogramLabels(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []string
+	var predictions []evaluationPrediction
+	if cfg.Method == evaluationKFold {
+		classes, predictions, err = crossValidate(ctx, projectDir, model.Config, labels, cfg.Folds, report)
+	} else {
+		classes = model.Classes
+		predictions, err = evaluateHoldout(projectDir, model, labels)
+		report.update(1, 1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	evaluation := buildEvaluationReport(classes, predictions)
+	evaluation.Method = cfg.Method
+	if cfg.Method == evaluationKFold {
+		evaluation.Folds = cfg.Folds
+	}
+	evaluation.Model = model.Model
+	evaluation.LabelLevel = model.LabelLevel
+	for i := range evaluation.Misclassified {
+		item := &evaluation.Misclassified[i]
+		spectrogram, err := loadSpectrogramFile(filepath.Join(projectDir, "spectrograms", item.MD5Hash+".json"))
+		if err != nil {
+			continue
+		}
+		item.SourceFile, item.StartTime, item.EndTime = spectrogram.SourceFile, spectrogram.StartTime, spectrogram.EndTime
+	}
+
+	if err := saveEvaluationReport(projectDir, evaluation); err != nil {
+		return nil, a.LogError(projectName, err, "error saving evaluation report")
+	}
+	fmt.Printf("Evaluated %s classifier on %d spectrograms: accuracy %.3f, macro F1 %.3f\n", model.Model, evaluation.Samples, evaluation.Accuracy, evaluation.MacroAverage.F1)
+	return evaluation, nil
+}
+
+// GetEvaluationReport returns the last evaluation report of a project.
+func (a *App) GetEvaluationReport(projectName string) (*EvaluationReport, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileData, err := os.ReadFile(filepath.Join(projectDir, classifierDir, evaluationFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading evaluation report: %v", err)
+	}
+	var report EvaluationReport
+	if err := json.Unmarshal(fileData, &report); err != nil {
+		return nil, fmt.Errorf("error unmarshalling evaluation report: %v", err)
+	}
+	return &report, nil
+}
+
+func saveEvaluationReport(projectDir string, report *EvaluationReport) error {
+	if err := os.MkdirAll(filepath.Join(projectDir, classifierDir), os.ModePerm); err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(projectDir, classifierDir, evaluationFile), jsonData, os.ModePerm)
+}
+
+// evaluateHoldout predicts the held-out spectrograms of a saved model that
+// still carry a label of one of its classes.
+func evaluateHoldout(projectDir string, model *ClassifierModel, labels map[string]string) ([]evaluationPrediction, error) {
+	predictor, err := newClassifierPredictor(model)
+	if err != nil {
+		return nil, err
+	}
+	classIndex := map[string]int{}
+	for i, class := range model.Classes {
+		classIndex[class] = i
+	}
+
+	var predictions []evaluationPrediction
+	for _, md5Hash := range model.ValidationSamples {
+		label, ok := labels[md5Hash]
+		if !ok {
+			continue
+		}
+		class, ok := classIndex[truncateLabel(label, model.LabelLevel)]
+		if !ok {
+			fmt.Printf("skipping held-out spectrogram %s: label %s is not a class of the classifier\n", md5Hash, label)
+			continue
+		}
+		predictions = append(predictions, evaluationPrediction{md5Hash: md5Hash, actual: class})
+	}
+	if len(predictions) == 0 {
+		return nil, fmt.Errorf("the classifier has no labelled held-out spectrograms; train it with a validation split or use k-fold evaluation")
+	}
+
+	spectrogramsDir := filepath.Join(projectDir, "spectrograms")
+	inputs := make([][]float64, len(predictions))
+	errs := make([]error, len(predictions))
+	parallelRows(len(predictions), func(i int) {
+		inputs[i], errs[i] = predictor.loadInput(spectrogramsDir, predictions[i].md5Hash)
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("error loading held-out spectrogram %s: %v", predictions[i].md5Hash, err)
+		}
+	}
+
+	probabilities := predictor.predict(inputs)
+	for i := range predictions {
+		predictions[i].probabilities = probabilities.RawRowView(i)
+	}
+	return predictions, nil
+}
+
+// crossValidate trains a model of cfg on all but one of k stratified folds
+// of the labelled spectrograms, k times, and predicts each fold with the
+// model that did not see it. Each model picks its best epoch on a
+// validation split of its own training folds.
+func crossValidate(ctx context.Context, projectDir string, cfg TrainingConfig, labels map[string]string, k int, report progressFunc) ([]string, []evaluationPrediction, error) {
+	dataset, err := loadTrainingDataset(projectDir, labels, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dataset.targets) < k {
+		return nil, nil, fmt.Errorf("%d labelled spectrograms are too few for %d folds", len(dataset.targets), k)
+	}
+	// Every fold trains on at least one spectrogram of each class only if no
+	// class is held out whole in one test fold.
+	counts := make([]int, len(dataset.classes))
+	for _, target := range dataset.targets {
+		counts[target]++
+	}
+	for class, count := range counts {
+		if count < 2 {
+			return nil, nil, fmt.Errorf("class %s has %d labelled spectrogram, k-fold cross-validation needs at least 2 per class", dataset.classes[class], count)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed))
+	folds := stratifiedFolds(dataset.targets, len(dataset.classes), k, rng)
+	var predictions []evaluationPrediction
+	for f, test := range folds {
+		var rest []int
+		for g, fold := range folds {
+			if g != f {
+				rest = append(rest, fold...)
+			}
+		}
+		sort.Ints(rest)
+		restTargets := make([]int, len(rest))
+		for i, row := range rest {
+			restTargets[i] = dataset.targets[row]
+		}
+		trainIndices, validationIndices := stratifiedSplit(restTargets, len(dataset.classes), cfg.ValidationSplit, rng)
+		train, validation := make([]int, len(trainIndices)), make([]int, len(validationIndices))
+		for i, index := range trainIndices {
+			train[i] = rest[index]
+		}
+		for i, index := range validationIndices {
+			validation[i] = rest[index]
+		}
+
+		fmt.Printf("Fold %d/%d: training on %d spectrograms, testing on %d\n", f+1, k, len(train), len(test))
+		model, err := buildClassifier(ctx, dataset, train, validation, cfg, rng, func(processed, total int) {
+			report.update(f*total+processed, k*total)
+		}, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		predictor, err := newClassifierPredictor(model)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		inputs := make([][]float64, len(test))
+		for i, row := range test {
+			inputs[i] = dataset.vectors[row]
+		}
+		probabilities := predictor.predict(inputs)
+		for i, row := range test {
+			predictions = append(predictions, evaluationPrediction{
+				md5Hash:       dataset.md5Hashes[row],
+				actual:        dataset.targets[row],
+				probabilities: probabilities.RawRowView(i),
+				fold:          f + 1,
+			})
+		}
+	}
+	return dataset.classes, predictions, nil
+}
+
+// stratifiedFolds deals the rows of every class, shuffled, across k folds
+// so each fold has about the same class balance and size.
+func stratifiedFolds(targets []int, classes, k int, rng *rand.Rand) [][]int {
+	byClass := make([][]int, classes)
+	for row, target := range targets {
+		byClass[target] = append(byClass[target], row)
+	}
+	folds := make([][]int, k)
+	next := 0
+	for _, rows := range byClass {
+		rng.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+		for _, row := range rows {
+			folds[next%k] = append(folds[next%k], row)
+			next++
+		}
+	}
+	for _, fold := range folds {
+		sort.Ints(fold)
+	}
+	return folds
+}
+
+// buildEvaluationReport computes the metrics of a set of predictions. The
+// metadata of the report and the source of misclassified items are left to
+// the caller.
+func buildEvaluationReport(classes []string, predictions []evaluationPrediction) *EvaluationReport {
+	report := &EvaluationReport{
+		Classes:         classes,
+		Samples:         len(predictions),
+		ConfusionMatrix: make([][]int, len(classes)),
+		PerClass:        make([]ClassEvaluation, len(classes)),
+		Misclassified:   []MisclassifiedItem{},
+		CreatedAt:       time.Now(),
+	}
+	for i := range report.ConfusionMatrix {
+		report.ConfusionMatrix[i] = make([]int, len(classes))
+	}
+
+	correct := 0
+	for _, prediction := range predictions {
+		predicted := argmax(prediction.probabilities)
+		report.ConfusionMatrix[prediction.actual][predicted]++
+		if predicted == prediction.actual {
+			correct++
+			continue
+		}
+		report.Misclassified = append(report.Misclassified, MisclassifiedItem{
+			MD5Hash:    prediction.md5Hash,
+			Actual:     classes[prediction.actual],
+			Predicted:  classes[predicted],
+			Confidence: prediction.probabilities[predicted],
+			Fold:       prediction.fold,
+		})
+	}
+	if len(predictions) > 0 {
+		report.Accuracy = float64(correct) / float64(len(predictions))
+	}
+
+	totalTP, totalFP, totalFN := 0, 0, 0
+	averaged := 0
+	for c, class := range classes {
+		tp, fp, fn := report.ConfusionMatrix[c][c], 0, 0
+		for other := range classes {
+			if other != c {
+				fp += report.ConfusionMatrix[other][c]
+				fn += report.ConfusionMatrix[c][other]
+			}
+		}
+		totalTP, totalFP, totalFN = totalTP+tp, totalFP+fp, totalFN+fn
+
+		metrics := &report.PerClass[c]
+		metrics.Class = class
+		metrics.Support = tp + fn
+		metrics.Precision, metrics.Recall, metrics.F1 = precisionRecallF1(tp, fp, fn)
+		metrics.ROC, metrics.ROCAUC, metrics.PR, metrics.AveragePrecision = classCurves(predictions, c)
+		if tp+fp+fn > 0 {
+			report.MacroAverage.Precision += metrics.Precision
+			report.MacroAverage.Recall += metrics.Recall
+			report.MacroAverage.F1 += metrics.F1
+			averaged++
+		}
+	}
+	if averaged > 0 {
+		report.MacroAverage.Precision /= float64(averaged)
+		report.MacroAverage.Recall /= float64(averaged)
+		report.MacroAverage.F1 /= float64(averaged)
+	}
+	report.MicroAverage.Precision, report.MicroAverage.Recall, report.MicroAverage.F1 = precisionRecallF1(totalTP, totalFP, totalFN)
+	return report
+}
+
+// precisionRecallF1 computes the metrics from counts, taking 0 where they
+// are undefined.
+func precisionRecallF1(tp, fp, fn int) (precision, recall, f1 float64) {
+	if tp+fp > 0 {
+		precision = float64(tp) / float64(tp+fp)
+	}
+	if tp+fn > 0 {
+		recall = float64(tp) / float64(tp+fn)
+	}
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
+
+// classCurves computes the one-vs-rest ROC and precision-recall curves of a
+// class from the predicted probabilities, with one point per distinct
+// probability, thinned to evaluationCurvePoints. The areas use every point.
+func classCurves(predictions []evaluationPrediction, class int) (roc []ROCPoint, rocAUC float64, pr []PRPoint, averagePrecision float64) {
+	order := make([]int, len(predictions))
+	positives := 0
+	for i, prediction := range predictions {
+		order[i] = i
+		if prediction.actual == class {
+			positives++
+		}
+	}
+	negatives := len(predictions) - positives
+	if positives == 0 || negatives == 0 {
+		return []ROCPoint{}, 0, []PRPoint{}, 0
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return predictions[order[i]].probabilities[class] > predictions[order[j]].probabilities[class]
+	})
+
+	tp, fp := 0, 0
+	previousFPR, previousTPR, previousRecall := 0.0, 0.0, 0.0
+	for i, index := range order {
+		if predictions[index].actual == class {
+			tp++
+		} else {
+			fp++
+		}
+		threshold := predictions[index].probabilities[class]
+		if i+1 < len(order) && predictions[order[i+1]].probabilities[class] == threshold {
+			continue // Tied scores cross the threshold together
+		}
+
+		fpr, tpr := float64(fp)/float64(negatives), float64(tp)/float64(positives)
+		precision := float64(tp) / float64(tp+fp)
+		rocAUC += (fpr - previousFPR) * (tpr + previousTPR) / 2
+		averagePrecision += (tpr - previousRecall) * precision
+		previousFPR, previousTPR, previousRecall = fpr, tpr, tpr
+
+		roc = append(roc, ROCPoint{Threshold: threshold, FalsePositiveRate: fpr, TruePositiveRate: tpr})
+		pr = append(pr, PRPoint{Threshold: threshold, Recall: tpr, Precision: precision})
+	}
+	return thinCurve(roc), rocAUC, thinCurve(pr), averagePrecision
+}
+
+// thinCurve keeps at most evaluationCurvePoints evenly spaced points,
+// including the first and the last.
+func thinCurve[T any](points []T) []T {
+	if len(points) <= evaluationCurvePoints {
+		return points
+	}
+	thinned := make([]T, evaluationCurvePoints)
+	for i := range thinned {
+		thinned[i] = points[int(math.Round(float64(i)*float64(len(points)-1)/float64(evaluationCurvePoints-1)))]
+	}
+	return thinned
+}
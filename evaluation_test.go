@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestBuildEvaluationReport(t *testing.T) {
+	classes := []string{"a", "b", "c"}
+	predictions := []evaluationPrediction{
+		{md5Hash: "p1", actual: 0, probabilities: []float64{0.7, 0.2, 0.1}},
+		{md5Hash: "p2", actual: 0, probabilities: []float64{0.4, 0.5, 0.1}, fold: 2},
+		{md5Hash: "p3", actual: 1, probabilities: []float64{0.2, 0.6, 0.2}},
+		{md5Hash: "p4", actual: 1, probabilities: []float64{0.1, 0.8, 0.1}},
+		{md5Hash: "p5", actual: 2, probabilities: []float64{0.3, 0.3, 0.4}},
+	}
+	report := buildEvaluationReport(classes, predictions)
+
+	if report.Samples != 5 || !approxEqual(report.Accuracy, 0.8) {
+		t.Errorf("samples %d, accuracy %g; want 5, 0.8", report.Samples, report.Accuracy)
+	}
+	wantMatrix := [][]int{{1, 1, 0}, {0, 2, 0}, {0, 0, 1}}
+	if !reflect.DeepEqual(report.ConfusionMatrix, wantMatrix) {
+		t.Errorf("confusion matrix %v, want %v", report.ConfusionMatrix, wantMatrix)
+	}
+
+	wantClasses := []struct {
+		support                  int
+		precision, recall, f1    float64
+		rocAUC, averagePrecision float64
+	}{
+		{2, 1, 0.5, 2.0 / 3, 1, 1},
+		{2, 2.0 / 3, 1, 0.8, 1, 1},
+		{1, 1, 1, 1, 1, 1},
+	}
+	for c, want := range wantClasses {
+		got := report.PerClass[c]
+		if got.Class != classes[c] || got.Support != want.support ||
+			!approxEqual(got.Precision, want.precision) || !approxEqual(got.Recall, want.recall) || !approxEqual(got.F1, want.f1) ||
+			!approxEqual(got.ROCAUC, want.rocAUC) || !approxEqual(got.AveragePrecision, want.averagePrecision) {
+			t.Errorf("class %s: got %+v, want %+v", classes[c], got, want)
+		}
+	}
+
+	macro, micro := report.MacroAverage, report.MicroAverage
+	if !approxEqual(macro.Precision, 8.0/9) || !approxEqual(macro.Recall, 2.5/3) || !approxEqual(macro.F1, (2.0/3+0.8+1)/3) {
+		t.Errorf("macro average %+v", macro)
+	}
+	if !approxEqual(micro.Precision, 0.8) || !approxEqual(micro.Recall, 0.8) || !approxEqual(micro.F1, 0.8) {
+		t.Errorf("micro average %+v", micro)
+	}
+
+	wantMisclassified := []MisclassifiedItem{{MD5Hash: "p2", Actual: "a", Predicted: "b", Confidence: 0.5, Fold: 2}}
+	if !reflect.DeepEqual(report.Misclassified, wantMisclassified) {
+		t.Errorf("misclassified %+v, want %+v", report.Misclassified, wantMisclassified)
+	}
+}
+
+func TestClassCurves(t *testing.T) {
+	// One positive ranks below a negative and another ties with one
+	scores := []float64{0.9, 0.8, 0.7, 0.7, 0.2}
+	actual := []int{1, 0, 1, 0, 0}
+	predictions := make([]evaluationPrediction, len(scores))
+	for i, score := range scores {
+		predictions[i] = evaluationPrediction{actual: actual[i], probabilities: []float64{1 - score, score}}
+	}
+
+	roc, rocAUC, pr, averagePrecision := classCurves(predictions, 1)
+	if !approxEqual(rocAUC, 0.75) || !approxEqual(averagePrecision, 0.75) {
+		t.Errorf("ROC AUC %g, average precision %g; want 0.75, 0.75", rocAUC, averagePrecision)
+	}
+	wantROC := []ROCPoint{
+		{Threshold: 0.9, FalsePositiveRate: 0, TruePositiveRate: 0.5},
+		{Threshold: 0.8, FalsePositiveRate: 1.0 / 3, TruePositiveRate: 0.5},
+		{Threshold: 0.7, FalsePositiveRate: 2.0 / 3, TruePositiveRate: 1},
+		{Threshold: 0.2, FalsePositiveRate: 1, TruePositiveRate: 1},
+	}
+	wantPR := []PRPoint{
+		{Threshold: 0.9, Recall: 0.5, Precision: 1},
+		{Threshold: 0.8, Recall: 0.5, Precision: 0.5},
+		{Threshold: 0.7, Recall: 1, Precision: 0.5},
+		{Threshold: 0.2, Recall: 1, Precision: 0.4},
+	}
+	if len(roc) != len(wantROC) || len(pr) != len(wantPR) {
+		t.Fatalf("%d ROC and %d PR points, want %d and %d", len(roc), len(pr), len(wantROC), len(wantPR))
+	}
+	for i := range wantROC {
+		got, want := roc[i], wantROC[i]
+		if !approxEqual(got.Threshold, want.Threshold) || !approxEqual(got.FalsePositiveRate, want.FalsePositiveRate) || !approxEqual(got.TruePositiveRate, want.TruePositiveRate) {
+			t.Errorf("ROC point %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	for i := range wantPR {
+		got, want := pr[i], wantPR[i]
+		if !approxEqual(got.Threshold, want.Threshold) || !approxEqual(got.Recall, want.Recall) || !approxEqual(got.Precision, want.Precision) {
+			t.Errorf("PR point %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	// Without negatives the curves are undefined
+	roc, rocAUC, pr, averagePrecision = classCurves(predictions[:1], 1)
+	if len(roc) != 0 || len(pr) != 0 || rocAUC != 0 || averagePrecision != 0 {
+		t.Errorf("curves of a class without negatives: %v, %g, %v, %g", roc, rocAUC, pr, averagePrecision)
+	}
+}
@@ -108,11 +108,10 @@ class OSound extends React.Component {
           this.setState({ projectName: "" }); // Clear the input field after creation
         })
         .catch((error) => {
-          console.error(
-            "Failed to create project using Wails, trying Axios:",
-            error
-          );
-          this.createProjectWithAxios(prefixedProjectName); // Fallback to Axios if Wails fails
+          // Wails answered, so the error is the project's (an existing or
+          // invalid name) and Axios would only fail again
+          console.error("Failed to create project using Wails:", error);
+          alert(`Failed to create project: ${error}`);
         });
     } else {
       this.createProjectWithAxios(prefixedProjectName); // Use Axios if Wails is not available or toggle is off
@@ -129,6 +128,11 @@ class OSound extends React.Component {
       })
       .catch((error) => {
         console.error("Failed to create project using Axios:", error);
+        alert(
+          `Failed to create project: ${
+            error.response?.data?.error || error.message
+          }`
+        );
       });
   };
 
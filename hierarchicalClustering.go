@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+const (
+	clusterDendrogramFile = "cluster_dendrogram.json"
+	agglomerativeMaxRows  = 20000 // The condensed distance matrix grows with rows^2
+)
+
+// Linkage criteria for agglomerative clustering.
+const (
+	linkageWard     = "ward"
+	linkageComplete = "complete"
+	linkageAverage  = "average"
+	linkageSingle   = "single"
+)
+
+// AgglomerativeConfig controls agglomerative clustering. With a distance
+// threshold the tree is cut where merges get farther apart than it;
+// otherwise it is cut into the requested number of clusters.
+type AgglomerativeConfig struct {
+	Linkage           string  `json:"linkage"`
+	DistanceThreshold float64 `json:"distance_threshold"`
+}
+
+func defaultAgglomerativeConfig() AgglomerativeConfig {
+	return AgglomerativeConfig{Linkage: linkageWard}
+}
+
+func (cfg AgglomerativeConfig) validate() error {
+	switch cfg.Linkage {
+	case linkageWard, linkageComplete, linkageAverage, linkageSingle:
+	default:
+		return fmt.Errorf("unknown linkage: %s", cfg.Linkage)
+	}
+	if cfg.DistanceThreshold < 0 {
+		return fmt.Errorf("distance threshold cannot be negative")
+	}
+	return nil
+}
+
+// ClusterDendrogram is the full merge tree of an agglomerative clustering run.
+// Leaf i is the spectrogram Leaves[i]; merge i creates node len(Leaves)+i.
+type ClusterDendrogram struct {
+	Linkage string            `json:"linkage"`
+	Leaves  []string          `json:"leaves"`
+	Merges  []DendrogramMerge `json:"merges"`
+}
+
+// DendrogramMerge joins two nodes of the dendrogram at the given distance.
+type DendrogramMerge struct {
+	Left     int     `json:"left"`
+	Right    int     `json:"right"`
+	Distance float64 `json:"distance"`
+	Size     int     `json:"size"` // Leaves under the new node
+}
+
+// GetClusterDendrogram returns the dendrogram of the last agglomerative
+// clustering run.
+func (a *App) GetClusterDendrogram(projectName string) (*ClusterDendrogram, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileData, err := os.ReadFile(filepath.Join(projectDir, clusterDendrogramFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster dendrogram: %v", err)
+	}
+	var dendrogram ClusterDendrogram
+	if err := json.Unmarshal(fileData, &dendrogram); err != nil {
+		return nil, fmt.Errorf("error unmarshalling cluster dendrogram: %v", err)
+	}
+	return &dendrogram, nil
+}
+
+// agglomerative builds the merge tree of the rows with the nearest-neighbour
+// chain algorithm, which is exact for all supported linkages.
+func agglomerative(data *mat.Dense, linkage string) ([]linkageNode, error) {
+	rows, _ := data.Dims()
+	if rows > agglomerativeMaxRows {
+		return nil, fmt.Errorf("agglomerative clustering supports at most %d spectrograms, got %d", agglomerativeMaxRows, rows)
+	}
+	if rows < 2 {
+		return nil, fmt.Errorf("agglomerative clustering needs at least 2 spectrograms")
+	}
+
+	// Condensed upper triangle of the distance matrix.
+	index := func(i, j int) int {
+		if i > j {
+			i, j = j, i
+		}
+		return rows*i - i*(i+1)/2 + j - i - 1
+	}
+	distances := make([]float64, rows*(rows-1)/2)
+	parallelRows(rows, func(i int) {
+		row := data.RawRowView(i)
+		for j := i + 1; j < rows; j++ {
+			distances[index(i, j)] = math.Sqrt(squaredDistance(row, data.RawRowView(j)))
+		}
+	})
+
+	active := make([]bool, rows)
+	size := make([]int, rows)
+	for i := range active {
+		active[i] = true
+		size[i] = 1
+	}
+
+	merges := make([]mergeEdge, 0, rows-1)
+	var chain []int
+	for len(merges) < rows-1 {
+		if len(chain) == 0 {
+			for i := range active {
+				if active[i] {
+					chain = append(chain, i)
+					break
+				}
+			}
+		}
+
+		// Follow nearest neighbours until two clusters are each other's.
+		var x, y int
+		var nearest float64
+		for {
+			x = chain[len(chain)-1]
+			y, nearest = -1, math.Inf(1)
+			if len(chain) > 1 {
+				y = chain[len(chain)-2]
+				nearest = distances[index(x, y)]
+			}
+			for i := range active {
+				if active[i] && i != x && distances[index(x, i)] < nearest {
+					y, nearest = i, distances[index(x, i)]
+				}
+			}
+			if len(chain) > 1 && y == chain[len(chain)-2] {
+				break
+			}
+			chain = append(chain, y)
+		}
+		chain = chain[:len(chain)-2]
+
+		// The merged cluster lives on in slot y.
+		if x > y {
+			x, y = y, x
+		}
+		merges = append(merges, mergeEdge{A: x, B: y, Distance: nearest})
+		nx, ny := float64(size[x]), float64(size[y])
+		active[x] = false
+		size[y] += size[x]
+		for i := range active {
+			if !active[i] || i == y {
+				continue
+			}
+			dx, dy, ni := distances[index(i, x)], distances[index(i, y)], float64(size[i])
+			var d float64
+			switch linkage {
+			case linkageWard:
+				d = math.Sqrt(((ni+nx)*dx*dx + (ni+ny)*dy*dy - ni*nearest*nearest) / (ni + nx + ny))
+			case linkageComplete:
+				d = math.Max(dx, dy)
+			case linkageAverage:
+				d = (nx*dx + ny*dy) / (nx + ny)
+			case linkageSingle:
+				d = math.Min(dx, dy)
+			default:
+				return nil, fmt.Errorf("unknown linkage: %s", linkage)
+			}
+			distances[index(i, y)] = d
+		}
+	}
+
+	return buildLinkageTree(merges, rows), nil
+}
+
+// cutTree labels the leaves of a merge tree by applying merges in order until
+// k clusters remain, or, if threshold is positive, every merge closer than
+// the threshold. Labels are numbered in order of first appearance.
+func cutTree(nodes []linkageNode, rows, k int, threshold float64) []int {
+	parent := make([]int, rows+len(nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+	find := func(x int) int {
+		for parent[x] != x {
+			parent[x] = parent[parent[x]]
+			x = parent[x]
+		}
+		return x
+	}
+
+	for i, node := range nodes {
+		if threshold > 0 && node.Distance > threshold {
+			break
+		}
+		if threshold <= 0 && i >= rows-k {
+			break
+		}
+		id := rows + i
+		parent[find(node.Left)] = id
+		parent[find(node.Right)] = id
+	}
+
+	labels := make([]int, rows)
+	numbering := map[int]int{}
+	for i := range labels {
+		root := find(i)
+		if _, ok := numbering[root]; !ok {
+			numbering[root] = len(numbering)
+		}
+		labels[i] = numbering[root]
+	}
+	return labels
+}
+
+func saveClusterDendrogram(projectDir, linkage string, md5Hashes []string, nodes []linkageNode) error {
+	dendrogram := ClusterDendrogram{
+		Linkage: linkage,
+		Leaves:  md5Hashes,
+		Merges:  make([]DendrogramMerge, len(nodes)),
+	}
+	for i, node := range nodes {
+		dendrogram.Merges[i] = DendrogramMerge{Left: node.Left, Right: node.Right, Distance: node.Distance, Size: node.Size}
+	}
+
+	fileData, err := json.Marshal(dendrogram)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(projectDir, clusterDendrogramFile), fileData, os.ModePerm)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestAgglomerativeMergeDistances(t *testing.T) {
+	data := mat.NewDense(5, 1, []float64{0, 1, 5, 6, 20})
+	tests := []struct {
+		linkage string
+		want    []float64
+	}{
+		{linkageSingle, []float64{1, 1, 4, 14}},
+		{linkageComplete, []float64{1, 1, 6, 20}},
+		{linkageAverage, []float64{1, 1, 5, 17}},
+		{linkageWard, []float64{1, 1, 5 * math.Sqrt2, 17 * math.Sqrt(8.0/5)}},
+	}
+	for _, tt := range tests {
+		nodes, err := agglomerative(data, tt.linkage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != len(tt.want) {
+			t.Fatalf("%s: %d merges, want %d", tt.linkage, len(nodes), len(tt.want))
+		}
+		for i, node := range nodes {
+			if math.Abs(node.Distance-tt.want[i]) > 1e-9 {
+				t.Errorf("%s: merge %d at %g, want %g", tt.linkage, i, node.Distance, tt.want[i])
+			}
+		}
+		if root := nodes[len(nodes)-1]; root.Size != 5 {
+			t.Errorf("%s: root has %d leaves, want 5", tt.linkage, root.Size)
+		}
+	}
+}
+
+func TestCutTree(t *testing.T) {
+	data := mat.NewDense(5, 1, []float64{0, 1, 5, 6, 20})
+	nodes, err := agglomerative(data, linkageSingle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		k         int
+		threshold float64
+		want      []int
+	}{
+		{"one cluster", 1, 0, []int{0, 0, 0, 0, 0}},
+		{"two clusters", 2, 0, []int{0, 0, 0, 0, 1}},
+		{"three clusters", 3, 0, []int{0, 0, 1, 1, 2}},
+		{"every leaf", 5, 0, []int{0, 1, 2, 3, 4}},
+		{"threshold", 0, 2, []int{0, 0, 1, 1, 2}},
+		{"threshold over every merge", 0, 100, []int{0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		if got := cutTree(nodes, 5, tt.k, tt.threshold); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAgglomerativeRejectsTooFewRows(t *testing.T) {
+	if _, err := agglomerative(mat.NewDense(1, 1, []float64{0}), linkageWard); err == nil {
+		t.Error("a single row was clustered")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	predictionsDir     = "predictions" // One subdirectory per inference run
+	inferenceBatchSize = 64            // Chunks predicted at once
+)
+
+// Inference output formats and CSV tables.
+const (
+	predictionFormatJSON    = "json"
+	predictionFormatCSV     = "csv"
+	predictionTableChunks   = "chunks"
+	predictionTableTimeline = "timeline"
+)
+
+// InferenceResult holds the predictions of a classifier for new audio files.
+type InferenceResult struct {
+	ID        string           `json:"id"`
+	Project   string           `json:"project"`
+	Model     string           `json:"model"`
+	Classes   []string         `json:"classes"`
+	Files     []FilePrediction `json:"files"`
+	OutputDir string           `json:"output_dir"` // Where the JSON and CSV outputs were saved
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// FilePrediction holds the predictions for the chunks of one audio file.
+type FilePrediction struct {
+	SourceFile   string             `json:"source_file"`
+	Chunks       []ChunkPrediction  `json:"chunks"`
+	Timeline     []TimelineSegment  `json:"timeline"`
+	ClassSeconds map[string]float64 `json:"class_seconds"` // Length of the timeline per class
+	Error        string             `json:"error,omitempty"`
+}
+
+// ChunkPrediction is the classification of one chunk of an audio file.
+type ChunkPrediction struct {
+	ChunkIndex    int       `json:"chunk_index"`
+	MD5Hash       string    `json:"md5_hash"`
+	StartTime     float64   `json:"start_time"`
+	EndTime       float64   `json:"end_time"`
+	Class         string    `json:"class"`
+	Confidence    float64   `json:"confidence"`
+	Probabilities []float64 `json:"probabilities"` // In the order of the result classes
+}
+
+// TimelineSegment is a run of consecutive, touching chunks of a file
+// predicted as the same class.
+type TimelineSegment struct {
+	Class          string  `json:"class"`
+	StartTime      float64 `json:"start_time"`
+	EndTime        float64 `json:"end_time"`
+	Chunks         int     `json:"chunks"`
+	MeanConfidence float64 `json:"mean_confidence"`
+}
+
+// ClassifyAudioFiles runs audio files through the conversion, activity
+// detection, chunking, spectrogram and feature steps of the project
+// pipeline and classifies every chunk with the saved classifier of the
+// project. Nothing is added to the project's spectrograms; the predictions
+// are saved as JSON and CSV under predictions/<id>. Files that fail are
+// reported in the result; the call only fails if all of them do.
+func (a *App) ClassifyAudioFiles(projectName string, filePaths []string) (*InferenceResult, error) {
+	return a.classifyAudioFiles(context.Background(), projectName, filePaths)
+}
+
+func (a *App) classifyAudioFiles(ctx context.Context, projectName string, filePaths []string) (*InferenceResult, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	if !projectExists(projectDir) {
+		return nil, fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	if len(filePaths) == 0 {
+		return nil, fmt.Errorf("no audio files to classify")
+	}
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	model, err := loadClassifierModel(projectDir)
+	if err != nil {
+		return nil, fmt.Errorf("train a classifier first: %v", err)
+	}
+	predictor, err := newClassifierPredictor(model)
+	if err != nil {
+		return nil, fmt.Errorf("invalid classifier: %v", err)
+	}
+
+	result := &InferenceResult{
+		ID:        newID(),
+		Project:   projectName,
+		Model:     model.Model,
+		Classes:   model.Classes,
+		CreatedAt: time.Now(),
+	}
+	failed := 0
+	for _, filePath := range filePaths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Classifying %s\n", filePath)
+		prediction, err := classifyAudioFile(ctx, predictor, filePath, settings)
+		if err != nil {
+			fmt.Printf("Error classifying %s: %v\n", filePath, err)
+			prediction = FilePrediction{SourceFile: filepath.Base(filePath), Chunks: []ChunkPrediction{}, Timeline: []TimelineSegment{}, ClassSeconds: map[string]float64{}, Error: err.Error()}
+			failed++
+		}
+		result.Files = append(result.Files, prediction)
+	}
+	if failed == len(filePaths) {
+		return nil, fmt.Errorf("no audio file could be classified: %s", result.Files[0].Error)
+	}
+
+	result.OutputDir = filepath.Join(projectDir, predictionsDir, result.ID)
+	if err := saveInferenceResult(result); err != nil {
+		return nil, a.LogError(projectName, err, "error saving predictions")
+	}
+	return result, nil
+}
+
+// classifyAudioFile chunks one audio file as processWAVFile does and
+// predicts every chunk.
+func classifyAudioFile(ctx context.Context, predictor *classifierPredictor, filePath string, settings PipelineSettings) (FilePrediction, error) {
+	prediction := FilePrediction{SourceFile: filepath.Base(filePath), ClassSeconds: map[string]float64{}}
+
+	wavPath := filePath
+	if strings.ToLower(filepath.Ext(filePath)) != ".wav" || settings.Conversion.SampleRate > 0 {
+		tempDir, err := os.MkdirTemp("", "neuralforge-inference-*")
+		if err != nil {
+			return prediction, fmt.Errorf("error creating temp directory: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+		wavPath = filepath.Join(tempDir, strings.TrimSuffix(prediction.SourceFile, filepath.Ext(filePath))+".wav")
+		if err := convertToWAV(ctx, filePath, wavPath, settings.Conversion.SampleRate); err != nil {
+			return prediction, fmt.Errorf("error converting to WAV: %v", err)
+		}
+	}
+
+	r, cleanup, err := openAudio(ctx, wavPath)
+	if err != nil {
+		return prediction, err
+	}
+	defer cleanup()
+	defer r.Close()
+
+	regions := []frameRange{{Start: 0, End: r.Info().NumFrames}}
+	if settings.Activity.Enabled {
+		report, err := detectActivity(r, settings.Activity)
+		if err != nil {
+			return prediction, fmt.Errorf("error detecting activity: %v", err)
+		}
+		regions = report.frameRanges()
+	}
+
+	var chunks []ChunkPrediction
+	var inputs [][]float64
+	flush := func() {
+		if len(inputs) == 0 {
+			return
+		}
+		probabilities := predictor.predict(inputs)
+		for i := range inputs {
+			chunk := &chunks[len(chunks)-len(inputs)+i]
+			chunk.Probabilities = append([]float64(nil), probabilities.RawRowView(i)...)
+			best := argmax(chunk.Probabilities)
+			chunk.Class, chunk.Confidence = predictor.model.Classes[best], chunk.Probabilities[best]
+		}
+		inputs = inputs[:0]
+	}
+	err = forEachChunk(r, filePath, regions, settings.Chunking, func(chunk audioChunk) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		spectrogram, err := generateSpectrogramData(chunk.Samples, settings.Spectrogram)
+		if err != nil {
+			return fmt.Errorf("error generating spectrogram for chunk %d: %v", chunk.Index, err)
+		}
+		input, err := predictor.spectrogramInput(spectrogram, chunk.SampleRate, settings.Spectrogram, settings.Features)
+		if err != nil {
+			return fmt.Errorf("chunk %d does not fit the classifier, were the pipeline settings changed since training? %v", chunk.Index, err)
+		}
+		chunks = append(chunks, ChunkPrediction{
+			ChunkIndex: chunk.Index,
+			MD5Hash:    chunk.MD5(),
+			StartTime:  chunk.StartTime(),
+			EndTime:    chunk.EndTime(),
+		})
+		inputs = append(inputs, input)
+		if len(inputs) == inferenceBatchSize {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return prediction, err
+	}
+	flush()
+
+	prediction.Chunks = chunks
+	if prediction.Chunks == nil {
+		prediction.Chunks = []ChunkPrediction{}
+	}
+	prediction.Timeline = predictionTimeline(chunks)
+	for _, segment := range prediction.Timeline {
+		prediction.ClassSeconds[segment.Class] += segment.EndTime - segment.StartTime
+	}
+	return prediction, nil
+}
+
+// predictionTimeline merges consecutive chunks of the same class that touch
+// or overlap into segments.
+func predictionTimeline(chunks []ChunkPrediction) []TimelineSegment {
+	timeline := []TimelineSegment{}
+	for _, chunk := range chunks {
+		last := len(timeline) - 1
+		if last >= 0 && timeline[last].Class == chunk.Class && chunk.StartTime <= timeline[last].EndTime {
+			segment := &timeline[last]
+			segment.EndTime = max(segment.EndTime, chunk.EndTime)
+			segment.MeanConfidence = (segment.MeanConfidence*float64(segment.Chunks) + chunk.Confidence) / float64(segment.Chunks+1)
+			segment.Chunks++
+			continue
+		}
+		timeline = append(timeline, TimelineSegment{
+			Class:          chunk.Class,
+			StartTime:      chunk.StartTime,
+			EndTime:        chunk.EndTime,
+			Chunks:         1,
+			MeanConfidence: chunk.Confidence,
+		})
+	}
+	return timeline
+}
+
+// saveInferenceResult writes predictions.json, chunks.csv and timeline.csv
+// to the output directory of a result.
+func saveInferenceResult(result *InferenceResult) error {
+	if err := os.MkdirAll(result.OutputDir, os.ModePerm); err != nil {
+		return err
+	}
+	jsonData, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(result.OutputDir, "predictions.json"), jsonData, os.ModePerm); err != nil {
+		return err
+	}
+	for _, table := range []string{predictionTableChunks, predictionTableTimeline} {
+		csvData, err := result.csv(table)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(result.OutputDir, table+".csv"), []byte(csvData), os.ModePerm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csv renders the chunk predictions, with one probability column per class,
+// or the timelines of all files as CSV.
+func (result *InferenceResult) csv(table string) (string, error) {
+	formatFloat := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	switch table {
+	case predictionTableChunks:
+		header := []string{"source_file", "chunk_index", "md5_hash", "start_time", "end_time", "class", "confidence"}
+		for _, class := range result.Classes {
+			header = append(header, "p_"+class)
+		}
+		w.Write(header)
+		for _, file := range result.Files {
+			for _, chunk := range file.Chunks {
+				record := []string{file.SourceFile, strconv.Itoa(chunk.ChunkIndex), chunk.MD5Hash, formatFloat(chunk.StartTime), formatFloat(chunk.EndTime), chunk.Class, formatFloat(chunk.Confidence)}
+				for _, p := range chunk.Probabilities {
+					record = append(record, formatFloat(p))
+				}
+				w.Write(record)
+			}
+		}
+	case predictionTableTimeline:
+		w.Write([]string{"source_file", "class", "start_time", "end_time", "chunks", "mean_confidence"})
+		for _, file := range result.Files {
+			for _, segment := range file.Timeline {
+				w.Write([]string{file.SourceFile, segment.Class, formatFloat(segment.StartTime), formatFloat(segment.EndTime), strconv.Itoa(segment.Chunks), formatFloat(segment.MeanConfidence)})
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown prediction table: %s", table)
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
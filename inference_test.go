@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestPredictionTimeline(t *testing.T) {
+	chunks := []ChunkPrediction{
+		{StartTime: 0, EndTime: 1, Class: "bird", Confidence: 0.9},
+		{StartTime: 0.5, EndTime: 1.5, Class: "bird", Confidence: 0.7}, // Overlaps
+		{StartTime: 1.5, EndTime: 2.5, Class: "bird", Confidence: 0.5}, // Touches
+		{StartTime: 3, EndTime: 4, Class: "bird", Confidence: 0.6},     // After a gap
+		{StartTime: 4, EndTime: 5, Class: "frog", Confidence: 0.8},
+	}
+	want := []TimelineSegment{
+		{Class: "bird", StartTime: 0, EndTime: 2.5, Chunks: 3, MeanConfidence: 0.7},
+		{Class: "bird", StartTime: 3, EndTime: 4, Chunks: 1, MeanConfidence: 0.6},
+		{Class: "frog", StartTime: 4, EndTime: 5, Chunks: 1, MeanConfidence: 0.8},
+	}
+	got := predictionTimeline(chunks)
+	if len(got) != len(want) {
+		t.Fatalf("%d segments, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Class != want[i].Class || got[i].StartTime != want[i].StartTime || got[i].EndTime != want[i].EndTime ||
+			got[i].Chunks != want[i].Chunks || !approxEqual(got[i].MeanConfidence, want[i].MeanConfidence) {
+			t.Errorf("segment %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if got := predictionTimeline(nil); got == nil || len(got) != 0 {
+		t.Errorf("timeline of no chunks is %v, want empty", got)
+	}
+}
+
+func TestInferenceResultCSV(t *testing.T) {
+	result := &InferenceResult{
+		Classes: []string{"bird", "frog"},
+		Files: []FilePrediction{{
+			SourceFile: "a.wav",
+			Chunks:     []ChunkPrediction{{ChunkIndex: 0, MD5Hash: "h", StartTime: 0, EndTime: 1.5, Class: "bird", Confidence: 0.75, Probabilities: []float64{0.75, 0.25}}},
+			Timeline:   []TimelineSegment{{Class: "bird", StartTime: 0, EndTime: 1.5, Chunks: 1, MeanConfidence: 0.75}},
+		}},
+	}
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{predictionTableChunks, "source_file,chunk_index,md5_hash,start_time,end_time,class,confidence,p_bird,p_frog\na.wav,0,h,0,1.5,bird,0.75,0.75,0.25\n"},
+		{predictionTableTimeline, "source_file,class,start_time,end_time,chunks,mean_confidence\na.wav,bird,0,1.5,1,0.75\n"},
+	}
+	for _, tt := range tests {
+		got, err := result.csv(tt.table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s table:\n%s\nwant\n%s", tt.table, got, tt.want)
+		}
+	}
+	if _, err := result.csv("files"); err == nil {
+		t.Error("unknown table accepted")
+	}
+}
+
+func TestIsPredictionsPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/api/projects/birds/predictions", true},
+		{"/api/projects/birds/predictions/", true},
+		{"/api/projects/predictions", false},
+		{"/api/projects/birds/predictions/extra", false},
+		{"/api/projects/birds/labels", false},
+		{"/api/files", false},
+	}
+	for _, tt := range tests {
+		if got := isPredictionsPath(tt.path); got != tt.want {
+			t.Errorf("isPredictionsPath(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	tests := []struct {
+		size    int
+		limit   int64
+		wantErr error
+	}{
+		{0, 0, nil},
+		{10, 10, nil},
+		{10, 100, nil},
+		{11, 10, errBodyTooLarge},
+		{10, 0, errBodyTooLarge},
+	}
+	for _, tt := range tests {
+		data, err := io.ReadAll(&limitedReader{reader: strings.NewReader(strings.Repeat("x", tt.size)), remaining: tt.limit})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%d bytes with limit %d: error %v, want %v", tt.size, tt.limit, err, tt.wantErr)
+		}
+		if int64(len(data)) > tt.limit {
+			t.Errorf("%d bytes with limit %d: read %d bytes", tt.size, tt.limit, len(data))
+		}
+	}
+}
+
+func TestUploadForm(t *testing.T) {
+	const limit = 1 << 10
+	fiberApp := newTestFiberApp()
+	fiberApp.Post("/api/projects/:id/predictions", func(c *fiber.Ctx) error {
+		form, err := uploadForm(c, int64(c.QueryInt("limit", limit)))
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), err)
+		}
+		defer form.RemoveAll()
+		return c.SendString(strings.Join(form.Value["name"], ","))
+	})
+	multipartBody := func(size int) (string, *bytes.Buffer) {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		w.WriteField("name", "upload")
+		file, _ := w.CreateFormFile("files", "a.wav")
+		file.Write(bytes.Repeat([]byte{1}, size))
+		w.Close()
+		return w.FormDataContentType(), &buf
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		query   string
+		chunked bool
+		want    int
+	}{
+		{"small", 100, "", false, fiber.StatusOK},
+		{"small chunked", 100, "", true, fiber.StatusOK},
+		{"over the limit", 2 * limit, "", false, fiber.StatusRequestEntityTooLarge},
+		{"over the limit chunked", 2 * limit, "", true, fiber.StatusRequestEntityTooLarge},
+		// Uploads are not held to the limit of the other routes
+		{"over the default limit", defaultBodyLimit + 1, "?limit=8388608", false, fiber.StatusOK},
+		{"over the default limit chunked", defaultBodyLimit + 1, "?limit=8388608", true, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		contentType, body := multipartBody(tt.size)
+		req := httptest.NewRequest(fiber.MethodPost, "/api/projects/birds/predictions"+tt.query, body)
+		req.Header.Set(fiber.HeaderContentType, contentType)
+		if tt.chunked {
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		status, got := doRequest(t, fiberApp, req)
+		if status != tt.want {
+			t.Errorf("%s: status %d (%s), want %d", tt.name, status, got, tt.want)
+		}
+		if status == fiber.StatusOK && got != "upload" {
+			t.Errorf("%s: form values %q, want upload", tt.name, got)
+		}
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/api/projects/birds/predictions", strings.NewReader("{}"))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if status, _ := doRequest(t, fiberApp, req); status != fiber.StatusBadRequest {
+		t.Errorf("JSON body: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+}
+
+func TestClassifyAudioFilesNeedsClassifier(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	wavPath := writeTestFile(t, "a.wav", encodeWAV(make([]float64, 8000), 8000))
+	_, err := app.ClassifyAudioFiles("birds", []string{wavPath})
+	if err == nil || !strings.Contains(err.Error(), "train a classifier first") {
+		t.Errorf("got error %v, want one asking to train a classifier", err)
+	}
+	if _, err := app.ClassifyAudioFiles("frogs", []string{wavPath}); !errors.Is(err, errProjectNotFound) {
+		t.Errorf("missing project: got error %v, want errProjectNotFound", err)
+	}
+	if _, err := app.ClassifyAudioFiles("birds", nil); err == nil {
+		t.Error("no files accepted")
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// Long-running pipeline steps that can be started as jobs.
+const (
+	jobConvertFiles     = "convert_files"
+	jobSpectrograms     = "spectrograms"
+	jobOptimalClusters  = "optimal_clusters"
+	jobTrainClassifier  = "train_classifier"
+	jobEvaluation       = "evaluate_classifier"
+	jobEventName        = "job:update" // Wails event carrying a JobStatus
+	jobConcurrency      = 1            // Pipeline steps are CPU and disk heavy, so run one at a time
+	jobProgressInterval = 250 * time.Millisecond
+	maxFinishedJobs     = 100
+)
+
+// JobStatus is the externally visible state of a job.
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Project    string     `json:"project"`
+	State      string     `json:"state"`
+	Processed  int        `json:"processed"`
+	Total      int        `json:"total"`
+	ETASeconds float64    `json:"eta_seconds"` // 0 while unknown
+	Result     any        `json:"result,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// progressFunc reports how many of the items of a pipeline step are done.
+// A nil progressFunc discards the updates.
+type progressFunc func(processed, total int)
+
+func (report progressFunc) update(processed, total int) {
+	if report != nil {
+		report(processed, total)
+	}
+}
+
+// jobFunc does the work of a job. Its result is stored in the job status.
+type jobFunc func(ctx context.Context, report progressFunc) (any, error)
+
+type job struct {
+	status    JobStatus
+	cancel    context.CancelFunc
+	lastEvent time.Time
+}
+
+// jobManager runs jobs in the background and publishes every change of their
+// status to Wails events and to server-mode subscribers.
+type jobManager struct {
+	mu          sync.Mutex
+	jobs        map[string]*job
+	slots       chan struct{}
+	subscribers map[chan JobStatus]struct{}
+	emit        func(JobStatus)
+}
+
+func newJobManager(emit func(JobStatus)) *jobManager {
+	return &jobManager{
+		jobs:        map[string]*job{},
+		slots:       make(chan struct{}, jobConcurrency),
+		subscribers: map[chan JobStatus]struct{}{},
+		emit:        emit,
+	}
+}
+
+// StartJob queues a pipeline step for a project and returns its status
+// immediately. Progress is published as "job:update" events.
+func (a *App) StartJob(jobType, projectName string) (*JobStatus, error) {
+	var fn jobFunc
+	switch jobType {
+	case jobConvertFiles:
+		fn = func(ctx context.Context, report progressFunc) (any, error) {
+			return nil, a.convertFilesToWAV(ctx, projectName, report)
+		}
+	case jobSpectrograms:
+		fn = func(ctx context.Context, report progressFunc) (any, error) {
+			md5Hashes, err := a.processAudioChunksAndSpectrograms(ctx, projectName, report)
+			return len(md5Hashes), err
+		}
+	case jobOptimalClusters:
+		fn = func(ctx context.Context, report progressFunc) (any, error) {
+			return a.calculateOptimalClusters(ctx, projectName, report)
+		}
+	case jobTrainClassifier:
+		fn = func(ctx context.Context, report progressFunc) (any, error) {
+			model, err := a.trainClassifier(ctx, projectName, report)
+			if err != nil {
+				return nil, err
+			}
+			// The weights are too large for job events; report the best epoch
+			return model.History[model.BestEpoch-1], nil
+		}
+	case jobEvaluation:
+		fn = func(ctx context.Context, report progressFunc) (any, error) {
+			evaluation, err := a.evaluateClassifier(ctx, projectName, report)
+			if err != nil {
+				return nil, err
+			}
+			// The full report is too large for job events as well
+			return map[string]any{"accuracy": evaluation.Accuracy, "macro_average": evaluation.MacroAverage}, nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown job type: %s", jobType)
+	}
+
+	status := a.jobs.start(jobType, projectName, fn)
+	return &status, nil
+}
+
+// GetJob returns the status of a job.
+func (a *App) GetJob(jobID string) (*JobStatus, error) {
+	status, ok := a.jobs.get(jobID)
+	if !ok {
+		return nil, fmt.Errorf("job not found: %s", jobID)
+	}
+	return &status, nil
+}
+
+// ListJobs returns the status of all known jobs, newest first.
+func (a *App) ListJobs() []JobStatus {
+	return a.jobs.list()
+}
+
+// CancelJob asks a queued or running job to stop.
+func (a *App) CancelJob(jobID string) error {
+	return a.jobs.cancelJob(jobID)
+}
+
+// emitJobEvent forwards job updates to the frontend in desktop mode.
+func (a *App) emitJobEvent(status JobStatus) {
+	if a.ctx != nil {
+		wailsruntime.EventsEmit(a.ctx, jobEventName, status)
+	}
+}
+
+func (m *jobManager) start(jobType, projectName string, fn jobFunc) JobStatus {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: JobStatus{
+			ID:        newID(),
+			Type:      jobType,
+			Project:   projectName,
+			State:     jobQueued,
+			CreatedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	m.mu.Lock()
+	m.jobs[j.status.ID] = j
+	m.pruneLocked()
+	status := j.status
+	m.publishLocked(status)
+	m.mu.Unlock()
+
+	go m.run(ctx, j, fn)
+	return status
+}
+
+func (m *jobManager) run(ctx context.Context, j *job, fn jobFunc) {
+	defer j.cancel()
+
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(j, nil, ctx.Err())
+		return
+	}
+
+	now := time.Now()
+	m.update(j, func(status *JobStatus) {
+		status.State = jobRunning
+		status.StartedAt = &now
+	})
+
+	result, err := fn(ctx, func(processed, total int) {
+		m.progress(j, processed, total)
+	})
+	if err != nil && ctx.Err() != nil {
+		// Steps may wrap the cancellation error, so trust the context.
+		err = ctx.Err()
+	}
+	m.finish(j, result, err)
+}
+
+func (m *jobManager) progress(j *job, processed, total int) {
+	m.mu.Lock()
+	status := &j.status
+	status.Processed, status.Total = processed, total
+	status.ETASeconds = 0
+	if status.StartedAt != nil && processed > 0 && total > processed {
+		elapsed := time.Since(*status.StartedAt).Seconds()
+		status.ETASeconds = elapsed / float64(processed) * float64(total-processed)
+	}
+
+	// Progress can be very frequent, so only publish it every so often.
+	if time.Since(j.lastEvent) < jobProgressInterval && processed < total {
+		m.mu.Unlock()
+		return
+	}
+	j.lastEvent = time.Now()
+	m.publishLocked(*status)
+	m.mu.Unlock()
+}
+
+func (m *jobManager) finish(j *job, result any, err error) {
+	now := time.Now()
+	m.update(j, func(status *JobStatus) {
+		status.FinishedAt = &now
+		status.ETASeconds = 0
+		status.Result = result
+		switch {
+		case errors.Is(err, context.Canceled):
+			status.State = jobCancelled
+		case err != nil:
+			status.State = jobFailed
+			status.Error = err.Error()
+		default:
+			status.State = jobDone
+		}
+		fmt.Printf("Job %s (%s, %s) finished: %s\n", status.ID, status.Type, status.Project, status.State)
+	})
+}
+
+func (m *jobManager) update(j *job, fn func(status *JobStatus)) {
+	m.mu.Lock()
+	fn(&j.status)
+	j.lastEvent = time.Now()
+	m.publishLocked(j.status)
+	m.mu.Unlock()
+}
+
+func (m *jobManager) get(jobID string) (JobStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[jobID]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return j.status, true
+}
+
+func (m *jobManager) list() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		statuses = append(statuses, j.status)
+	}
+	sort.Slice(statuses, func(i, k int) bool { return statuses[i].CreatedAt.After(statuses[k].CreatedAt) })
+	return statuses
+}
+
+// active reports whether a project has queued or running jobs.
+func (m *jobManager) active(projectName string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range m.jobs {
+		if j.status.Project == projectName && j.status.FinishedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *jobManager) cancelJob(jobID string) error {
+	m.mu.Lock()
+	j, ok := m.jobs[jobID]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("job not found: %s", jobID)
+	}
+	j.cancel()
+	return nil
+}
+
+// subscribe returns a channel receiving every job update until unsubscribe is
+// called. Updates are dropped for subscribers that fall behind.
+func (m *jobManager) subscribe() (<-chan JobStatus, func()) {
+	updates := make(chan JobStatus, 64)
+	m.mu.Lock()
+	m.subscribers[updates] = struct{}{}
+	m.mu.Unlock()
+
+	return updates, func() {
+		m.mu.Lock()
+		delete(m.subscribers, updates)
+		m.mu.Unlock()
+	}
+}
+
+// publishLocked sends a status to all subscribers. Publishing under the lock
+// keeps updates of a job in order.
+func (m *jobManager) publishLocked(status JobStatus) {
+	for subscriber := range m.subscribers {
+		select {
+		case subscriber <- status:
+		default:
+		}
+	}
+	if m.emit != nil {
+		m.emit(status)
+	}
+}
+
+// pruneLocked forgets the oldest finished jobs beyond maxFinishedJobs.
+func (m *jobManager) pruneLocked() {
+	var finished []*job
+	for _, j := range m.jobs {
+		if j.status.FinishedAt != nil {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(finished, func(i, k int) bool { return finished[i].status.FinishedAt.Before(*finished[k].status.FinishedAt) })
+	for _, j := range finished[:len(finished)-maxFinishedJobs] {
+		delete(m.jobs, j.status.ID)
+	}
+}
+
+func newID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitForJob returns the statuses published for a job up to and including
+// its final one.
+func waitForJob(t *testing.T, updates <-chan JobStatus, jobID string) []JobStatus {
+	t.Helper()
+	var statuses []JobStatus
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case status := <-updates:
+			if status.ID != jobID {
+				continue
+			}
+			statuses = append(statuses, status)
+			if status.FinishedAt != nil {
+				return statuses
+			}
+		case <-timeout:
+			t.Fatalf("job %s did not finish: %+v", jobID, statuses)
+		}
+	}
+}
+
+func TestJobManagerFinishesJobs(t *testing.T) {
+	tests := []struct {
+		name      string
+		fn        jobFunc
+		state     string
+		result    any
+		errorText string
+	}{
+		{
+			name: "done",
+			fn: func(ctx context.Context, report progressFunc) (any, error) {
+				report(1, 2)
+				report(2, 2)
+				return 7, nil
+			},
+			state:  jobDone,
+			result: 7,
+		},
+		{
+			name: "failed",
+			fn: func(ctx context.Context, report progressFunc) (any, error) {
+				return nil, errors.New("no spectrograms")
+			},
+			state:     jobFailed,
+			errorText: "no spectrograms",
+		},
+		{
+			name: "cancelled by the step",
+			fn: func(ctx context.Context, report progressFunc) (any, error) {
+				return nil, fmt.Errorf("error converting: %w", context.Canceled)
+			},
+			state: jobCancelled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newJobManager(nil)
+			updates, unsubscribe := m.subscribe()
+			defer unsubscribe()
+
+			started := m.start(jobSpectrograms, "test", tt.fn)
+			if started.State != jobQueued {
+				t.Errorf("started in state %s, want %s", started.State, jobQueued)
+			}
+			statuses := waitForJob(t, updates, started.ID)
+			final := statuses[len(statuses)-1]
+			if final.State != tt.state || final.Result != tt.result || final.Error != tt.errorText {
+				t.Errorf("finished as %s with result %v and error %q, want %s, %v and %q", final.State, final.Result, final.Error, tt.state, tt.result, tt.errorText)
+			}
+			if statuses[0].State != jobQueued || statuses[1].State != jobRunning {
+				t.Errorf("published %s then %s, want queued then running", statuses[0].State, statuses[1].State)
+			}
+			if status, ok := m.get(started.ID); !ok || status.State != tt.state {
+				t.Errorf("get returned %+v, %t", status, ok)
+			}
+		})
+	}
+}
+
+func TestJobManagerReportsProgress(t *testing.T) {
+	m := newJobManager(nil)
+	updates, unsubscribe := m.subscribe()
+	defer unsubscribe()
+
+	started := m.start(jobSpectrograms, "test", func(ctx context.Context, report progressFunc) (any, error) {
+		report(3, 3)
+		return nil, nil
+	})
+	statuses := waitForJob(t, updates, started.ID)
+	// Reaching the total is always published, however soon it comes
+	var progress *JobStatus
+	for i := range statuses {
+		if statuses[i].Processed == 3 && statuses[i].State == jobRunning {
+			progress = &statuses[i]
+		}
+	}
+	if progress == nil || progress.Total != 3 {
+		t.Errorf("no progress update of 3/3 in %+v", statuses)
+	}
+}
+
+func TestJobManagerCancelsJobs(t *testing.T) {
+	m := newJobManager(nil)
+	updates, unsubscribe := m.subscribe()
+	defer unsubscribe()
+
+	running := make(chan struct{})
+	first := m.start(jobSpectrograms, "test", func(ctx context.Context, report progressFunc) (any, error) {
+		close(running)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-running
+
+	// The second job waits for the only slot, so it is cancelled unstarted
+	called := false
+	second := m.start(jobConvertFiles, "test", func(ctx context.Context, report progressFunc) (any, error) {
+		called = true
+		return nil, nil
+	})
+	if !m.active("test") || m.active("other") {
+		t.Error("active does not follow the queued jobs")
+	}
+	if err := m.cancelJob(second.ID); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := waitForJob(t, updates, second.ID); statuses[len(statuses)-1].State != jobCancelled || statuses[len(statuses)-1].StartedAt != nil {
+		t.Errorf("queued job ended as %+v", statuses[len(statuses)-1])
+	}
+	if called {
+		t.Error("the cancelled queued job ran")
+	}
+
+	if err := m.cancelJob(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if statuses := waitForJob(t, updates, first.ID); statuses[len(statuses)-1].State != jobCancelled {
+		t.Errorf("running job ended as %s", statuses[len(statuses)-1].State)
+	}
+	if m.active("test") {
+		t.Error("the project is still active after its jobs ended")
+	}
+	if err := m.cancelJob("missing"); err == nil {
+		t.Error("cancelling an unknown job succeeded")
+	}
+}
+
+func TestStartJobRejectsUnknownTypes(t *testing.T) {
+	app := &App{jobs: newJobManager(nil)}
+	if _, err := app.StartJob("compress", "test"); err == nil {
+		t.Error("an unknown job type was started")
+	}
+	if jobs := app.ListJobs(); len(jobs) != 0 {
+		t.Errorf("%d jobs listed, want 0", len(jobs))
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/mat"
+)
+
+// KMeansConfig controls the k-means runs used by the clustering stage.
+type KMeansConfig struct {
+	Seed          int64   `json:"seed"`
+	MaxIterations int     `json:"max_iterations"`
+	Tolerance     float64 `json:"tolerance"` // Relative to the mean feature variance
+	Restarts      int     `json:"restarts"`
+}
+
+func defaultKMeansConfig() KMeansConfig {
+	return KMeansConfig{
+		Seed:          42,
+		MaxIterations: 300,
+		Tolerance:     1e-4,
+		Restarts:      10,
+	}
+}
+
+func (cfg KMeansConfig) validate() error {
+	if cfg.MaxIterations <= 0 {
+		return fmt.Errorf("max iterations must be positive")
+	}
+	if cfg.Tolerance < 0 {
+		return fmt.Errorf("tolerance cannot be negative")
+	}
+	if cfg.Restarts <= 0 {
+		return fmt.Errorf("restarts must be positive")
+	}
+	return nil
+}
+
+type kMeansResult struct {
+	Labels     []int
+	Centroids  *mat.Dense
+	Inertia    float64 // Sum of squared distances to the assigned centroids
+	Iterations int
+	Converged  bool
+}
+
+// kMeans clusters the rows of data into k groups. Each restart is seeded with
+// k-means++ and iterated until the centroids move less than the tolerance;
+// the restart with the lowest inertia wins. Results depend only on cfg.Seed
+// and k, so runs are reproducible. Cancelling ctx stops at the next iteration.
+func kMeans(ctx context.Context, data *mat.Dense, k int, cfg KMeansConfig) (*kMeansResult, error) {
+	rows, _ := data.Dims()
+	if k < 1 || k > rows {
+		return nil, fmt.Errorf("cannot form %d clusters from %d rows", k, rows)
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed + int64(k)))
+	threshold := cfg.Tolerance * meanFeatureVariance(data)
+
+	var best *kMeansResult
+	for restart := 0; restart < cfg.Restarts; restart++ {
+		result, err := kMeansOnce(ctx, data, k, cfg.MaxIterations, threshold, rng)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || result.Inertia < best.Inertia {
+			best = result
+		}
+	}
+	return best, nil
+}
+
+func kMeansOnce(ctx context.Context, data *mat.Dense, k, maxIterations int, threshold float64, rng *rand.Rand) (*kMeansResult, error) {
+	rows, cols := data.Dims()
+	centroids := kMeansPlusPlus(data, k, rng)
+	labels := make([]int, rows)
+	distances := make([]float64, rows)
+
+	result := &kMeansResult{Labels: labels}
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		assignToCentroids(data, centroids, labels, distances)
+
+		newCentroids := mat.NewDense(k, cols, nil)
+		sizes := make([]int, k)
+		for i, label := range labels {
+			floats.Add(newCentroids.RawRowView(label), data.RawRowView(i))
+			sizes[label]++
+		}
+		reseedEmptyClusters(data, newCentroids, sizes, labels, distances)
+		for c := 0; c < k; c++ {
+			floats.Scale(1/float64(sizes[c]), newCentroids.RawRowView(c))
+		}
+
+		var shift float64
+		for c := 0; c < k; c++ {
+			shift += squaredDistance(centroids.RawRowView(c), newCentroids.RawRowView(c))
+		}
+		centroids = newCentroids
+		result.Iterations = iteration
+
+		if shift <= threshold {
+			result.Converged = true
+			break
+		}
+	}
+
+	assignToCentroids(data, centroids, labels, distances)
+	for _, d := range distances {
+		result.Inertia += d
+	}
+	result.Centroids = centroids
+	return result, nil
+}
+
+// kMeansPlusPlus picks the initial centroids, each new one sampled with
+// probability proportional to its squared distance from the closest centroid
+// chosen so far.
+func kMeansPlusPlus(data *mat.Dense, k int, rng *rand.Rand) *mat.Dense {
+	rows, cols := data.Dims()
+	centroids := mat.NewDense(k, cols, nil)
+	centroids.SetRow(0, data.RawRowView(rng.Intn(rows)))
+
+	closest := make([]float64, rows)
+	for i := range closest {
+		closest[i] = squaredDistance(data.RawRowView(i), centroids.RawRowView(0))
+	}
+
+	for c := 1; c < k; c++ {
+		var total float64
+		for _, d := range closest {
+			total += d
+		}
+
+		next := rng.Intn(rows)
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range closest {
+				target -= d
+				if target <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+
+		centroids.SetRow(c, data.RawRowView(next))
+		for i := range closest {
+			closest[i] = math.Min(closest[i], squaredDistance(data.RawRowView(i), centroids.RawRowView(c)))
+		}
+	}
+	return centroids
+}
+
+// reseedEmptyClusters moves the point farthest from its centroid into each
+// empty cluster so that no centroid ends up as a division by zero.
+func reseedEmptyClusters(data, sums *mat.Dense, sizes, labels []int, distances []float64) {
+	for c, size := range sizes {
+		if size > 0 {
+			continue
+		}
+
+		farthest := -1
+		for i, d := range distances {
+			if sizes[labels[i]] > 1 && (farthest < 0 || d > distances[farthest]) {
+				farthest = i
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+
+		row := data.RawRowView(farthest)
+		floats.Sub(sums.RawRowView(labels[farthest]), row)
+		sizes[labels[farthest]]--
+		sums.SetRow(c, row)
+		sizes[c] = 1
+		labels[farthest] = c
+		distances[farthest] = 0
+	}
+}
+
+// assignToCentroids labels every row with its nearest centroid and records the
+// squared distance to it. Rows are split across CPU cores.
+func assignToCentroids(data, centroids *mat.Dense, labels []int, distances []float64) {
+	rows, _ := data.Dims()
+	k, _ := centroids.Dims()
+
+	workers := runtime.NumCPU()
+	chunk := (rows + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < rows; start += chunk {
+		end := min(rows, start+chunk)
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				row := data.RawRowView(i)
+				labels[i] = 0
+				distances[i] = squaredDistance(row, centroids.RawRowView(0))
+				for c := 1; c < k; c++ {
+					if d := squaredDistance(row, centroids.RawRowView(c)); d < distances[i] {
+						labels[i] = c
+						distances[i] = d
+					}
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+func meanFeatureVariance(data *mat.Dense) float64 {
+	rows, cols := data.Dims()
+	if rows == 0 || cols == 0 {
+		return 0
+	}
+
+	mean := make([]float64, cols)
+	for i := 0; i < rows; i++ {
+		floats.Add(mean, data.RawRowView(i))
+	}
+	floats.Scale(1/float64(rows), mean)
+
+	var total float64
+	for i := 0; i < rows; i++ {
+		total += squaredDistance(data.RawRowView(i), mean)
+	}
+	return total / float64(rows*cols)
+}
+
+func squaredDistance(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		diff := a[i] - b[i]
+		sum += diff * diff
+	}
+	return sum
+}
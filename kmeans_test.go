@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// blobs returns n points around each of the given centres, in order.
+func blobs(centres [][]float64, n int, spread float64, rng *rand.Rand) *mat.Dense {
+	cols := len(centres[0])
+	data := mat.NewDense(len(centres)*n, cols, nil)
+	for c, centre := range centres {
+		for i := 0; i < n; i++ {
+			row := data.RawRowView(c*n + i)
+			for j := range row {
+				row[j] = centre[j] + spread*rng.NormFloat64()
+			}
+		}
+	}
+	return data
+}
+
+func TestKMeansSeparatesBlobs(t *testing.T) {
+	centres := [][]float64{{0, 0}, {10, 0}, {0, 10}}
+	data := blobs(centres, 20, 0.5, rand.New(rand.NewSource(1)))
+
+	result, err := kMeans(context.Background(), data, 3, defaultKMeansConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Converged {
+		t.Errorf("did not converge in %d iterations", result.Iterations)
+	}
+	for c := range centres {
+		label := result.Labels[c*20]
+		for i := c * 20; i < (c+1)*20; i++ {
+			if result.Labels[i] != label {
+				t.Fatalf("row %d of blob %d has label %d, want %d", i, c, result.Labels[i], label)
+			}
+		}
+	}
+	if result.Labels[0] == result.Labels[20] || result.Labels[0] == result.Labels[40] || result.Labels[20] == result.Labels[40] {
+		t.Errorf("blobs share labels: %v", result.Labels)
+	}
+
+	again, err := kMeans(context.Background(), data, 3, defaultKMeansConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Labels, result.Labels) || again.Inertia != result.Inertia {
+		t.Error("the same seed gave a different result")
+	}
+}
+
+func TestKMeansRejectsInvalidK(t *testing.T) {
+	data := mat.NewDense(3, 2, []float64{0, 0, 1, 1, 2, 2})
+	for _, k := range []int{0, 4} {
+		if _, err := kMeans(context.Background(), data, k, defaultKMeansConfig()); err == nil {
+			t.Errorf("k = %d succeeded", k)
+		}
+	}
+}
+
+func TestKMeansPlusPlusPicksDistinctRows(t *testing.T) {
+	// Duplicate rows have no distance left, so each centroid must come from
+	// a different location
+	data := mat.NewDense(6, 1, []float64{0, 0, 0, 5, 5, 9})
+	centroids := kMeansPlusPlus(data, 3, rand.New(rand.NewSource(3)))
+	seen := make(map[float64]bool)
+	for c := 0; c < 3; c++ {
+		seen[centroids.At(c, 0)] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("centroids %v are not distinct", mat.Col(nil, 0, centroids))
+	}
+}
+
+func TestReseedEmptyClusters(t *testing.T) {
+	data := mat.NewDense(4, 1, []float64{0, 1, 2, 10})
+	labels := []int{0, 0, 0, 0}
+	distances := []float64{1, 0, 1, 81}
+	sums := mat.NewDense(2, 1, []float64{13, 0})
+	sizes := []int{4, 0}
+
+	reseedEmptyClusters(data, sums, sizes, labels, distances)
+	if !reflect.DeepEqual(labels, []int{0, 0, 0, 1}) || !reflect.DeepEqual(sizes, []int{3, 1}) {
+		t.Errorf("labels %v and sizes %v, want [0 0 0 1] and [3 1]", labels, sizes)
+	}
+	if sums.At(0, 0) != 3 || sums.At(1, 0) != 10 || distances[3] != 0 {
+		t.Errorf("sums %v and distances %v after reseeding", mat.Col(nil, 0, sums), distances)
+	}
+}
+
+func TestKMeansStopsWhenCancelled(t *testing.T) {
+	data := blobs([][]float64{{0}, {1}}, 10, 1, rand.New(rand.NewSource(1)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := kMeans(ctx, data, 2, defaultKMeansConfig()); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	labelsFile         = "labels.json"
+	labelSeparator     = "/" // Joins the levels of a label path, e.g. "bird/song/alarm"
+	labelLevels        = 3
+	maxLabelNameLength = 100
+)
+
+// Errors of label calls, wrapped with the label concerned.
+var (
+	errInvalidLabel  = errors.New("invalid label")
+	errLabelNotFound = errors.New("label not found")
+	errLabelExists   = errors.New("label already exists")
+	errLabelInUse    = errors.New("label is used by annotations")
+)
+
+// LabelTaxonomy is the label tree of a project: categories hold
+// subcategories, which hold sub-subcategories. Annotations refer to a node of
+// the tree by its path, so a label can be as coarse as a category or as fine
+// as a sub-subcategory.
+type LabelTaxonomy map[string]map[string][]string
+
+// GetLabels returns the label tree of a project, empty if none was saved.
+func (a *App) GetLabels(projectName string) (LabelTaxonomy, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return LabelTaxonomy{}, err
+	}
+
+	a.labelsMu.Lock()
+	defer a.labelsMu.Unlock()
+	return loadLabels(projectDir)
+}
+
+// SaveLabels replaces the label tree of a project. Labels still used by
+// annotations cannot be dropped; rename or merge them instead.
+func (a *App) SaveLabels(projectName string, labels LabelTaxonomy) error {
+	labels, err := labels.normalize()
+	if err != nil {
+		return err
+	}
+	return a.updateLabels(projectName, func(projectDir string, current LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		var removed []string
+		for _, label := range current.paths() {
+			if !labels.has(label) {
+				removed = append(removed, label)
+			}
+		}
+		if err := checkLabelsUnused(projectDir, removed...); err != nil {
+			return nil, nil, err
+		}
+		return labels, nil, nil
+	})
+}
+
+// AddLabel adds a label path of one to three levels, creating missing parents.
+func (a *App) AddLabel(projectName string, label string) error {
+	parts, err := splitLabel(label)
+	if err != nil {
+		return err
+	}
+	return a.updateLabels(projectName, func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		if labels.has(label) {
+			return nil, nil, fmt.Errorf("%w: %s", errLabelExists, label)
+		}
+		labels.add(parts)
+		return labels, nil, nil
+	})
+}
+
+// DeleteLabel removes a label and everything below it, provided no annotation
+// uses any of them.
+func (a *App) DeleteLabel(projectName string, label string) error {
+	parts, err := splitLabel(label)
+	if err != nil {
+		return err
+	}
+	return a.updateLabels(projectName, func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		if !labels.has(label) {
+			return nil, nil, fmt.Errorf("%w: %s", errLabelNotFound, label)
+		}
+		if err := checkLabelsUnused(projectDir, label); err != nil {
+			return nil, nil, err
+		}
+		labels.remove(parts)
+		return labels, nil, nil
+	})
+}
+
+// RenameLabel gives a label a new name at the same level and moves the
+// annotations of the label and of everything below it along. It returns the
+// number of annotations changed.
+func (a *App) RenameLabel(projectName string, label string, newName string) (int, error) {
+	parts, err := splitLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateLabelName(newName); err != nil {
+		return 0, err
+	}
+	target := strings.Join(append(parts[:len(parts)-1:len(parts)-1], newName), labelSeparator)
+
+	var relabeled int
+	err = a.updateLabels(projectName, func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		if !labels.has(label) {
+			return nil, nil, fmt.Errorf("%w: %s", errLabelNotFound, label)
+		}
+		if labels.has(target) {
+			return nil, nil, fmt.Errorf("%w: %s; merge the labels instead", errLabelExists, target)
+		}
+		labels.rename(parts, newName)
+		annotations, n, err := relabelAnnotations(projectDir, label, target)
+		relabeled = n
+		return labels, annotations, err
+	})
+	return relabeled, err
+}
+
+// MergeLabels folds a label into another one of the same level: the children
+// of both are combined, the annotations of the merged label move to the
+// target, and the merged label is removed. It returns the number of
+// annotations changed.
+func (a *App) MergeLabels(projectName string, label string, into string) (int, error) {
+	parts, err := splitLabel(label)
+	if err != nil {
+		return 0, err
+	}
+	intoParts, err := splitLabel(into)
+	if err != nil {
+		return 0, err
+	}
+	if len(parts) != len(intoParts) {
+		return 0, fmt.Errorf("%w: %s and %s are not on the same level", errInvalidLabel, label, into)
+	}
+	if label == into {
+		return 0, fmt.Errorf("%w: cannot merge %s into itself", errInvalidLabel, label)
+	}
+
+	var relabeled int
+	err = a.updateLabels(projectName, func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error) {
+		for _, path := range []string{label, into} {
+			if !labels.has(path) {
+				return nil, nil, fmt.Errorf("%w: %s", errLabelNotFound, path)
+			}
+		}
+		labels.merge(parts, intoParts)
+		annotations, n, err := relabelAnnotations(projectDir, label, into)
+		relabeled = n
+		return labels, annotations, err
+	})
+	return relabeled, err
+}
+
+// updateLabels applies fn to the label tree of a project and saves the tree
+// it returns, then the annotations it returns, if any. Annotations change
+// together with the labels, so both are guarded by the same lock; the labels
+// are written first so saved annotations never use a label missing from the
+// tree.
+func (a *App) updateLabels(projectName string, fn func(projectDir string, labels LabelTaxonomy) (LabelTaxonomy, []Annotation, error)) error {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return err
+	}
+
+	a.labelsMu.Lock()
+	defer a.labelsMu.Unlock()
+
+	labels, err := loadLabels(projectDir)
+	if err != nil {
+		return err
+	}
+	labels, annotations, err := fn(projectDir, labels)
+	if err != nil {
+		return err
+	}
+	if err := saveLabels(projectDir, labels); err != nil {
+		return err
+	}
+	if annotations == nil {
+		return nil
+	}
+	return saveAnnotations(projectDir, annotations)
+}
+
+func loadLabels(projectDir string) (LabelTaxonomy, error) {
+	fileData, err := os.ReadFile(filepath.Join(projectDir, labelsFile))
+	if os.IsNotExist(err) {
+		return LabelTaxonomy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading labels: %v", err)
+	}
+	var labels LabelTaxonomy
+	if err := json.Unmarshal(fileData, &labels); err != nil {
+		return nil, fmt.Errorf("error unmarshalling labels: %v", err)
+	}
+	return labels.normalize()
+}
+
+func saveLabels(projectDir string, labels LabelTaxonomy) error {
+	jsonData, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(projectDir, labelsFile), jsonData); err != nil {
+		return fmt.Errorf("error saving labels: %v", err)
+	}
+	return nil
+}
+
+// normalize validates every name of the tree and returns a copy without
+// duplicate sub-subcategories and with empty levels as {} and [] rather than
+// null, the shape the frontend expects.
+func (t LabelTaxonomy) normalize() (LabelTaxonomy, error) {
+	normalized := LabelTaxonomy{}
+	for category, subcategories := range t {
+		if err := validateLabelName(category); err != nil {
+			return nil, err
+		}
+		normalized[category] = map[string][]string{}
+		for subcategory, subsubcategories := range subcategories {
+			if err := validateLabelName(subcategory); err != nil {
+				return nil, err
+			}
+			unique := []string{}
+			for _, subsubcategory := range subsubcategories {
+				if err := validateLabelName(subsubcategory); err != nil {
+					return nil, err
+				}
+				if !slices.Contains(unique, subsubcategory) {
+					unique = append(unique, subsubcategory)
+				}
+			}
+			normalized[category][subcategory] = unique
+		}
+	}
+	return normalized, nil
+}
+
+// has reports whether a label path is a node of the tree.
+func (t LabelTaxonomy) has(label string) bool {
+	parts := strings.Split(label, labelSeparator)
+	subcategories, ok := t[parts[0]]
+	if !ok || len(parts) == 1 {
+		return ok && len(parts) == 1
+	}
+	subsubcategories, ok := subcategories[parts[1]]
+	if !ok || len(parts) == 2 {
+		return ok && len(parts) == 2
+	}
+	return len(parts) == labelLevels && slices.Contains(subsubcategories, parts[2])
+}
+
+// paths lists the path of every node of the tree in sorted order.
+func (t LabelTaxonomy) paths() []string {
+	var paths []string
+	for category, subcategories := range t {
+		paths = append(paths, category)
+		for subcategory, subsubcategories := range subcategories {
+			paths = append(paths, category+labelSeparator+subcategory)
+			for _, subsubcategory := range subsubcategories {
+				paths = append(paths, category+labelSeparator+subcategory+labelSeparator+subsubcategory)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (t LabelTaxonomy) add(parts []string) {
+	if t[parts[0]] == nil {
+		t[parts[0]] = map[string][]string{}
+	}
+	if len(parts) == 1 {
+		return
+	}
+	if t[parts[0]][parts[1]] == nil {
+		t[parts[0]][parts[1]] = []string{}
+	}
+	if len(parts) == labelLevels && !slices.Contains(t[parts[0]][parts[1]], parts[2]) {
+		t[parts[0]][parts[1]] = append(t[parts[0]][parts[1]], parts[2])
+	}
+}
+
+func (t LabelTaxonomy) remove(parts []string) {
+	switch len(parts) {
+	case 1:
+		delete(t, parts[0])
+	case 2:
+		delete(t[parts[0]], parts[1])
+	default:
+		t[parts[0]][parts[1]] = slices.DeleteFunc(t[parts[0]][parts[1]], func(name string) bool { return name == parts[2] })
+	}
+}
+
+func (t LabelTaxonomy) rename(parts []string, newName string) {
+	switch len(parts) {
+	case 1:
+		t[newName] = t[parts[0]]
+		delete(t, parts[0])
+	case 2:
+		t[parts[0]][newName] = t[parts[0]][parts[1]]
+		delete(t[parts[0]], parts[1])
+	default:
+		subsubcategories := t[parts[0]][parts[1]]
+		for i, subsubcategory := range subsubcategories {
+			if subsubcategory == parts[2] {
+				subsubcategories[i] = newName
+			}
+		}
+	}
+}
+
+// merge moves the children of the node at parts into the node at into and
+// removes the node at parts.
+func (t LabelTaxonomy) merge(parts, into []string) {
+	switch len(parts) {
+	case 1:
+		for subcategory, subsubcategories := range t[parts[0]] {
+			for _, subsubcategory := range subsubcategories {
+				t.add([]string{into[0], subcategory, subsubcategory})
+			}
+			t.add([]string{into[0], subcategory})
+		}
+	case 2:
+		for _, subsubcategory := range t[parts[0]][parts[1]] {
+			t.add([]string{into[0], into[1], subsubcategory})
+		}
+	}
+	t.remove(parts)
+}
+
+// splitLabel validates a label path and returns its levels.
+func splitLabel(label string) ([]string, error) {
+	parts := strings.Split(label, labelSeparator)
+	if len(parts) > labelLevels {
+		return nil, fmt.Errorf("%w: %q has more than %d levels", errInvalidLabel, label, labelLevels)
+	}
+	for _, part := range parts {
+		if err := validateLabelName(part); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+func validateLabelName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("%w: empty name", errInvalidLabel)
+	case strings.TrimSpace(name) != name:
+		return fmt.Errorf("%w: %q has leading or trailing spaces", errInvalidLabel, name)
+	case len(name) > maxLabelNameLength:
+		return fmt.Errorf("%w: %q is longer than %d bytes", errInvalidLabel, name, maxLabelNameLength)
+	case strings.Contains(name, labelSeparator):
+		return fmt.Errorf("%w: %q contains %q", errInvalidLabel, name, labelSeparator)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("%w: %q contains control characters", errInvalidLabel, name)
+	}
+	return nil
+}
+
+// labelWithin reports whether label is path or lies below it.
+func labelWithin(label, path string) bool {
+	return label == path || strings.HasPrefix(label, path+labelSeparator)
+}
+
+// checkLabelsUnused fails with errLabelInUse if an annotation uses one of the
+// labels or anything below them.
+func checkLabelsUnused(projectDir string, labels ...string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	annotations, err := loadAnnotations(projectDir)
+	if err != nil {
+		return err
+	}
+	for _, annotation := range annotations {
+		for _, label := range labels {
+			if labelWithin(annotation.Label, label) {
+				return fmt.Errorf("%w: %s", errLabelInUse, annotation.Label)
+			}
+		}
+	}
+	return nil
+}
+
+// relabelAnnotations moves the annotations of a label and of everything below
+// it to another label path. It returns the annotations to save, or nil when
+// none changed, and how many changed.
+func relabelAnnotations(projectDir, from, to string) ([]Annotation, int, error) {
+	annotations, err := loadAnnotations(projectDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	relabeled := 0
+	for i := range annotations {
+		if labelWithin(annotations[i].Label, from) {
+			annotations[i].Label = to + strings.TrimPrefix(annotations[i].Label, from)
+			relabeled++
+		}
+	}
+	if relabeled == 0 {
+		return nil, 0, nil
+	}
+	return annotations, relabeled, nil
+}
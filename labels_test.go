@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSplitLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  []string
+	}{
+		{"bird", []string{"bird"}},
+		{"bird/song", []string{"bird", "song"}},
+		{"bird/song/alarm", []string{"bird", "song", "alarm"}},
+		{"bird/song/alarm/short", nil},
+		{"", nil},
+		{"bird//alarm", nil},
+		{" bird", nil},
+		{"bird\tsong", nil},
+		{strings.Repeat("b", maxLabelNameLength+1), nil},
+	}
+	for _, tt := range tests {
+		parts, err := splitLabel(tt.label)
+		if tt.want == nil {
+			if !errors.Is(err, errInvalidLabel) {
+				t.Errorf("splitLabel(%q) returned %v, %v; want errInvalidLabel", tt.label, parts, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(parts, tt.want) {
+			t.Errorf("splitLabel(%q) = %v, %v; want %v", tt.label, parts, err, tt.want)
+		}
+	}
+}
+
+func TestLabelTaxonomyHas(t *testing.T) {
+	labels := LabelTaxonomy{"bird": {"song": {"alarm"}, "call": {}}, "frog": {}}
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"bird", true},
+		{"bird/song", true},
+		{"bird/song/alarm", true},
+		{"bird/call", true},
+		{"frog", true},
+		{"bird/song/trill", false},
+		{"bird/croak", false},
+		{"frog/croak", false},
+		{"insect", false},
+	}
+	for _, tt := range tests {
+		if got := labels.has(tt.label); got != tt.want {
+			t.Errorf("has(%q) = %t, want %t", tt.label, got, tt.want)
+		}
+	}
+	want := []string{"bird", "bird/call", "bird/song", "bird/song/alarm", "frog"}
+	if got := labels.paths(); !reflect.DeepEqual(got, want) {
+		t.Errorf("paths() = %v, want %v", got, want)
+	}
+}
+
+func TestLabelTaxonomyNormalize(t *testing.T) {
+	normalized, err := LabelTaxonomy{"bird": {"song": {"alarm", "alarm"}, "call": nil}, "frog": nil}.normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LabelTaxonomy{"bird": {"song": {"alarm"}, "call": {}}, "frog": {}}
+	if !reflect.DeepEqual(normalized, want) {
+		t.Errorf("normalized to %v, want %v", normalized, want)
+	}
+	if _, err := (LabelTaxonomy{"bird": {"so/ng": nil}}).normalize(); !errors.Is(err, errInvalidLabel) {
+		t.Errorf("name with a separator: got error %v, want errInvalidLabel", err)
+	}
+}
+
+func TestLabelCalls(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	steps := []struct {
+		name    string
+		call    func() error
+		wantErr error
+		want    LabelTaxonomy
+	}{
+		{"add with parents", func() error { return app.AddLabel("birds", "bird/song/alarm") }, nil,
+			LabelTaxonomy{"bird": {"song": {"alarm"}}}},
+		{"add existing", func() error { return app.AddLabel("birds", "bird/song") }, errLabelExists, nil},
+		{"add sibling", func() error { return app.AddLabel("birds", "bird/call/contact") }, nil,
+			LabelTaxonomy{"bird": {"song": {"alarm"}, "call": {"contact"}}}},
+		{"rename", func() error { _, err := app.RenameLabel("birds", "bird/song/alarm", "warning"); return err }, nil,
+			LabelTaxonomy{"bird": {"song": {"warning"}, "call": {"contact"}}}},
+		{"rename onto existing", func() error { _, err := app.RenameLabel("birds", "bird/song", "call"); return err }, errLabelExists, nil},
+		{"merge", func() error { _, err := app.MergeLabels("birds", "bird/song", "bird/call"); return err }, nil,
+			LabelTaxonomy{"bird": {"call": {"contact", "warning"}}}},
+		{"merge across levels", func() error { _, err := app.MergeLabels("birds", "bird/call", "bird"); return err }, errInvalidLabel, nil},
+		{"delete missing", func() error { return app.DeleteLabel("birds", "frog") }, errLabelNotFound, nil},
+		{"delete", func() error { return app.DeleteLabel("birds", "bird/call/contact") }, nil,
+			LabelTaxonomy{"bird": {"call": {"warning"}}}},
+		{"save", func() error { return app.SaveLabels("birds", LabelTaxonomy{"frog": nil}) }, nil,
+			LabelTaxonomy{"frog": {}}},
+	}
+	for _, step := range steps {
+		err := step.call()
+		if !errors.Is(err, step.wantErr) {
+			t.Fatalf("%s: got error %v, want %v", step.name, err, step.wantErr)
+		}
+		if step.want == nil {
+			continue
+		}
+		labels, err := app.GetLabels("birds")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, subcategories := range labels {
+			for _, subsubcategories := range subcategories {
+				sort.Strings(subsubcategories)
+			}
+		}
+		if !reflect.DeepEqual(labels, step.want) {
+			t.Fatalf("%s: labels %v, want %v", step.name, labels, step.want)
+		}
+	}
+}
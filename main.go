@@ -28,23 +28,52 @@ func main() {
     // Load .env file if it exists
     err := godotenv.Load()
     if err != nil {
-        fmt.Println("No .env file found, using default settings")
+        fmt.Fprintln(os.Stderr, "No .env file found, using default settings")
     }
 
-    app := NewApp()
+    // Pick the workspace from -home, NEURALFORGE_HOME or ~/NeuralForge
+    homeFlag, args, err := parseWorkspaceFlag(os.Args[1:])
+    if err != nil {
+        writeJSON(os.Stderr, apiError{Error: err.Error()})
+        os.Exit(exitUsage)
+    }
+    workspace, err := resolveWorkspace(homeFlag)
+    if err != nil {
+        writeJSON(os.Stderr, apiError{Error: fmt.Sprintf("error resolving workspace: %v", err)})
+        os.Exit(exitError)
+    }
+
+    app := NewApp(workspace)
+
+    // Run a headless command instead of the GUI or server when one is given
+    if code, ok := runCLI(app, args); ok {
+        os.Exit(code)
+    }
 
     // Check if we should run in server mode
     serverMode := os.Getenv("SERVER_MODE")
     //devMode := os.Getenv("DEV_MODE")
     if serverMode == "true" {
-        fiberApp = fiber.New()
-
+        fiberApp = fiber.New(fiber.Config{
+            ErrorHandler: apiErrorHandler, // Answer every failed request with a JSON error body
+            // Bodies over the limit are streamed rather than buffered, so limitBody
+            // can allow large uploads on the prediction route only
+            BodyLimit:                    defaultBodyLimit,
+            StreamRequestBody:            true,
+            DisablePreParseMultipartForm: true,
+        })
+
+
+        // Enable CORS only for the origins listed in CORS_ALLOW_ORIGINS, such as a
+        // frontend dev server; the frontend served by this server needs none
+        if origins := os.Getenv("CORS_ALLOW_ORIGINS"); origins != "" {
+            fiberApp.Use(cors.New(cors.Config{
+                AllowOrigins: origins, // Comma separated
+                AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
+            }))
+        }
 
-        // Enable CORS for all routes and origins
-        fiberApp.Use(cors.New(cors.Config{
-            AllowOrigins: "*", // Allow all origins
-            AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-        }))
+        fiberApp.Use(limitBody)
 
         fmt.Println("Running server mode")
         runServerMode(app)
@@ -56,7 +85,11 @@ func main() {
 
 
 func runServerMode(app *App) {
-    
+    if err := app.workspace.ensure(); err != nil {
+        log.Fatalf("Error creating workspace %s: %v", app.workspace.Root, err)
+    }
+    fmt.Printf("Using workspace %s\n", app.workspace.Root)
+
     // Serve the React frontend from the embedded assets
     fiberApp.Use("/", filesystem.New(filesystem.Config{
         Root:       http.FS(assets), // Serve embedded assets
@@ -124,7 +157,7 @@ func setupRoutes(fiberApp *fiber.App, appLogic *App) {
     fiberApp.Get("/api/greet/:name", func(c *fiber.Ctx) error {
         name := c.Params("name")
         if name == "" {
-            return c.Status(400).SendString("Name parameter is required")
+            return sendError(c, 400, fmt.Errorf("name parameter is required"))
         }
         greeting := appLogic.Greet(name)
         return c.Status(200).SendString(greeting) // Send 200 status for success
@@ -134,7 +167,7 @@ func setupRoutes(fiberApp *fiber.App, appLogic *App) {
     fiberApp.Get("/api/list-projects", func(c *fiber.Ctx) error {
         projects, err := appLogic.ListProjects()
         if err != nil {
-            return c.Status(500).SendString("Failed to list projects: " + err.Error())
+            return sendError(c, 500, fmt.Errorf("failed to list projects: %v", err))
         }
         if len(projects) == 0 {
             return c.Status(204).JSON([]string{}) // Send 204 No Content if no projects found
@@ -142,23 +175,33 @@ func setupRoutes(fiberApp *fiber.App, appLogic *App) {
         return c.Status(200).JSON(projects) // Send 200 status for success with project list
     })
 
+    // Get the workspace directory of this server
+    fiberApp.Get("/api/workspace", func(c *fiber.Ctx) error {
+        return c.Status(200).JSON(fiber.Map{"root": appLogic.GetWorkspace()})
+    })
+
     // Create project route
     fiberApp.Post("/api/create-project", func(c *fiber.Ctx) error {
         var body struct {
             ProjectName string `json:"projectName"`
+            ProjectType string `json:"projectType"` // Inferred from the name if empty
         }
         if err := c.BodyParser(&body); err != nil {
-            return c.Status(400).SendString("Invalid request body: " + err.Error())
+            return sendError(c, 400, fmt.Errorf("invalid request body: %v", err))
         }
         if body.ProjectName == "" {
-            return c.Status(400).SendString("ProjectName is required")
+            return sendError(c, 400, fmt.Errorf("projectName is required"))
         }
-        projectDir, err := appLogic.CreateProject(body.ProjectName)
+        projectDir, err := appLogic.CreateProjectWithType(body.ProjectName, body.ProjectType)
         if err != nil {
-            return c.Status(500).SendString("Failed to create project: " + err.Error())
+            return sendError(c, errorStatus(err, 500), fmt.Errorf("failed to create project: %w", err))
         }
         return c.Status(201).SendString(projectDir) // Send 201 status for successful creation
     })
 
+    // Project, pipeline and job routes
+    setupProjectRoutes(fiberApp, appLogic)
+    setupJobRoutes(fiberApp, appLogic)
+
     // Add more routes as needed
 }
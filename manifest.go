@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	manifestFile         = "manifest.json"
+	manifestVersion      = 1
+	manifestSaveInterval = 2 * time.Second // Minimum time between saves while a run is in progress
+)
+
+// Pipeline stages recorded in the manifest.
+const (
+	stageConverted   = "converted"
+	stageChunked     = "chunked"
+	stageSpectrogram = "spectrogram"
+	stageFeatures    = "features"
+	stageClustered   = "clustered"
+)
+
+// FileFingerprint identifies the content of a file. The content hash is only
+// recomputed when the size or modification time changes.
+type FileFingerprint struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentHash string    `json:"content_hash"`
+}
+
+// StageRecord notes that a stage completed with the given parameters.
+type StageRecord struct {
+	ParamsHash  string    `json:"params_hash"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// ManifestFile tracks one WAV file of the sounds directory through the
+// pipeline. The entry outlives the WAV when it is deleted after processing.
+type ManifestFile struct {
+	Source *FileFingerprint       `json:"source,omitempty"` // Original file the WAV was converted from
+	WAV    FileFingerprint        `json:"wav"`
+	Chunks []string               `json:"chunks,omitempty"` // MD5 hashes of the chunk spectrograms
+	Stages map[string]StageRecord `json:"stages"`
+}
+
+// PipelineManifest records, per project, which files have been through which
+// stages with which parameters, so reruns only process new or changed files.
+type PipelineManifest struct {
+	Version   int                      `json:"version"`
+	UpdatedAt time.Time                `json:"updated_at"`
+	Files     map[string]*ManifestFile `json:"files"` // Keyed by the WAV path relative to the project
+}
+
+// GetPipelineManifest returns the manifest of a project.
+func (a *App) GetPipelineManifest(projectName string) (*PipelineManifest, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := openManifest(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	return &store.manifest, nil
+}
+
+func (f *ManifestFile) stageDone(stage, paramsHash string) bool {
+	record, ok := f.Stages[stage]
+	return ok && record.ParamsHash == paramsHash
+}
+
+// manifestStore guards a loaded manifest for concurrent workers and saves it
+// at most every manifestSaveInterval, so a crashed run loses little progress.
+type manifestStore struct {
+	mu       sync.Mutex
+	path     string
+	manifest PipelineManifest
+	lastSave time.Time
+}
+
+func openManifest(projectDir string) (*manifestStore, error) {
+	store := &manifestStore{
+		path:     filepath.Join(projectDir, manifestFile),
+		manifest: PipelineManifest{Version: manifestVersion, Files: map[string]*ManifestFile{}},
+	}
+
+	fileData, err := os.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %v", err)
+	}
+	if err := json.Unmarshal(fileData, &store.manifest); err != nil {
+		return nil, fmt.Errorf("error unmarshalling manifest: %v", err)
+	}
+	if store.manifest.Files == nil {
+		store.manifest.Files = map[string]*ManifestFile{}
+	}
+	return store, nil
+}
+
+// lookup returns a copy of the entry for a WAV path.
+func (s *manifestStore) lookup(key string) (ManifestFile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.manifest.Files[key]
+	if !ok {
+		return ManifestFile{}, false
+	}
+	return *file, true
+}
+
+// update changes the entry for a WAV path, creating it if needed, and saves
+// the manifest if the last save is long enough ago.
+func (s *manifestStore) update(key string, fn func(file *ManifestFile)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, ok := s.manifest.Files[key]
+	if !ok {
+		file = &ManifestFile{}
+		s.manifest.Files[key] = file
+	}
+	if file.Stages == nil {
+		file.Stages = map[string]StageRecord{}
+	}
+	fn(file)
+
+	if time.Since(s.lastSave) < manifestSaveInterval {
+		return nil
+	}
+	return s.saveLocked()
+}
+
+// snapshot returns a copy of all entries.
+func (s *manifestStore) snapshot() map[string]ManifestFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string]ManifestFile, len(s.manifest.Files))
+	for key, file := range s.manifest.Files {
+		files[key] = *file
+	}
+	return files
+}
+
+// markStage records a completed stage for every entry whose chunks are all
+// in done, and saves the manifest.
+func (s *manifestStore) markStage(stage, paramsHash string, done map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, file := range s.manifest.Files {
+		if len(file.Chunks) == 0 {
+			continue
+		}
+		complete := true
+		for _, md5Hash := range file.Chunks {
+			complete = complete && done[md5Hash]
+		}
+		if complete {
+			file.Stages[stage] = StageRecord{ParamsHash: paramsHash, CompletedAt: now}
+		}
+	}
+	return s.saveLocked()
+}
+
+// chunkOwners maps every chunk MD5 hash to the WAV paths that produced it.
+func (s *manifestStore) chunkOwners() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owners := map[string][]string{}
+	for key, file := range s.manifest.Files {
+		for _, md5Hash := range file.Chunks {
+			owners[md5Hash] = append(owners[md5Hash], key)
+		}
+	}
+	return owners
+}
+
+func (s *manifestStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+// saveLocked writes the manifest through a temporary file so a crash never
+// leaves it half written.
+func (s *manifestStore) saveLocked() error {
+	s.manifest.UpdatedAt = time.Now()
+	fileData, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := s.path + ".tmp"
+	if err := os.WriteFile(tempPath, fileData, os.ModePerm); err != nil {
+		return fmt.Errorf("error saving manifest: %v", err)
+	}
+	if err := os.Rename(tempPath, s.path); err != nil {
+		return fmt.Errorf("error saving manifest: %v", err)
+	}
+	s.lastSave = time.Now()
+	return nil
+}
+
+// fingerprintFile describes a file, reusing the previous content hash when the
+// size and modification time are unchanged. changed reports whether the
+// content differs from previous.
+func fingerprintFile(path, name string, previous *FileFingerprint) (fingerprint FileFingerprint, changed bool, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileFingerprint{}, true, err
+	}
+	fingerprint = FileFingerprint{Path: name, Size: info.Size(), ModTime: info.ModTime()}
+	if previous != nil && previous.Size == fingerprint.Size && previous.ModTime.Equal(fingerprint.ModTime) {
+		fingerprint.ContentHash = previous.ContentHash
+		return fingerprint, false, nil
+	}
+
+	fingerprint.ContentHash, err = calculateMD5FromFile(path)
+	if err != nil {
+		return FileFingerprint{}, true, err
+	}
+	return fingerprint, previous == nil || previous.ContentHash != fingerprint.ContentHash, nil
+}
+
+// paramsHash fingerprints the settings a stage ran with.
+func paramsHash(params ...any) string {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	hash := md5.Sum(jsonData)
+	return hex.EncodeToString(hash[:])
+}
+
+// manifestKey is the manifest key of a file inside the project directory.
+func manifestKey(projectDir, path string) string {
+	rel, err := filepath.Rel(projectDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestManifestStoreRoundTrip(t *testing.T) {
+	projectDir := t.TempDir()
+	store, err := openManifest(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := map[string][]string{
+		"sounds/a.wav": {"c1", "c2"},
+		"sounds/b.wav": {"c2", "c3"},
+		"sounds/c.wav": nil,
+	}
+	for key, md5Hashes := range chunks {
+		md5Hashes := md5Hashes
+		if err := store.update(key, func(file *ManifestFile) { file.Chunks = md5Hashes }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only a.wav has all of its chunks done, and c.wav has none to be done
+	if err := store.markStage(stageFeatures, paramsHash("features", 1), map[string]bool{"c1": true, "c2": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openManifest(projectDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key    string
+		params string
+		done   bool
+	}{
+		{"sounds/a.wav", paramsHash("features", 1), true},
+		{"sounds/a.wav", paramsHash("features", 2), false},
+		{"sounds/b.wav", paramsHash("features", 1), false},
+		{"sounds/c.wav", paramsHash("features", 1), false},
+	}
+	for _, tt := range tests {
+		file, ok := reopened.lookup(tt.key)
+		if !ok {
+			t.Fatalf("%s is missing from the saved manifest", tt.key)
+		}
+		if done := file.stageDone(stageFeatures, tt.params); done != tt.done {
+			t.Errorf("%s: stage done %t, want %t", tt.key, done, tt.done)
+		}
+	}
+
+	owners := reopened.chunkOwners()
+	sort.Strings(owners["c2"])
+	if !reflect.DeepEqual(owners["c2"], []string{"sounds/a.wav", "sounds/b.wav"}) || len(owners["c3"]) != 1 {
+		t.Errorf("chunk owners %v", owners)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, manifestFile+".tmp")); !os.IsNotExist(err) {
+		t.Error("the temporary manifest file was left behind")
+	}
+}
+
+func TestFingerprintFile(t *testing.T) {
+	path := writeTestFile(t, "a.wav", []byte("first"))
+	first, changed, err := fingerprintFile(path, "sounds/a.wav", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed || first.ContentHash == "" || first.Size != 5 || first.Path != "sounds/a.wav" {
+		t.Fatalf("first fingerprint %+v, changed %t", first, changed)
+	}
+
+	// An unchanged size and modification time reuse the stored hash
+	cached := first
+	cached.ContentHash = "cached"
+	if fingerprint, changed, err := fingerprintFile(path, "sounds/a.wav", &cached); err != nil || changed || fingerprint.ContentHash != "cached" {
+		t.Errorf("unchanged file: %+v, changed %t, error %v", fingerprint, changed, err)
+	}
+
+	// Touching the file rehashes it, but the content is still the same
+	later := first.ModTime.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if fingerprint, changed, err := fingerprintFile(path, "sounds/a.wav", &first); err != nil || changed || !fingerprint.ModTime.Equal(later) {
+		t.Errorf("touched file: %+v, changed %t, error %v", fingerprint, changed, err)
+	}
+
+	if err := os.WriteFile(path, []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later.Add(time.Minute), later.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := fingerprintFile(path, "sounds/a.wav", &first); err != nil || !changed {
+		t.Errorf("rewritten file: changed %t, error %v", changed, err)
+	}
+}
+
+func TestParamsHash(t *testing.T) {
+	chunking := defaultChunkConfig()
+	if paramsHash(chunking) != paramsHash(defaultChunkConfig()) {
+		t.Error("equal settings hash differently")
+	}
+	chunking.Duration++
+	if paramsHash(chunking) == paramsHash(defaultChunkConfig()) {
+		t.Error("different settings hash the same")
+	}
+	if paramsHash("a", "b") == paramsHash("ab") {
+		t.Error("separate parameters hash like their concatenation")
+	}
+}
+
+func TestManifestKey(t *testing.T) {
+	projectDir := filepath.Join("projects", "test")
+	if got := manifestKey(projectDir, filepath.Join(projectDir, "sounds", "a.wav")); got != "sounds/a.wav" {
+		t.Errorf("got %q, want sounds/a.wav", got)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import "fmt"
+
+// pipelineSettingsFile held the pipeline settings before they moved into the
+// project config.
+const pipelineSettingsFile = "pipeline_settings.json"
+
+// Retention policies for the WAV files in the sounds directory.
+const (
+	retentionKeep       = "keep"        // Keep source WAVs after processing
+	retentionKeepChunks = "keep_chunks" // Also keep each chunk as its own WAV
+	retentionDelete     = "delete"      // Delete source WAVs once their spectrograms exist
+)
+
+// PipelineSettings holds the tunable parameters of the sound processing
+// pipeline for a project.
+type PipelineSettings struct {
+	Conversion  ConversionConfig `json:"conversion"`
+	Activity    ActivityConfig   `json:"activity"`
+	Chunking    ChunkConfig      `json:"chunking"`
+	Spectrogram STFTConfig       `json:"spectrogram"`
+	Features    FeatureConfig    `json:"features"`
+	Clustering  ClusteringConfig `json:"clustering"`
+	Embedding   EmbeddingConfig  `json:"embedding"`
+	Training    TrainingConfig   `json:"training"`
+	Evaluation  EvaluationConfig `json:"evaluation"`
+	Retention   RetentionConfig  `json:"retention"`
+}
+
+// ConversionConfig controls how selected files are copied or converted into
+// the sounds directory.
+type ConversionConfig struct {
+	SampleRate int `json:"sample_rate"` // Resample every file with FFmpeg, 0 keeps the rate of each file
+}
+
+func (cfg ConversionConfig) validate() error {
+	if cfg.SampleRate != 0 && (cfg.SampleRate < 1000 || cfg.SampleRate > 384000) {
+		return fmt.Errorf("sample rate must be 0 or between 1000 and 384000 Hz, got %d", cfg.SampleRate)
+	}
+	return nil
+}
+
+// paramsHash is the parameter hash of the converted stage. Copies at the
+// original rate hash to "", as they did before the rate could be set.
+func (cfg ConversionConfig) paramsHash() string {
+	if cfg.SampleRate == 0 {
+		return ""
+	}
+	return paramsHash(cfg)
+}
+
+// RetentionConfig decides which audio files survive spectrogram generation.
+// Playback of clustered chunks needs either the source or the chunk files.
+type RetentionConfig struct {
+	Policy string `json:"policy"`
+}
+
+func defaultPipelineSettings() PipelineSettings {
+	return PipelineSettings{
+		Conversion:  ConversionConfig{SampleRate: 0},
+		Activity:    defaultActivityConfig(),
+		Chunking:    defaultChunkConfig(),
+		Spectrogram: defaultSTFTConfig(),
+		Features:    defaultFeatureConfig(),
+		Clustering:  defaultClusteringConfig(),
+		Embedding:   defaultEmbeddingConfig(),
+		Training:    defaultTrainingConfig(),
+		Evaluation:  defaultEvaluationConfig(),
+		Retention:   RetentionConfig{Policy: retentionKeep},
+	}
+}
+
+func (s PipelineSettings) validate() error {
+	if err := s.Conversion.validate(); err != nil {
+		return fmt.Errorf("invalid conversion settings: %v", err)
+	}
+	if err := s.Activity.validate(); err != nil {
+		return fmt.Errorf("invalid activity detection settings: %v", err)
+	}
+	if err := s.Chunking.validate(); err != nil {
+		return fmt.Errorf("invalid chunking settings: %v", err)
+	}
+	if err := s.Spectrogram.validate(); err != nil {
+		return fmt.Errorf("invalid spectrogram settings: %v", err)
+	}
+	if err := s.Features.validate(); err != nil {
+		return fmt.Errorf("invalid feature settings: %v", err)
+	}
+	if err := s.Clustering.validate(); err != nil {
+		return fmt.Errorf("invalid clustering settings: %v", err)
+	}
+	if err := s.Embedding.validate(); err != nil {
+		return fmt.Errorf("invalid embedding settings: %v", err)
+	}
+	if err := s.Training.validate(); err != nil {
+		return fmt.Errorf("invalid training settings: %v", err)
+	}
+	if err := s.Evaluation.validate(); err != nil {
+		return fmt.Errorf("invalid evaluation settings: %v", err)
+	}
+	switch s.Retention.Policy {
+	case retentionKeep, retentionKeepChunks, retentionDelete:
+	default:
+		return fmt.Errorf("unknown retention policy: %s", s.Retention.Policy)
+	}
+	return nil
+}
+
+// loadPipelineSettings reads the pipeline settings from the project config,
+// falling back to the defaults for anything that has not been saved.
+func loadPipelineSettings(projectDir string) (PipelineSettings, error) {
+	config, err := loadProjectConfig(projectDir)
+	if err != nil {
+		return defaultPipelineSettings(), err
+	}
+	return config.Pipeline, nil
+}
+
+func savePipelineSettings(projectDir string, settings PipelineSettings) error {
+	return updateProjectConfig(projectDir, func(config *ProjectConfig) error {
+		config.Pipeline = settings
+		return nil
+	})
+}
+
+func (a *App) GetPipelineSettings(projectName string) (*PipelineSettings, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+func (a *App) SavePipelineSettings(projectName string, settings PipelineSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return err
+	}
+	if !projectExists(projectDir) {
+		return fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	return savePipelineSettings(projectDir, settings)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	projectConfigFile = "config.json"
+	// projectConfigVersion is the schema version written by this build.
+	// Version 1 is the untyped {"selected_directory": ...} map, with the
+	// pipeline settings in a separate pipeline_settings.json.
+	projectConfigVersion = 2
+)
+
+// Project types.
+const (
+	projectTypeSoundSupervised   = "sound_supervised"
+	projectTypeSoundUnsupervised = "sound_unsupervised"
+)
+
+// projectConfigMu serializes reads and writes of project configs, so jobs
+// loading a config while it is migrated or updated see a whole file.
+var projectConfigMu sync.Mutex
+
+// errInvalidProjectType is returned, wrapped with the type, for unknown
+// project types.
+var errInvalidProjectType = errors.New("invalid project type")
+
+// ProjectConfig is the configuration of a project, kept in config.json. The
+// pipeline settings live here so an experiment can be reproduced from the
+// project folder alone.
+type ProjectConfig struct {
+	SchemaVersion     int              `json:"schema_version"`
+	ProjectType       string           `json:"project_type"`
+	SelectedDirectory string           `json:"selected_directory"` // Empty until a source directory is selected
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+	Pipeline          PipelineSettings `json:"pipeline"`
+}
+
+func defaultProjectConfig(projectType string) ProjectConfig {
+	now := time.Now()
+	return ProjectConfig{
+		SchemaVersion: projectConfigVersion,
+		ProjectType:   projectType,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Pipeline:      defaultPipelineSettings(),
+	}
+}
+
+func (cfg ProjectConfig) validate() error {
+	if err := checkProjectType(cfg.ProjectType); err != nil {
+		return err
+	}
+	return cfg.Pipeline.validate()
+}
+
+func checkProjectType(projectType string) error {
+	switch projectType {
+	case projectTypeSoundSupervised, projectTypeSoundUnsupervised:
+		return nil
+	}
+	return fmt.Errorf("%w: %q", errInvalidProjectType, projectType)
+}
+
+// projectTypeFromName infers the type of a project from the _sup suffix the
+// frontend gives supervised project names, defaulting to unsupervised.
+func projectTypeFromName(projectName string) string {
+	if strings.HasSuffix(strings.ToLower(projectName), "_sup") {
+		return projectTypeSoundSupervised
+	}
+	return projectTypeSoundUnsupervised
+}
+
+// configMigration upgrades a raw config of one schema version to the next.
+type configMigration func(projectDir string, raw map[string]json.RawMessage) error
+
+// projectConfigMigrations[v] upgrades a config from version v to v+1.
+var projectConfigMigrations = map[int]configMigration{
+	1: migrateProjectConfigV1,
+}
+
+// migrateProjectConfigV1 adds the fields of version 2 to an untyped config:
+// the project type, inferred from the name and the annotations, timestamps
+// taken from the project folder, and the pipeline settings moved in from
+// pipeline_settings.json.
+func migrateProjectConfigV1(projectDir string, raw map[string]json.RawMessage) error {
+	projectType := projectTypeFromName(filepath.Base(projectDir))
+	if _, err := os.Stat(filepath.Join(projectDir, annotationsFile)); err == nil {
+		projectType = projectTypeSoundSupervised
+	}
+
+	createdAt := time.Now()
+	if info, err := os.Stat(projectDir); err == nil {
+		createdAt = info.ModTime()
+	}
+
+	pipeline := json.RawMessage("{}")
+	data, err := os.ReadFile(filepath.Join(projectDir, pipelineSettingsFile))
+	if err == nil {
+		pipeline = data
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error reading pipeline settings: %v", err)
+	}
+
+	fields := map[string]any{
+		"project_type": projectType,
+		"created_at":   createdAt,
+		"updated_at":   time.Now(),
+		"pipeline":     pipeline,
+	}
+	for key, value := range fields {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		raw[key] = data
+	}
+	return nil
+}
+
+// loadProjectConfig reads the config of a project, upgrading files of older
+// schema versions and saving the result. Projects without a config get the
+// defaults, which are not saved. Settings missing from the file fall back to
+// their defaults.
+func loadProjectConfig(projectDir string) (ProjectConfig, error) {
+	projectConfigMu.Lock()
+	defer projectConfigMu.Unlock()
+	return loadProjectConfigLocked(projectDir)
+}
+
+func loadProjectConfigLocked(projectDir string) (ProjectConfig, error) {
+	config := defaultProjectConfig(projectTypeFromName(filepath.Base(projectDir)))
+
+	fileData, err := os.ReadFile(filepath.Join(projectDir, projectConfigFile))
+	if os.IsNotExist(err) {
+		// Pipeline settings may have been saved before any config
+		data, err := os.ReadFile(filepath.Join(projectDir, pipelineSettingsFile))
+		if err == nil {
+			if err := json.Unmarshal(data, &config.Pipeline); err != nil {
+				return config, fmt.Errorf("error unmarshalling pipeline settings: %v", err)
+			}
+		}
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(fileData, &raw); err != nil {
+		return config, fmt.Errorf("error unmarshalling config data: %v", err)
+	}
+	version := 1
+	if data, ok := raw["schema_version"]; ok {
+		if err := json.Unmarshal(data, &version); err != nil {
+			return config, fmt.Errorf("invalid config schema version: %v", err)
+		}
+	}
+	if version > projectConfigVersion {
+		return config, fmt.Errorf("config schema version %d is newer than the supported version %d", version, projectConfigVersion)
+	}
+
+	migrated := version < projectConfigVersion
+	for ; version < projectConfigVersion; version++ {
+		migrate, ok := projectConfigMigrations[version]
+		if !ok {
+			return config, fmt.Errorf("no migration for config schema version %d", version)
+		}
+		if err := migrate(projectDir, raw); err != nil {
+			return config, fmt.Errorf("error migrating config from schema version %d: %v", version, err)
+		}
+	}
+	raw["schema_version"], _ = json.Marshal(projectConfigVersion)
+
+	fileData, err = json.Marshal(raw)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(fileData, &config); err != nil {
+		return config, fmt.Errorf("error unmarshalling config data: %v", err)
+	}
+
+	if migrated {
+		if err := saveProjectConfig(projectDir, config); err != nil {
+			return config, fmt.Errorf("error saving migrated config: %v", err)
+		}
+		fmt.Printf("Migrated %s of %s to schema version %d\n", projectConfigFile, filepath.Base(projectDir), projectConfigVersion)
+	}
+	return config, nil
+}
+
+// saveProjectConfig writes the config through a temporary file, so readers
+// never see it half written. A pipeline_settings.json left from before the
+// settings moved into the config is removed.
+func saveProjectConfig(projectDir string, config ProjectConfig) error {
+	config.SchemaVersion = projectConfigVersion
+	jsonData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(projectDir, projectConfigFile), jsonData); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(projectDir, pipelineSettingsFile))
+	return nil
+}
+
+// updateProjectConfig applies fn to the config of a project and saves it
+// with a new update time.
+func updateProjectConfig(projectDir string, fn func(config *ProjectConfig) error) error {
+	projectConfigMu.Lock()
+	defer projectConfigMu.Unlock()
+
+	config, err := loadProjectConfigLocked(projectDir)
+	if err != nil {
+		return err
+	}
+	if err := fn(&config); err != nil {
+		return err
+	}
+	config.UpdatedAt = time.Now()
+	return saveProjectConfig(projectDir, config)
+}
+
+// GetProjectConfig returns the configuration of a project.
+func (a *App) GetProjectConfig(projectName string) (*ProjectConfig, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	if !projectExists(projectDir) {
+		return nil, fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+
+	config, err := loadProjectConfig(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
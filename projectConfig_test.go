@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProjectTypeFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"birds", projectTypeSoundUnsupervised},
+		{"birds_sup", projectTypeSoundSupervised},
+		{"Birds_SUP", projectTypeSoundSupervised},
+		{"birds_unsup", projectTypeSoundUnsupervised},
+		{"superb", projectTypeSoundUnsupervised},
+		{"birds_sup_old", projectTypeSoundUnsupervised},
+	}
+	for _, tt := range tests {
+		if got := projectTypeFromName(tt.name); got != tt.want {
+			t.Errorf("projectTypeFromName(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// writeV1Project lays out a project as saved before typed configs: an
+// untyped config.json and the pipeline settings in their own file.
+func writeV1Project(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	projectDir := filepath.Join(t.TempDir(), name)
+	if err := os.Mkdir(projectDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range files {
+		if err := os.WriteFile(filepath.Join(projectDir, file), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return projectDir
+}
+
+func TestMigrateProjectConfigV1(t *testing.T) {
+	tests := []struct {
+		name          string
+		project       string
+		files         map[string]string
+		wantType      string
+		wantDirectory string
+		wantMinK      int
+	}{
+		{
+			name:          "with pipeline settings",
+			project:       "birds",
+			files:         map[string]string{projectConfigFile: `{"selected_directory": "/data/birds"}`, pipelineSettingsFile: `{"clustering": {"min_k": 3}}`},
+			wantType:      projectTypeSoundUnsupervised,
+			wantDirectory: "/data/birds",
+			wantMinK:      3,
+		},
+		{
+			name:     "supervised by name",
+			project:  "birds_sup",
+			files:    map[string]string{projectConfigFile: `{}`},
+			wantType: projectTypeSoundSupervised,
+			wantMinK: defaultClusteringConfig().MinK,
+		},
+		{
+			name:     "supervised by annotations",
+			project:  "frogs",
+			files:    map[string]string{projectConfigFile: `{"selected_directory": ""}`, annotationsFile: `[]`},
+			wantType: projectTypeSoundSupervised,
+			wantMinK: defaultClusteringConfig().MinK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projectDir := writeV1Project(t, tt.project, tt.files)
+			config, err := loadProjectConfig(projectDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.SchemaVersion != projectConfigVersion || config.ProjectType != tt.wantType || config.SelectedDirectory != tt.wantDirectory {
+				t.Errorf("migrated to version %d, type %s, directory %q; want %d, %s, %q",
+					config.SchemaVersion, config.ProjectType, config.SelectedDirectory, projectConfigVersion, tt.wantType, tt.wantDirectory)
+			}
+			if config.Pipeline.Clustering.MinK != tt.wantMinK || config.Pipeline.Spectrogram != defaultSTFTConfig() {
+				t.Errorf("pipeline settings %+v, want min K %d and default spectrogram settings", config.Pipeline, tt.wantMinK)
+			}
+			if config.CreatedAt.IsZero() || config.UpdatedAt.Before(config.CreatedAt) {
+				t.Errorf("timestamps created %v, updated %v", config.CreatedAt, config.UpdatedAt)
+			}
+
+			// The migrated config replaces both files
+			if _, err := os.Stat(filepath.Join(projectDir, pipelineSettingsFile)); !os.IsNotExist(err) {
+				t.Errorf("%s left after the migration", pipelineSettingsFile)
+			}
+			data, err := os.ReadFile(filepath.Join(projectDir, projectConfigFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var saved ProjectConfig
+			if err := json.Unmarshal(data, &saved); err != nil || saved.SchemaVersion != projectConfigVersion || saved.ProjectType != tt.wantType {
+				t.Errorf("saved config %s", data)
+			}
+		})
+	}
+}
+
+func TestLoadProjectConfigRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"newer version", `{"schema_version": 99}`, "newer"},
+		{"invalid version", `{"schema_version": "two"}`, "schema version"},
+		{"invalid JSON", `{`, "unmarshalling"},
+	}
+	for _, tt := range tests {
+		projectDir := writeV1Project(t, "birds", map[string]string{projectConfigFile: tt.config})
+		if _, err := loadProjectConfig(projectDir); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want one mentioning %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProjectConfigOfNewProjects(t *testing.T) {
+	app, _ := newTestProject(t, "birds_sup")
+	config, err := app.GetProjectConfig("birds_sup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SchemaVersion != projectConfigVersion || config.ProjectType != projectTypeSoundSupervised {
+		t.Errorf("config version %d of type %s", config.SchemaVersion, config.ProjectType)
+	}
+	if err := config.validate(); err != nil {
+		t.Errorf("default config is invalid: %v", err)
+	}
+	if _, err := app.CreateProjectWithType("frogs", "image_supervised"); err == nil {
+		t.Error("unknown project type accepted")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	projectInfoFile   = "project.json"
+	maxProjectNameLen = 64 // Longest slug given to new projects
+)
+
+// errInvalidProjectName is returned, wrapped with the reason, for project
+// names that cannot be used as a folder of the workspace.
+var errInvalidProjectName = errors.New("invalid project name")
+
+// reservedProjectNames are device names on Windows, which cannot be used as
+// folder names there, with or without an extension.
+var reservedProjectNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// ProjectInfo holds the identity of a project. Name is the slug the project
+// folder is named after and the id used by every call; DisplayName is the
+// name the project was created with. Timestamps live in the project config.
+type ProjectInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// checkProjectName rejects project names that would resolve outside of the
+// projects directory of the workspace: empty names, paths, hidden names and
+// reserved device names. Names of existing projects that are not slugs, such
+// as names with spaces or capitals, pass, so older projects stay reachable.
+func checkProjectName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("%w: name is empty", errInvalidProjectName)
+	case strings.ContainsAny(name, `/\`) || filepath.IsAbs(name) || !filepath.IsLocal(name):
+		return fmt.Errorf("%w: %q must not be a path", errInvalidProjectName, name)
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("%w: %q must not start with a dot", errInvalidProjectName, name)
+	case strings.IndexFunc(name, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0:
+		return fmt.Errorf("%w: %q contains control characters", errInvalidProjectName, name)
+	case reservedProjectName(name):
+		return fmt.Errorf("%w: %q is reserved", errInvalidProjectName, name)
+	}
+	return nil
+}
+
+func reservedProjectName(name string) bool {
+	base, _, _ := strings.Cut(strings.ToLower(name), ".")
+	return reservedProjectNames[strings.TrimSpace(base)]
+}
+
+// projectSlug turns a display name into the name of a new project: lower
+// case ASCII letters and digits, with runs of other characters replaced by a
+// dash. Underscores are kept. Display names that are paths are rejected
+// rather than cleaned up.
+func projectSlug(displayName string) (string, error) {
+	if err := checkProjectName(displayName); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(displayName) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	slug := b.String()
+	if len(slug) > maxProjectNameLen {
+		slug = strings.TrimRight(slug[:maxProjectNameLen], "-")
+	}
+	if slug == "" {
+		return "", fmt.Errorf("%w: %q needs at least one letter or digit", errInvalidProjectName, displayName)
+	}
+	if err := checkProjectName(slug); err != nil {
+		return "", err
+	}
+	return slug, nil
+}
+
+// loadProjectInfo reads the identity of a project. Projects created before
+// identities were recorded get their folder name as display name.
+func loadProjectInfo(projectDir string) (ProjectInfo, error) {
+	info := ProjectInfo{Name: filepath.Base(projectDir)}
+	data, err := os.ReadFile(filepath.Join(projectDir, projectInfoFile))
+	if os.IsNotExist(err) {
+		info.DisplayName = info.Name
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("error parsing %s: %v", projectInfoFile, err)
+	}
+	// The folder is the source of truth for the name, so a copied or renamed
+	// folder keeps working.
+	info.Name = filepath.Base(projectDir)
+	if info.DisplayName == "" {
+		info.DisplayName = info.Name
+	}
+	return info, nil
+}
+
+func saveProjectInfo(projectDir string, info ProjectInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(projectDir, projectInfoFile), data, os.ModePerm)
+}
+
+// GetProjectInfo returns the slug and display name of a project.
+func (a *App) GetProjectInfo(projectName string) (*ProjectInfo, error) {
+	projectDir, err := a.workspace.projectDir(projectName)
+	if err != nil {
+		return nil, err
+	}
+	if !projectExists(projectDir) {
+		return nil, fmt.Errorf("%w: %s", errProjectNotFound, projectName)
+	}
+	info, err := loadProjectInfo(projectDir)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
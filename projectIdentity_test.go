@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckProjectName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"birds", true},
+		{"Birds 2024", true}, // Older projects may have any folder name
+		{"birds_sup", true},
+		{"", false},
+		{"   ", false},
+		{".", false},
+		{"..", false},
+		{"../birds", false},
+		{"birds/../..", false},
+		{`..\birds`, false},
+		{"/etc", false},
+		{".hidden", false},
+		{"bird\x00s", false},
+		{"line\nbreak", false},
+		{"con", false},
+		{"COM1.txt", false},
+		{"nul ", false},
+		{"console", true},
+	}
+	for _, tt := range tests {
+		err := checkProjectName(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("checkProjectName(%q) returned %v", tt.name, err)
+		}
+		if !tt.valid && !errors.Is(err, errInvalidProjectName) {
+			t.Errorf("checkProjectName(%q) returned %v, want errInvalidProjectName", tt.name, err)
+		}
+	}
+}
+
+func TestProjectSlug(t *testing.T) {
+	tests := []struct {
+		displayName string
+		want        string
+	}{
+		{"birds", "birds"},
+		{"Dawn Chorus 2024", "dawn-chorus-2024"},
+		{"  Frogs -- at night!  ", "frogs-at-night"},
+		{"owls_sup", "owls_sup"},
+		{"Café Recordings", "caf-recordings"},
+		{strings.Repeat("a", maxProjectNameLen) + "b", strings.Repeat("a", maxProjectNameLen)},
+		{strings.Repeat("a", maxProjectNameLen-1) + " b", strings.Repeat("a", maxProjectNameLen-1)},
+		// Rejected rather than cleaned up
+		{"../birds", ""},
+		{"..", ""},
+		{"birds/owls", ""},
+		{"!!!", ""},
+		{"aux", ""},
+		{"Aux!", ""},
+	}
+	for _, tt := range tests {
+		slug, err := projectSlug(tt.displayName)
+		if tt.want == "" {
+			if !errors.Is(err, errInvalidProjectName) {
+				t.Errorf("projectSlug(%q) = %q, %v; want errInvalidProjectName", tt.displayName, slug, err)
+			}
+			continue
+		}
+		if err != nil || slug != tt.want {
+			t.Errorf("projectSlug(%q) = %q, %v; want %q", tt.displayName, slug, err, tt.want)
+		}
+	}
+}
+
+func TestProjectCallsStayInWorkspace(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	outside := filepath.Join(filepath.Dir(app.workspace.Root), "outside")
+	if err := os.MkdirAll(outside, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"..", "../outside", "../../outside", outside} {
+		if err := app.DeleteProject(name); !errors.Is(err, errInvalidProjectName) {
+			t.Errorf("DeleteProject(%q) returned %v, want errInvalidProjectName", name, err)
+		}
+		if _, err := app.RenameProject("birds", name); !errors.Is(err, errInvalidProjectName) {
+			t.Errorf("RenameProject to %q returned %v, want errInvalidProjectName", name, err)
+		}
+		if err := app.LogError(name, errors.New("failure"), "test"); err == nil || err.Error() != "failure" {
+			t.Errorf("LogError(%q) returned %v, want the error it was given", name, err)
+		}
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("directory outside the workspace is gone: %v", err)
+	}
+}
+
+func TestCreateAndRenameProject(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	projectDir, err := app.CreateProject("Dawn Chorus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(projectDir) != "dawn-chorus" {
+		t.Errorf("project created in %s, want dawn-chorus", projectDir)
+	}
+	if _, err := app.CreateProject("dawn chorus"); !errors.Is(err, errProjectExists) {
+		t.Errorf("creating a project with the same slug returned %v, want errProjectExists", err)
+	}
+
+	info, err := app.RenameProject("dawn-chorus", "Dusk Chorus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "dusk-chorus" || info.DisplayName != "Dusk Chorus" {
+		t.Errorf("renamed to %+v, want dusk-chorus named Dusk Chorus", info)
+	}
+	if _, err := app.GetProjectInfo("dawn-chorus"); !errors.Is(err, errProjectNotFound) {
+		t.Errorf("old name still found: %v", err)
+	}
+	if _, err := app.RenameProject("dusk-chorus", "Birds"); !errors.Is(err, errProjectExists) {
+		t.Errorf("renaming onto another project returned %v, want errProjectExists", err)
+	}
+	// Changing only the display name keeps the folder
+	if info, err := app.RenameProject("dusk-chorus", "DUSK chorus"); err != nil || info.Name != "dusk-chorus" || info.DisplayName != "DUSK chorus" {
+		t.Errorf("RenameProject returned %+v, %v", info, err)
+	}
+}
+
+func TestLoadProjectInfoOfOlderProjects(t *testing.T) {
+	projectDir := filepath.Join(t.TempDir(), "Old Project")
+	if err := os.Mkdir(projectDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	info, err := loadProjectInfo(projectDir)
+	if err != nil || info.Name != "Old Project" || info.DisplayName != "Old Project" {
+		t.Errorf("loadProjectInfo returned %+v, %v", info, err)
+	}
+}
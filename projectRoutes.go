@@ -0,0 +1,785 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Request body limits.
+const (
+	defaultBodyLimit = 4 << 20 // Every route but audio uploads
+	maxUploadSize    = 1 << 30 // Audio files uploaded to classify
+)
+
+// errBodyTooLarge is returned when a streamed request body passes its limit.
+var errBodyTooLarge = errors.New("request body too large")
+
+// apiError is the body of every failed API request.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func sendError(c *fiber.Ctx, status int, err error) error {
+	return c.Status(status).JSON(apiError{Error: err.Error()})
+}
+
+// apiErrorHandler turns errors returned by handlers, including Fiber's own
+// 404 and 405 errors, into JSON error bodies.
+func apiErrorHandler(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	}
+	return sendError(c, status, err)
+}
+
+// limitBody answers 413 for request bodies over the limit of their route.
+// The server streams every body instead of buffering it, so this reads the
+// body up to the limit before any handler sees it, counting chunked bodies as
+// they arrive. Uploads stay streamed and are limited by uploadForm.
+func limitBody(c *fiber.Ctx) error {
+	if c.Method() == fiber.MethodPost && isPredictionsPath(c.Path()) {
+		if c.Request().Header.ContentLength() > maxUploadSize {
+			return bodyTooLarge(c, maxUploadSize)
+		}
+		return c.Next()
+	}
+	if c.Request().Header.ContentLength() > defaultBodyLimit {
+		return bodyTooLarge(c, defaultBodyLimit)
+	}
+	if stream := c.Request().BodyStream(); stream != nil {
+		body, err := io.ReadAll(io.LimitReader(stream, defaultBodyLimit+1))
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("error reading request body: %v", err))
+		}
+		if len(body) > defaultBodyLimit {
+			return bodyTooLarge(c, defaultBodyLimit)
+		}
+		c.Request().SetBody(body)
+	}
+	return c.Next()
+}
+
+// bodyTooLarge answers 413. The rest of the body is left unread, so the
+// connection is closed afterwards.
+func bodyTooLarge(c *fiber.Ctx, limit int) error {
+	c.Context().SetConnectionClose()
+	return sendError(c, fiber.StatusRequestEntityTooLarge, fmt.Errorf("%w: over %d bytes", errBodyTooLarge, limit))
+}
+
+// limitedReader reads at most remaining bytes and fails with errBodyTooLarge
+// once the underlying reader has more.
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	if int64(n) > r.remaining {
+		n, r.err = int(r.remaining), errBodyTooLarge
+		r.remaining = 0
+		return n, r.err
+	}
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// uploadForm parses a multipart request body of at most limit bytes straight
+// from the stream, keeping large files in temporary files. The caller removes
+// them with RemoveAll.
+func uploadForm(c *fiber.Ctx, limit int64) (*multipart.Form, error) {
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, fmt.Errorf("request is not a multipart form")
+	}
+	body := c.Request().BodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	reader := &limitedReader{reader: body, remaining: limit}
+	form, err := multipart.NewReader(reader, boundary).ReadForm(defaultBodyLimit)
+	if err == nil {
+		// Read what follows the closing boundary, such as the end of a
+		// chunked body, so the connection can serve the next request
+		if _, err = io.Copy(io.Discard, reader); err != nil {
+			form.RemoveAll()
+			form = nil
+		}
+	}
+	if errors.Is(err, errBodyTooLarge) {
+		c.Context().SetConnectionClose()
+	}
+	return form, err
+}
+
+// isPredictionsPath reports whether path is /api/projects/:id/predictions,
+// the only route that takes uploads.
+func isPredictionsPath(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	return len(parts) == 4 && parts[0] == "api" && parts[1] == "projects" && parts[3] == "predictions"
+}
+
+// errorStatus maps the errors of project, label and annotation calls to a status code,
+// falling back to fallback.
+func errorStatus(err error, fallback int) int {
+	switch {
+	case errors.Is(err, errInvalidProjectName), errors.Is(err, errInvalidProjectType), errors.Is(err, errInvalidLabel), errors.Is(err, errInvalidAnnotation):
+		return fiber.StatusBadRequest
+	case errors.Is(err, errOutsideDataRoot):
+		return fiber.StatusForbidden
+	case errors.Is(err, errProjectNotFound), errors.Is(err, errLabelNotFound), errors.Is(err, errAnnotationNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, errBodyTooLarge):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, errProjectExists), errors.Is(err, errProjectBusy),
+		errors.Is(err, errLabelExists), errors.Is(err, errLabelInUse):
+		return fiber.StatusConflict
+	}
+	return fallback
+}
+
+// projectHandler wraps a handler for /api/projects/:id routes, answering 400
+// for invalid project names and 404 when the project does not exist.
+func projectHandler(appLogic *App, fn func(c *fiber.Ctx, projectName string) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		projectName := c.Params("id")
+		projectDir, err := appLogic.workspace.projectDir(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		if !projectExists(projectDir) {
+			return sendError(c, fiber.StatusNotFound, fmt.Errorf("%w: %s", errProjectNotFound, projectName))
+		}
+		return fn(c, projectName)
+	}
+}
+
+// sendLabels answers with the current label tree of a project added to body.
+func sendLabels(c *fiber.Ctx, appLogic *App, projectName string, status int, body fiber.Map) error {
+	labels, err := appLogic.GetLabels(projectName)
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err)
+	}
+	body["labels"] = labels
+	return c.Status(status).JSON(body)
+}
+
+// setupProjectRoutes exposes the project and pipeline methods of App. Steps
+// run synchronously here; POST /api/projects/:id/jobs runs them in the
+// background instead. Reads of results that have not been computed yet
+// answer 404.
+func setupProjectRoutes(fiberApp *fiber.App, appLogic *App) {
+	// List the files of a directory inside the data root, grouped by subdirectory
+	fiberApp.Get("/api/files", func(c *fiber.Ctx) error {
+		dirPath := c.Query("dir")
+		if dirPath == "" {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("dir query parameter is required"))
+		}
+		dirPath, err := appLogic.workspace.dataPath(dirPath)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), err)
+		}
+		fileList, err := appLogic.ListFilesInDirectory(dirPath)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(fiber.StatusOK).SendString(fileList)
+	})
+
+	// Get the selected directory and file list of a project
+	fiberApp.Get("/api/projects/:id", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		projectData, err := appLogic.GetProjectData(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(projectData)
+	}))
+
+	// Get the slug and display name of a project
+	fiberApp.Get("/api/projects/:id/info", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		info, err := appLogic.GetProjectInfo(projectName)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(info)
+	}))
+
+	// Get the typed configuration of a project, including its pipeline settings
+	fiberApp.Get("/api/projects/:id/config", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		config, err := appLogic.GetProjectConfig(projectName)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(config)
+	}))
+
+	// Rename a project
+	fiberApp.Patch("/api/projects/:id", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		info, err := appLogic.RenameProject(projectName, body.Name)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(info)
+	}))
+
+	// Delete a project with all its data
+	fiberApp.Delete("/api/projects/:id", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		if err := appLogic.DeleteProject(projectName); err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}))
+
+	// Select a directory inside the data root as source of a project and record its file list
+	fiberApp.Put("/api/projects/:id/directory", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Directory string `json:"directory"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		if body.Directory == "" {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("directory is required"))
+		}
+		directory, err := appLogic.workspace.dataPath(body.Directory)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), err)
+		}
+		if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("not a directory on the server: %q", body.Directory))
+		}
+		if err := appLogic.SaveSelectedDirectory(directory, projectName); err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		projectData, err := appLogic.GetProjectData(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(projectData)
+	}))
+
+	// Get the pipeline settings of a project
+	fiberApp.Get("/api/projects/:id/settings", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		settings, err := appLogic.GetPipelineSettings(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(settings)
+	}))
+
+	// Replace the pipeline settings of a project; omitted fields get defaults
+	fiberApp.Put("/api/projects/:id/settings", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		settings := defaultPipelineSettings()
+		if err := c.BodyParser(&settings); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		if err := settings.validate(); err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		if err := appLogic.SavePipelineSettings(projectName, settings); err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(settings)
+	}))
+
+	// Get the pipeline manifest of a project
+	fiberApp.Get("/api/projects/:id/manifest", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		manifest, err := appLogic.GetPipelineManifest(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(manifest)
+	}))
+
+	// Convert the selected files to WAV
+	fiberApp.Post("/api/projects/:id/convert", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		if err := appLogic.ConvertFilesToWAV(projectName); err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}))
+
+	// Chunk the WAV files and compute their spectrograms
+	fiberApp.Post("/api/projects/:id/spectrograms", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		md5Hashes, err := appLogic.ProcessAudioChunksAndSpectrograms(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(md5Hashes)
+	}))
+
+	// Get the audio of one spectrogram chunk as a WAV file
+	fiberApp.Get("/api/projects/:id/spectrograms/:md5/audio", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		projectDir, err := appLogic.workspace.projectDir(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		wavData, err := loadChunkAudio(projectDir, c.Params("md5"))
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		c.Set(fiber.HeaderContentType, "audio/wav")
+		return c.Status(fiber.StatusOK).Send(wavData)
+	}))
+
+	// Extract audio features from the spectrograms
+	fiberApp.Post("/api/projects/:id/features", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		md5Hashes, err := appLogic.ExtractAudioFeatures(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(md5Hashes)
+	}))
+
+	// Get the fitted dimensionality reduction model
+	fiberApp.Get("/api/projects/:id/reduction", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		model, err := appLogic.GetDimensionReduction(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(model)
+	}))
+
+	// Fit the dimensionality reduction model
+	fiberApp.Post("/api/projects/:id/reduction", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		model, err := appLogic.FitDimensionReduction(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(model)
+	}))
+
+	// Estimate the optimal number of clusters
+	fiberApp.Post("/api/projects/:id/optimal-clusters", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		k, err := appLogic.CalculateOptimalClusters(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"optimal_k": k})
+	}))
+
+	// Get the saved cluster assignments
+	fiberApp.Get("/api/projects/:id/clusters", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		assignments, err := appLogic.GetClusterAssignments(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(assignments)
+	}))
+
+	// Cluster the spectrograms; k may be omitted to use the optimal K
+	fiberApp.Post("/api/projects/:id/clusters", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			K int `json:"k"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&body); err != nil {
+				return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+			}
+		}
+		if body.K < 0 {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("k must not be negative"))
+		}
+		assignments, err := appLogic.AssignClusters(projectName, body.K)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(assignments)
+	}))
+
+	// Get the dendrogram of the last agglomerative clustering
+	fiberApp.Get("/api/projects/:id/dendrogram", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		dendrogram, err := appLogic.GetClusterDendrogram(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(dendrogram)
+	}))
+
+	// Get the saved 2-D embedding of a project's spectrograms
+	fiberApp.Get("/api/projects/:id/embedding", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		embedding, err := appLogic.GetEmbedding(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(embedding)
+	}))
+
+	// Compute the 2-D embedding of a project's spectrograms
+	fiberApp.Post("/api/projects/:id/embedding", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		embedding, err := appLogic.ComputeEmbedding(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(embedding)
+	}))
+
+	// Get the label tree of a project
+	fiberApp.Get("/api/projects/:id/labels", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		labels, err := appLogic.GetLabels(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"labels": labels})
+	}))
+
+	// Replace the label tree of a project
+	fiberApp.Post("/api/projects/:id/labels", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Labels LabelTaxonomy `json:"labels"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		if err := appLogic.SaveLabels(projectName, body.Labels); err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Labels saved"})
+	}))
+
+	// Add a label path such as "bird/song/alarm"
+	fiberApp.Post("/api/projects/:id/labels/entries", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Label string `json:"label"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		if err := appLogic.AddLabel(projectName, body.Label); err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return sendLabels(c, appLogic, projectName, fiber.StatusCreated, fiber.Map{})
+	}))
+
+	// Delete a label and everything below it
+	fiberApp.Delete("/api/projects/:id/labels/entries", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		if err := appLogic.DeleteLabel(projectName, c.Query("label")); err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return sendLabels(c, appLogic, projectName, fiber.StatusOK, fiber.Map{})
+	}))
+
+	// Rename a label, relabelling its annotations
+	fiberApp.Post("/api/projects/:id/labels/rename", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Label string `json:"label"`
+			Name  string `json:"name"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		relabeled, err := appLogic.RenameLabel(projectName, body.Label, body.Name)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return sendLabels(c, appLogic, projectName, fiber.StatusOK, fiber.Map{"relabeled": relabeled})
+	}))
+
+	// Merge a label into another one, relabelling its annotations
+	fiberApp.Post("/api/projects/:id/labels/merge", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Label string `json:"label"`
+			Into  string `json:"into"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		relabeled, err := appLogic.MergeLabels(projectName, body.Label, body.Into)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return sendLabels(c, appLogic, projectName, fiber.StatusOK, fiber.Map{"relabeled": relabeled})
+	}))
+
+	// List the annotations of a project
+	fiberApp.Get("/api/projects/:id/annotations", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		annotations, err := appLogic.ListAnnotations(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(annotations)
+	}))
+
+	// Annotate a spectrogram, given md5_hash, or a time range of a source file
+	fiberApp.Post("/api/projects/:id/annotations", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Label      string  `json:"label"`
+			Annotator  string  `json:"annotator"`
+			MD5Hash    string  `json:"md5_hash"`
+			SourceFile string  `json:"source_file"`
+			StartTime  float64 `json:"start_time"`
+			EndTime    float64 `json:"end_time"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		var annotation *Annotation
+		var err error
+		if body.MD5Hash != "" {
+			annotation, err = appLogic.AnnotateSpectrogram(projectName, body.MD5Hash, body.Label, body.Annotator)
+		} else {
+			annotation, err = appLogic.AnnotateTimeRange(projectName, body.SourceFile, body.StartTime, body.EndTime, body.Label, body.Annotator)
+		}
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(annotation)
+	}))
+
+	// Resolve the label of every annotated spectrogram
+	fiberApp.Get("/api/projects/:id/annotations/spectrograms", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		labels, err := appLogic.GetSpectrogramLabels(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(labels)
+	}))
+
+	// Export the annotations of a project as JSON or CSV
+	fiberApp.Get("/api/projects/:id/annotations/export", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		format := c.Query("format", annotationFormatJSON)
+		data, err := appLogic.ExportAnnotations(projectName, format)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		contentType := fiber.MIMEApplicationJSON
+		if format == annotationFormatCSV {
+			contentType = "text/csv"
+		}
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Attachment(fmt.Sprintf("%s-annotations.%s", projectName, format))
+		return c.Status(fiber.StatusOK).SendString(data)
+	}))
+
+	// Import exported annotations; the request body is the JSON or CSV file
+	fiberApp.Post("/api/projects/:id/annotations/import", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		imported, err := appLogic.ImportAnnotations(projectName, string(c.Body()), c.Query("format", annotationFormatJSON))
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"imported": imported})
+	}))
+
+	// Delete an annotation
+	fiberApp.Delete("/api/projects/:id/annotations/:annotationId", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		if err := appLogic.DeleteAnnotation(projectName, c.Params("annotationId")); err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}))
+
+	// Label every spectrogram of a cluster of the last clustering run
+	fiberApp.Post("/api/projects/:id/clusters/:cluster/annotations", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		cluster, err := c.ParamsInt("cluster")
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid cluster: %v", err))
+		}
+		var body struct {
+			Label     string `json:"label"`
+			Annotator string `json:"annotator"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		labelled, err := appLogic.AnnotateCluster(projectName, cluster, body.Label, body.Annotator)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusInternalServerError), err)
+		}
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"labelled": labelled})
+	}))
+
+	// Get the last trained classifier
+	fiberApp.Get("/api/projects/:id/classifier", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		model, err := appLogic.GetClassifier(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(model)
+	}))
+
+	// Train a classifier on the labelled spectrograms
+	fiberApp.Post("/api/projects/:id/classifier", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		model, err := appLogic.TrainClassifier(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(model)
+	}))
+
+	// Get the last evaluation report of the classifier
+	fiberApp.Get("/api/projects/:id/evaluation", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		report, err := appLogic.GetEvaluationReport(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(report)
+	}))
+
+	// Evaluate the classifier as the evaluation settings ask
+	fiberApp.Post("/api/projects/:id/evaluation", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		report, err := appLogic.EvaluateClassifier(projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(report)
+	}))
+
+	// Classify uploaded audio files with the saved classifier. The multipart
+	// field "files" holds one or more files; format=csv answers with the
+	// chunks or timeline table instead of JSON
+	fiberApp.Post("/api/projects/:id/predictions", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		format, table := c.Query("format", predictionFormatJSON), c.Query("table", predictionTableChunks)
+		if format != predictionFormatJSON && format != predictionFormatCSV {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("unknown prediction format: %s", format))
+		}
+		if table != predictionTableChunks && table != predictionTableTimeline {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("unknown prediction table: %s", table))
+		}
+		form, err := uploadForm(c, maxUploadSize)
+		if err != nil {
+			return sendError(c, errorStatus(err, fiber.StatusBadRequest), fmt.Errorf("invalid multipart form: %w", err))
+		}
+		defer form.RemoveAll()
+		uploads := form.File["files"]
+		if len(uploads) == 0 {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("no files uploaded"))
+		}
+
+		tempDir, err := os.MkdirTemp("", "neuralforge-upload-*")
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		defer os.RemoveAll(tempDir)
+		var filePaths []string
+		for i, upload := range uploads {
+			name := filepath.Base(upload.Filename)
+			if name == "." || name == ".." || name == string(filepath.Separator) {
+				return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid file name: %s", upload.Filename))
+			}
+			// Keep the uploaded name for the results; each file gets its own
+			// directory in case names repeat
+			filePath := filepath.Join(tempDir, strconv.Itoa(i), name)
+			if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+				return sendError(c, fiber.StatusInternalServerError, err)
+			}
+			if err := c.SaveFile(upload, filePath); err != nil {
+				return sendError(c, fiber.StatusInternalServerError, fmt.Errorf("error saving upload: %v", err))
+			}
+			filePaths = append(filePaths, filePath)
+		}
+
+		result, err := appLogic.ClassifyAudioFiles(projectName, filePaths)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		if format == predictionFormatJSON {
+			return c.Status(fiber.StatusOK).JSON(result)
+		}
+		data, err := result.csv(table)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err)
+		}
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Attachment(fmt.Sprintf("%s-%s.csv", projectName, table))
+		return c.Status(fiber.StatusOK).SendString(data)
+	}))
+
+	// Start a pipeline job for a project
+	fiberApp.Post("/api/projects/:id/jobs", projectHandler(appLogic, func(c *fiber.Ctx, projectName string) error {
+		var body struct {
+			Type string `json:"type"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return sendError(c, fiber.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		}
+		status, err := appLogic.StartJob(body.Type, projectName)
+		if err != nil {
+			return sendError(c, fiber.StatusBadRequest, err)
+		}
+		return c.Status(fiber.StatusAccepted).JSON(status)
+	}))
+}
+
+// setupJobRoutes exposes the background jobs of all projects.
+func setupJobRoutes(fiberApp *fiber.App, appLogic *App) {
+	// List all jobs
+	fiberApp.Get("/api/jobs", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusOK).JSON(appLogic.ListJobs())
+	})
+
+	// Stream job updates as Server-Sent Events
+	fiberApp.Get("/api/jobs/events", func(c *fiber.Ctx) error {
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+
+		updates, unsubscribe := appLogic.jobs.subscribe()
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer unsubscribe()
+			heartbeat := time.NewTicker(15 * time.Second)
+			defer heartbeat.Stop()
+			for {
+				select {
+				case status := <-updates:
+					data, err := json.Marshal(status)
+					if err != nil {
+						continue
+					}
+					fmt.Fprintf(w, "event: %s\ndata: %s\n\n", jobEventName, data)
+				case <-heartbeat.C:
+					fmt.Fprint(w, ": ping\n\n")
+				}
+				// Flush fails once the client has gone away
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	// Get the status of a job
+	fiberApp.Get("/api/jobs/:jobId", func(c *fiber.Ctx) error {
+		status, err := appLogic.GetJob(c.Params("jobId"))
+		if err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.Status(fiber.StatusOK).JSON(status)
+	})
+
+	// Cancel a job
+	fiberApp.Delete("/api/jobs/:jobId", func(c *fiber.Ctx) error {
+		if err := appLogic.CancelJob(c.Params("jobId")); err != nil {
+			return sendError(c, fiber.StatusNotFound, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestFiberApp returns a Fiber app with the error and body handling of
+// server mode.
+func newTestFiberApp() *fiber.App {
+	fiberApp := fiber.New(fiber.Config{
+		ErrorHandler:                 apiErrorHandler,
+		BodyLimit:                    defaultBodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	})
+	fiberApp.Use(limitBody)
+	return fiberApp
+}
+
+// newTestServer serves the REST API of app like server mode does.
+func newTestServer(app *App) *fiber.App {
+	fiberApp := newTestFiberApp()
+	setupRoutes(fiberApp, app)
+	return fiberApp
+}
+
+// doRequest sends a request to fiberApp and returns the status and body of
+// the response.
+func doRequest(t *testing.T, fiberApp *fiber.App, req *http.Request) (int, string) {
+	t.Helper()
+	resp, err := fiberApp.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: %q", errInvalidProjectName, "../x"), fiber.StatusBadRequest},
+		{fmt.Errorf("%w: %q", errOutsideDataRoot, "/etc"), fiber.StatusForbidden},
+		{fmt.Errorf("%w: x", errInvalidLabel), fiber.StatusBadRequest},
+		{fmt.Errorf("%w: x", errInvalidAnnotation), fiber.StatusBadRequest},
+		{fmt.Errorf("%w: x", errProjectNotFound), fiber.StatusNotFound},
+		{fmt.Errorf("%w: x", errLabelNotFound), fiber.StatusNotFound},
+		{fmt.Errorf("%w: x", errProjectExists), fiber.StatusConflict},
+		{fmt.Errorf("%w: x", errProjectBusy), fiber.StatusConflict},
+		{fmt.Errorf("%w: x", errLabelInUse), fiber.StatusConflict},
+		{fmt.Errorf("%w: over 1 bytes", errBodyTooLarge), fiber.StatusRequestEntityTooLarge},
+		{errors.New("disk full"), fiber.StatusTeapot},
+	}
+	for _, tt := range tests {
+		if got := errorStatus(tt.err, fiber.StatusTeapot); got != tt.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestProjectRoutesCheckProjects(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	fiberApp := newTestServer(app)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/projects/birds/settings", fiber.StatusOK},
+		{"/api/projects/frogs/settings", fiber.StatusNotFound},
+		{"/api/projects/../settings", fiber.StatusBadRequest},
+		{"/api/projects/.hidden/settings", fiber.StatusBadRequest},
+		{"/api/projects/nul/settings", fiber.StatusBadRequest},
+		{"/api/projects/birds/clusters", fiber.StatusNotFound},
+	}
+	for _, tt := range tests {
+		status, body := doRequest(t, fiberApp, httptest.NewRequest(fiber.MethodGet, tt.path, nil))
+		if status != tt.want {
+			t.Errorf("GET %s: status %d (%s), want %d", tt.path, status, body, tt.want)
+		}
+		if status != fiber.StatusOK && !strings.Contains(body, `"error"`) {
+			t.Errorf("GET %s: body %s is not a JSON error", tt.path, body)
+		}
+	}
+}
+
+func TestFilesRouteStaysInDataRoot(t *testing.T) {
+	app, _ := newTestProject(t, "birds")
+	dataRoot := t.TempDir()
+	app.workspace.DataRoot = dataRoot
+	if err := os.Mkdir(filepath.Join(dataRoot, "recordings"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.TempDir(), filepath.Join(dataRoot, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	fiberApp := newTestServer(app)
+	tests := []struct {
+		dir  string
+		want int
+	}{
+		{"recordings", fiber.StatusOK},
+		{filepath.Join(dataRoot, "recordings"), fiber.StatusOK},
+		{"..", fiber.StatusForbidden},
+		{"recordings/../..", fiber.StatusForbidden},
+		{"escape", fiber.StatusForbidden},
+		{"missing", fiber.StatusBadRequest},
+		{"", fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		path := "/api/files?dir=" + url.QueryEscape(tt.dir)
+		if status, body := doRequest(t, fiberApp, httptest.NewRequest(fiber.MethodGet, path, nil)); status != tt.want {
+			t.Errorf("dir %q: status %d (%s), want %d", tt.dir, status, body, tt.want)
+		}
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	fiberApp := newTestFiberApp()
+	fiberApp.Post("/echo", func(c *fiber.Ctx) error {
+		return c.Send(c.Body())
+	})
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool
+		want    int
+	}{
+		{"small", 100, false, fiber.StatusOK},
+		{"small chunked", 100, true, fiber.StatusOK},
+		{"at the limit chunked", defaultBodyLimit, true, fiber.StatusOK},
+		{"over the limit", defaultBodyLimit + 1, false, fiber.StatusRequestEntityTooLarge},
+		{"over the limit chunked", defaultBodyLimit + 1, true, fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		body := strings.Repeat("x", tt.size)
+		req := httptest.NewRequest(fiber.MethodPost, "/echo", strings.NewReader(body))
+		if tt.chunked {
+			// A body of unknown length is sent with chunked encoding
+			req.ContentLength = -1
+			req.TransferEncoding = []string{"chunked"}
+		}
+		status, got := doRequest(t, fiberApp, req)
+		if status != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, status, tt.want)
+		}
+		if status == fiber.StatusOK && got != body {
+			t.Errorf("%s: handler read %d bytes, want %d", tt.name, len(got), len(body))
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	_ "image/png"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const batchSize = 200
@@ -20,11 +23,17 @@ type SpectrogramData struct {
 }*/
 
 func (a *App) ConvertFilesToWAV(projectName string) error {
-	homeDir, err := os.UserHomeDir()
+	return a.convertFilesToWAV(context.Background(), projectName, nil)
+}
+
+// convertFilesToWAV copies or converts the project's selected files into the
+// sounds directory. Cancelling ctx stops the remaining files, kills running
+// FFmpeg processes and removes their partial output.
+func (a *App) convertFilesToWAV(ctx context.Context, projectName string, report progressFunc) error {
+	projectDir, err := a.workspace.projectDir(projectName)
 	if err != nil {
 		return err
 	}
-	projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
 	soundsDir := filepath.Join(projectDir, "sounds")
 
 	err = os.MkdirAll(soundsDir, os.ModePerm)
@@ -37,7 +46,19 @@ func (a *App) ConvertFilesToWAV(projectName string) error {
 		return a.LogError(projectName, err, "error getting project data")
 	}
 
+	settings, err := loadPipelineSettings(projectDir)
+	if err != nil {
+		return a.LogError(projectName, err, "error loading pipeline settings")
+	}
+
+	manifest, err := openManifest(projectDir)
+	if err != nil {
+		return a.LogError(projectName, err, "error loading manifest")
+	}
+	defer manifest.save()
+
 	var mutex sync.Mutex
+	var processed int
 	errorList := []error{}
 
 	files := []string{}
@@ -47,7 +68,7 @@ func (a *App) ConvertFilesToWAV(projectName string) error {
 		}
 	}
 
-	for i := 0; i < len(files); i += batchSize {
+	for i := 0; i < len(files) && ctx.Err() == nil; i += batchSize {
 		end := i + batchSize
 		if end > len(files) {
 			end = len(files)
@@ -59,23 +80,18 @@ func (a *App) ConvertFilesToWAV(projectName string) error {
 		for _, file := range files[i:end] {
 			go func(file string) {
 				defer wg.Done()
-				sourceFilePath := filepath.Join(projectData.SelectedDirectory, file)
-				// Use only the base file name for the target file path
-				targetFileName := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".wav"
-				targetFilePath := filepath.Join(soundsDir, targetFileName)
-
-				if _, err := os.Stat(targetFilePath); err == nil {
-					a.LogError(projectName, nil, fmt.Sprintf("WAV file already exists: %s", targetFilePath))
+				if ctx.Err() != nil {
 					return
 				}
-
-				if strings.ToLower(filepath.Ext(file)) == ".wav" {
-					err = copyFile(sourceFilePath, targetFilePath)
-				} else {
-					err = convertToWAV(sourceFilePath, targetFilePath)
-				}
-
-				if err != nil {
+				defer func() {
+					mutex.Lock()
+					processed++
+					report.update(processed, len(files))
+					mutex.Unlock()
+				}()
+				sourceFilePath := filepath.Join(projectData.SelectedDirectory, file)
+				err := convertSourceFile(ctx, manifest, projectDir, sourceFilePath, file, settings.Conversion)
+				if err != nil && ctx.Err() == nil {
 					mutex.Lock()
 					errorList = append(errorList, a.LogError(projectName, err, fmt.Sprintf("error processing file: %s", sourceFilePath)))
 					mutex.Unlock()
@@ -85,6 +101,10 @@ func (a *App) ConvertFilesToWAV(projectName string) error {
 		wg.Wait()
 	}
 
+	if ctx.Err() != nil {
+		fmt.Println("File conversion cancelled.")
+		return ctx.Err()
+	}
 	if len(errorList) > 0 {
 		return fmt.Errorf("errors occurred during file conversion")
 	}
@@ -93,6 +113,60 @@ func (a *App) ConvertFilesToWAV(projectName string) error {
 	return nil
 }
 
+// convertSourceFile copies or converts one selected file into the sounds
+// directory, unless the manifest shows that it was already converted from
+// identical content with the same settings. Output is written to a temporary
+// file first, so an interrupted run never leaves a truncated WAV behind.
+func convertSourceFile(ctx context.Context, manifest *manifestStore, projectDir, sourceFilePath, file string, cfg ConversionConfig) error {
+	// Use only the base file name for the target file path
+	targetFileName := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)) + ".wav"
+	targetFilePath := filepath.Join(projectDir, "sounds", targetFileName)
+	key := manifestKey(projectDir, targetFilePath)
+
+	entry, known := manifest.lookup(key)
+	if known && entry.Source != nil && entry.Source.Path != file {
+		return fmt.Errorf("WAV file already exists for %s: %s", entry.Source.Path, targetFilePath)
+	}
+
+	source, changed, err := fingerprintFile(sourceFilePath, file, entry.Source)
+	if err != nil {
+		return err
+	}
+	recordSource := func(f *ManifestFile) {
+		f.Source = &source
+		f.Stages[stageConverted] = StageRecord{ParamsHash: cfg.paramsHash(), CompletedAt: time.Now()}
+	}
+
+	_, statErr := os.Stat(targetFilePath)
+	switch {
+	case known && entry.stageDone(stageConverted, cfg.paramsHash()) && !changed:
+		// Already converted; the WAV itself may be gone under the delete
+		// retention policy once its spectrograms exist.
+		return manifest.update(key, func(f *ManifestFile) { f.Source = &source })
+	case (!known || entry.Source == nil) && statErr == nil:
+		// Converted before the manifest existed, or by a run that stopped
+		// before saving it.
+		fmt.Printf("Recording existing WAV file: %s\n", targetFilePath)
+		return manifest.update(key, recordSource)
+	}
+
+	tempFilePath := filepath.Join(projectDir, "sounds", "."+targetFileName+".tmp")
+	if strings.ToLower(filepath.Ext(file)) == ".wav" && cfg.SampleRate == 0 {
+		err = copyFile(sourceFilePath, tempFilePath)
+	} else {
+		err = convertToWAV(ctx, sourceFilePath, tempFilePath, cfg.SampleRate)
+	}
+	if err == nil {
+		err = os.Rename(tempFilePath, targetFilePath)
+	}
+	if err != nil {
+		os.Remove(tempFilePath)
+		return err
+	}
+
+	return manifest.update(key, recordSource)
+}
+
 func copyFile(src, dst string) error {
 	input, err := os.ReadFile(src)
 	if err != nil {
@@ -107,8 +181,14 @@ func copyFile(src, dst string) error {
 	return nil
 }
 
-func convertToWAV(src, dst string) error {
-	cmd := exec.Command("ffmpeg", "-i", src, dst)
+// convertToWAV converts src with FFmpeg, resampling it to sampleRate unless
+// that is 0.
+func convertToWAV(ctx context.Context, src, dst string, sampleRate int) error {
+	args := []string{"-y", "-i", src}
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, "-f", "wav", dst)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		fmt.Printf("Error converting file to WAV: %v. Output: %s\n", err, string(output))
@@ -118,8 +198,12 @@ func convertToWAV(src, dst string) error {
 }
 
 func (a *App) LogError(projectName string, err error, message string) error {
-	homeDir, _ := os.UserHomeDir()
-	projectDir := filepath.Join(homeDir, "NeuralForge", "projects", projectName)
+	projectDir, errFile := a.workspace.projectDir(projectName)
+	if errFile != nil {
+		// Nothing can be logged, but the caller still gets its own error back
+		fmt.Printf("Failed to open log file: %v\n", errFile)
+		return err
+	}
 	logFilePath := filepath.Join(projectDir, "log.error")
 
 	f, errFile := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"gonum.org/v1/gonum/dsp/fourier"
+)
+
+// STFTConfig controls how spectrograms are computed from audio samples.
+// Sizes are expressed in samples.
+type STFTConfig struct {
+	WindowSize     int     `json:"window_size"`
+	HopSize        int     `json:"hop_size"`
+	FFTSize        int     `json:"fft_size"`
+	WindowFunction string  `json:"window_function"` // hann, hamming, blackman or rectangular
+	MinDB          float64 `json:"min_db"`          // Floor applied to magnitudes in dB
+}
+
+func defaultSTFTConfig() STFTConfig {
+	return STFTConfig{
+		WindowSize:     1024,
+		HopSize:        512,
+		FFTSize:        1024,
+		WindowFunction: "hann",
+		MinDB:          -120,
+	}
+}
+
+func (cfg STFTConfig) validate() error {
+	if cfg.WindowSize <= 0 {
+		return fmt.Errorf("window size must be positive")
+	}
+	if cfg.HopSize <= 0 {
+		return fmt.Errorf("hop size must be positive")
+	}
+	if cfg.FFTSize < cfg.WindowSize {
+		return fmt.Errorf("FFT size (%d) must be at least the window size (%d)", cfg.FFTSize, cfg.WindowSize)
+	}
+	if _, err := windowFunction(cfg.WindowFunction, cfg.WindowSize); err != nil {
+		return err
+	}
+	return nil
+}
+
+// NumBins returns the number of frequency bins produced per frame.
+func (cfg STFTConfig) NumBins() int {
+	return cfg.FFTSize/2 + 1
+}
+
+func windowFunction(name string, size int) ([]float64, error) {
+	window := make([]float64, size)
+	if size == 1 {
+		window[0] = 1
+		return window, nil
+	}
+	n := float64(size - 1)
+	for i := range window {
+		x := 2 * math.Pi * float64(i) / n
+		switch name {
+		case "hann", "":
+			window[i] = 0.5 - 0.5*math.Cos(x)
+		case "hamming":
+			window[i] = 0.54 - 0.46*math.Cos(x)
+		case "blackman":
+			window[i] = 0.42 - 0.5*math.Cos(x) + 0.08*math.Cos(2*x)
+		case "rectangular":
+			window[i] = 1
+		default:
+			return nil, fmt.Errorf("unknown window function: %s", name)
+		}
+	}
+	return window, nil
+}
+
+// computeSTFT returns the linear magnitude spectrogram of the samples as
+// [frame][bin]. Magnitudes are scaled so that a full-scale sine wave peaks
+// at roughly 1.0 regardless of window choice. Signals shorter than one
+// window are zero padded into a single frame.
+func computeSTFT(samples []float64, cfg STFTConfig) ([][]float64, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	window, _ := windowFunction(cfg.WindowFunction, cfg.WindowSize)
+
+	var windowSum float64
+	for _, w := range window {
+		windowSum += w
+	}
+	scale := 2 / windowSum
+
+	numFrames := 1
+	if len(samples) > cfg.WindowSize {
+		numFrames = 1 + (len(samples)-cfg.WindowSize+cfg.HopSize-1)/cfg.HopSize
+	}
+
+	fft := fourier.NewFFT(cfg.FFTSize)
+	frame := make([]float64, cfg.FFTSize)
+	coeffs := make([]complex128, cfg.NumBins())
+	spectrogram := make([][]float64, numFrames)
+
+	for f := 0; f < numFrames; f++ {
+		start := f * cfg.HopSize
+		for i := range frame {
+			frame[i] = 0
+		}
+		for i := 0; i < cfg.WindowSize && start+i < len(samples); i++ {
+			frame[i] = samples[start+i] * window[i]
+		}
+
+		coeffs = fft.Coefficients(coeffs, frame)
+		row := make([]float64, len(coeffs))
+		for i, c := range coeffs {
+			row[i] = cmplx.Abs(c) * scale
+		}
+		spectrogram[f] = row
+	}
+
+	return spectrogram, nil
+}
+
+// magnitudesToDB converts linear magnitudes to decibels, clamping at minDB.
+func magnitudesToDB(magnitudes [][]float64, minDB float64) [][]float64 {
+	floor := math.Pow(10, minDB/20)
+	db := make([][]float64, len(magnitudes))
+	for f, row := range magnitudes {
+		out := make([]float64, len(row))
+		for i, m := range row {
+			if m < floor {
+				m = floor
+			}
+			out[i] = 20 * math.Log10(m)
+		}
+		db[f] = out
+	}
+	return db
+}
+
+// dbToMagnitudes inverts magnitudesToDB; values at the floor stay at the floor.
+func dbToMagnitudes(db [][]float64) [][]float64 {
+	magnitudes := make([][]float64, len(db))
+	for f, row := range db {
+		out := make([]float64, len(row))
+		for i, v := range row {
+			out[i] = math.Pow(10, v/20)
+		}
+		magnitudes[f] = out
+	}
+	return magnitudes
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComputeSTFTSinePeak(t *testing.T) {
+	const sampleRate, frequency = 8000, 1000
+	cfg := STFTConfig{WindowSize: 256, HopSize: 128, FFTSize: 256, WindowFunction: "hann", MinDB: -120}
+	samples := make([]float64, 2048)
+	for i := range samples {
+		samples[i] = math.Sin(2 * math.Pi * frequency * float64(i) / sampleRate)
+	}
+
+	spectrogram, err := computeSTFT(samples, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 1 + (len(samples)-cfg.WindowSize+cfg.HopSize-1)/cfg.HopSize; len(spectrogram) != want {
+		t.Fatalf("%d frames, want %d", len(spectrogram), want)
+	}
+	peakBin := frequency * cfg.FFTSize / sampleRate
+	for f, row := range spectrogram {
+		if len(row) != cfg.NumBins() {
+			t.Fatalf("frame %d has %d bins, want %d", f, len(row), cfg.NumBins())
+		}
+		best := 0
+		for i := range row {
+			if row[i] > row[best] {
+				best = i
+			}
+		}
+		if best != peakBin || math.Abs(row[best]-1) > 0.01 {
+			t.Errorf("frame %d peaks at bin %d with %g, want bin %d with 1", f, best, row[best], peakBin)
+		}
+	}
+}
+
+func TestComputeSTFTFrameCount(t *testing.T) {
+	cfg := STFTConfig{WindowSize: 4, HopSize: 2, FFTSize: 8, WindowFunction: "rectangular"}
+	tests := []struct {
+		samples int
+		frames  int
+	}{
+		{0, 1},
+		{3, 1},
+		{4, 1},
+		{5, 2},
+		{6, 2},
+		{7, 3},
+	}
+	for _, tt := range tests {
+		spectrogram, err := computeSTFT(make([]float64, tt.samples), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spectrogram) != tt.frames {
+			t.Errorf("%d samples: %d frames, want %d", tt.samples, len(spectrogram), tt.frames)
+		}
+	}
+}
+
+func TestSTFTConfigValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   STFTConfig
+		valid bool
+	}{
+		{"default", defaultSTFTConfig(), true},
+		{"zero padded FFT", STFTConfig{WindowSize: 100, HopSize: 50, FFTSize: 128, WindowFunction: "blackman"}, true},
+		{"zero window", STFTConfig{WindowSize: 0, HopSize: 1, FFTSize: 8}, false},
+		{"zero hop", STFTConfig{WindowSize: 8, HopSize: 0, FFTSize: 8}, false},
+		{"FFT shorter than window", STFTConfig{WindowSize: 16, HopSize: 8, FFTSize: 8}, false},
+		{"unknown window", STFTConfig{WindowSize: 8, HopSize: 4, FFTSize: 8, WindowFunction: "kaiser"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: validate returned %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestMagnitudesToDB(t *testing.T) {
+	db := magnitudesToDB([][]float64{{1, 0.1, 0, 1e-9}}, -120)
+	want := []float64{0, -20, -120, -120}
+	for i, v := range db[0] {
+		if math.Abs(v-want[i]) > 1e-9 {
+			t.Errorf("bin %d: got %g dB, want %g", i, v, want[i])
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+)
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatIEEEFloat  = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+var errUnsupportedWAV = errors.New("unsupported WAV encoding")
+
+// WAVInfo describes the sample layout of a PCM WAV file.
+type WAVInfo struct {
+	SampleRate    int `json:"sample_rate"`
+	Channels      int `json:"channels"`
+	BitsPerSample int `json:"bits_per_sample"`
+	Format        int `json:"format"`
+	NumFrames     int `json:"num_frames"`
+}
+
+// Duration returns the length of the audio in seconds.
+func (info WAVInfo) Duration() float64 {
+	if info.SampleRate == 0 {
+		return 0
+	}
+	return float64(info.NumFrames) / float64(info.SampleRate)
+}
+
+// wavReader streams mono float64 samples in [-1, 1] out of a PCM or IEEE
+// float WAV file. Multi-channel audio is mixed down by averaging channels.
+type wavReader struct {
+	file       *os.File
+	reader     *bufio.Reader
+	info       WAVInfo
+	dataOffset int64
+	frameSize  int
+	position   int
+	buf        []byte
+}
+
+func openWAV(path string) (*wavReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, dataOffset, err := readWAVHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading WAV header of %s: %w", path, err)
+	}
+
+	r := &wavReader{
+		file:       file,
+		info:       info,
+		dataOffset: dataOffset,
+		frameSize:  info.Channels * info.BitsPerSample / 8,
+	}
+	if err := r.Seek(0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *wavReader) Info() WAVInfo {
+	return r.info
+}
+
+func (r *wavReader) Close() error {
+	return r.file.Close()
+}
+
+// Seek positions the reader at the given frame index.
+func (r *wavReader) Seek(frame int) error {
+	if frame < 0 {
+		frame = 0
+	}
+	if frame > r.info.NumFrames {
+		frame = r.info.NumFrames
+	}
+	if _, err := r.file.Seek(r.dataOffset+int64(frame)*int64(r.frameSize), io.SeekStart); err != nil {
+		return err
+	}
+	r.reader = bufio.NewReaderSize(r.file, 1<<16)
+	r.position = frame
+	return nil
+}
+
+// Read fills dst with mono samples and returns the number of frames read.
+// It returns io.EOF once the data chunk is exhausted.
+func (r *wavReader) Read(dst []float64) (int, error) {
+	remaining := r.info.NumFrames - r.position
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	n := len(dst)
+	if n > remaining {
+		n = remaining
+	}
+
+	need := n * r.frameSize
+	if cap(r.buf) < need {
+		r.buf = make([]byte, need)
+	}
+	buf := r.buf[:need]
+	read, err := io.ReadFull(r.reader, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	n = read / r.frameSize
+
+	bytesPerSample := r.info.BitsPerSample / 8
+	for i := 0; i < n; i++ {
+		var sum float64
+		frame := buf[i*r.frameSize : (i+1)*r.frameSize]
+		for ch := 0; ch < r.info.Channels; ch++ {
+			sum += decodeSample(frame[ch*bytesPerSample:(ch+1)*bytesPerSample], r.info.Format, r.info.BitsPerSample)
+		}
+		dst[i] = sum / float64(r.info.Channels)
+	}
+	r.position += n
+
+	if n == 0 {
+		// The header promised more data than the file holds.
+		r.info.NumFrames = r.position
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// ReadRange returns the mono samples between the start and end frame.
+func (r *wavReader) ReadRange(start, end int) ([]float64, error) {
+	if end > r.info.NumFrames {
+		end = r.info.NumFrames
+	}
+	if start >= end {
+		return []float64{}, nil
+	}
+	if err := r.Seek(start); err != nil {
+		return nil, err
+	}
+
+	samples := make([]float64, end-start)
+	filled := 0
+	for filled < len(samples) {
+		n, err := r.Read(samples[filled:])
+		filled += n
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return samples[:filled], nil
+}
+
+func decodeSample(b []byte, format, bits int) float64 {
+	switch format {
+	case wavFormatIEEEFloat:
+		if bits == 64 {
+			return math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	default:
+		switch bits {
+		case 8:
+			return (float64(b[0]) - 128) / 128
+		case 16:
+			return float64(int16(binary.LittleEndian.Uint16(b))) / 32768
+		case 24:
+			v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+			return float64(v) / 8388608
+		default:
+			return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648
+		}
+	}
+}
+
+// readWAVHeader walks the RIFF chunks up to the data chunk and returns the
+// format description together with the byte offset of the sample data.
+func readWAVHeader(file *os.File) (WAVInfo, int64, error) {
+	var info WAVInfo
+
+	stat, err := file.Stat()
+	if err != nil {
+		return info, 0, err
+	}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return info, 0, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return info, 0, fmt.Errorf("%w: not a RIFF/WAVE file", errUnsupportedWAV)
+	}
+
+	offset := int64(12)
+	haveFormat := false
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(file, chunkHeader); err != nil {
+			return info, 0, fmt.Errorf("data chunk not found: %v", err)
+		}
+		offset += 8
+		id := string(chunkHeader[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+
+		switch id {
+		case "fmt ":
+			body := make([]byte, size)
+			if _, err := io.ReadFull(file, body); err != nil {
+				return info, 0, err
+			}
+			if size < 16 {
+				return info, 0, fmt.Errorf("fmt chunk too short")
+			}
+			info.Format = int(binary.LittleEndian.Uint16(body[0:2]))
+			info.Channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(body[4:8]))
+			info.BitsPerSample = int(binary.LittleEndian.Uint16(body[14:16]))
+			if info.Format == wavFormatExtensible && size >= 26 {
+				info.Format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+			haveFormat = true
+		case "data":
+			if !haveFormat {
+				return info, 0, fmt.Errorf("data chunk before fmt chunk")
+			}
+			if err := validateWAVFormat(info); err != nil {
+				return info, 0, err
+			}
+			// Streamed WAVs (e.g. from a pipe) carry a bogus size, so clamp
+			// it to what is actually on disk.
+			if available := stat.Size() - offset; size > available || size == 0xFFFFFFFF {
+				size = available
+			}
+			info.NumFrames = int(size / int64(info.Channels*info.BitsPerSample/8))
+			return info, offset, nil
+		default:
+			if _, err := file.Seek(size, io.SeekCurrent); err != nil {
+				return info, 0, err
+			}
+		}
+
+		offset += size
+		if size%2 == 1 {
+			// RIFF chunks are word aligned.
+			if _, err := file.Seek(1, io.SeekCurrent); err != nil {
+				return info, 0, err
+			}
+			offset++
+		}
+	}
+}
+
+func validateWAVFormat(info WAVInfo) error {
+	if info.Channels <= 0 || info.SampleRate <= 0 {
+		return fmt.Errorf("%w: invalid channel count or sample rate", errUnsupportedWAV)
+	}
+	switch info.Format {
+	case wavFormatPCM:
+		switch info.BitsPerSample {
+		case 8, 16, 24, 32:
+			return nil
+		}
+	case wavFormatIEEEFloat:
+		switch info.BitsPerSample {
+		case 32, 64:
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: format %d with %d bits per sample", errUnsupportedWAV, info.Format, info.BitsPerSample)
+}
+
+// openAudio opens a WAV file natively and falls back to transcoding it with
+// FFmpeg into a temporary 16-bit PCM file when the encoding is not PCM. The
+// returned cleanup function must be called once the reader is closed.
+// Cancelling ctx kills a running FFmpeg process.
+func openAudio(ctx context.Context, path string) (*wavReader, func(), error) {
+	r, err := openWAV(path)
+	if err == nil {
+		return r, func() {}, nil
+	}
+	if !errors.Is(err, errUnsupportedWAV) {
+		return nil, nil, err
+	}
+
+	tempFile, err := os.CreateTemp("", "neuralforge-pcm-*.wav")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating temp file: %v", err)
+	}
+	tempFile.Close()
+	cleanup := func() { os.Remove(tempFile.Name()) }
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", path, "-acodec", "pcm_s16le", tempFile.Name())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		fmt.Printf("FFmpeg error output:\n%s\n", string(output))
+		return nil, nil, fmt.Errorf("error transcoding %s to PCM with FFmpeg: %v", path, err)
+	}
+
+	r, err = openWAV(tempFile.Name())
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return r, cleanup, nil
+}
+
+// decodeWAVFile reads an entire audio file into memory as mono samples.
+func decodeWAVFile(path string) ([]float64, WAVInfo, error) {
+	r, cleanup, err := openAudio(context.Background(), path)
+	if err != nil {
+		return nil, WAVInfo{}, err
+	}
+	defer cleanup()
+	defer r.Close()
+
+	samples, err := r.ReadRange(0, r.Info().NumFrames)
+	if err != nil {
+		return nil, WAVInfo{}, err
+	}
+	return samples, r.Info(), nil
+}
+
+// encodeWAV encodes mono samples in [-1, 1] as a 16-bit PCM WAV file.
+func encodeWAV(samples []float64, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(36+dataSize))
+	copy(buf[8:12], "WAVE")
+	copy(buf[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:20], 16)
+	binary.LittleEndian.PutUint16(buf[20:22], wavFormatPCM)
+	binary.LittleEndian.PutUint16(buf[22:24], 1)
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(buf[32:34], 2)
+	binary.LittleEndian.PutUint16(buf[34:36], 16)
+	copy(buf[36:40], "data")
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataSize))
+
+	for i, s := range samples {
+		s = math.Max(-1, math.Min(1, s))
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(int16(math.Round(s*32767))))
+	}
+	return buf
+}
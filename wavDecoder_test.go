@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildWAV assembles a WAV file from a format description and raw sample
+// data. An odd-sized LIST chunk before the data checks that unknown chunks are
+// skipped with their padding byte.
+func buildWAV(format, channels, sampleRate, bits int, data []byte) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], uint16(format))
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(channels))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(channels*bits/8))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], uint16(bits))
+
+	var body []byte
+	chunk := func(id string, payload []byte) {
+		header := make([]byte, 8)
+		copy(header, id)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+		body = append(body, header...)
+		body = append(body, payload...)
+		if len(payload)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+	chunk("fmt ", fmtChunk)
+	chunk("LIST", []byte("odd"))
+	chunk("data", data)
+
+	header := make([]byte, 12)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+len(body)))
+	copy(header[8:], "WAVE")
+	return append(header, body...)
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenWAVDecodesSamples(t *testing.T) {
+	float32Bytes := func(values ...float32) []byte {
+		b := make([]byte, 4*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+		}
+		return b
+	}
+	float64Bytes := func(values ...float64) []byte {
+		b := make([]byte, 8*len(values))
+		for i, v := range values {
+			binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		format   int
+		channels int
+		bits     int
+		data     []byte
+		want     []float64
+	}{
+		{"8-bit PCM", wavFormatPCM, 1, 8, []byte{128, 192, 0}, []float64{0, 0.5, -1}},
+		{"16-bit PCM", wavFormatPCM, 1, 16, []byte{0x00, 0x40, 0x00, 0x80, 0xff, 0x7f}, []float64{0.5, -1, 32767.0 / 32768}},
+		{"24-bit PCM", wavFormatPCM, 1, 24, []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xc0}, []float64{0.5, -0.5}},
+		{"32-bit PCM", wavFormatPCM, 1, 32, []byte{0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00, 0x80}, []float64{0.5, -1}},
+		{"32-bit float", wavFormatIEEEFloat, 1, 32, float32Bytes(0.25, -0.75), []float64{0.25, -0.75}},
+		{"64-bit float", wavFormatIEEEFloat, 1, 64, float64Bytes(0.125, -1), []float64{0.125, -1}},
+		{"stereo mixed down", wavFormatPCM, 2, 16, []byte{0x00, 0x40, 0x00, 0x00, 0x00, 0xc0, 0x00, 0xc0}, []float64{0.25, -0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "test.wav", buildWAV(tt.format, tt.channels, 8000, tt.bits, tt.data))
+			r, err := openWAV(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			info := r.Info()
+			if info.SampleRate != 8000 || info.Channels != tt.channels || info.NumFrames != len(tt.want) {
+				t.Fatalf("info %+v, want 8000 Hz, %d channels, %d frames", info, tt.channels, len(tt.want))
+			}
+			samples, err := r.ReadRange(0, info.NumFrames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != len(tt.want) {
+				t.Fatalf("%d samples, want %d", len(samples), len(tt.want))
+			}
+			for i := range samples {
+				if math.Abs(samples[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("sample %d: got %g, want %g", i, samples[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenWAVRejectsUnsupportedEncodings(t *testing.T) {
+	tests := []struct {
+		name   string
+		format int
+		bits   int
+	}{
+		{"A-law", 6, 8},
+		{"12-bit PCM", wavFormatPCM, 12},
+		{"16-bit float", wavFormatIEEEFloat, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, "test.wav", buildWAV(tt.format, 1, 8000, tt.bits, make([]byte, 8)))
+			if _, err := openWAV(path); !errors.Is(err, errUnsupportedWAV) {
+				t.Errorf("got error %v, want errUnsupportedWAV", err)
+			}
+		})
+	}
+}
+
+func TestEncodeWAVRoundTrip(t *testing.T) {
+	samples := []float64{0, 0.5, -0.5, 1, -1}
+	path := writeTestFile(t, "test.wav", encodeWAV(samples, 16000))
+	decoded, info, err := decodeWAVFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SampleRate != 16000 || len(decoded) != len(samples) {
+		t.Fatalf("%d samples at %d Hz, want %d at 16000 Hz", len(decoded), info.SampleRate, len(samples))
+	}
+	for i := range samples {
+		if math.Abs(decoded[i]-samples[i]) > 1.0/32767 {
+			t.Errorf("sample %d: got %g, want %g", i, decoded[i], samples[i])
+		}
+	}
+}
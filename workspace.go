@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// workspaceEnv names the environment variable that sets the workspace root.
+// A .env file may set it as well.
+const workspaceEnv = "NEURALFORGE_HOME"
+
+// dataRootEnv names the environment variable that sets the directory the
+// server lets clients browse and select source directories in. It defaults
+// to the workspace root.
+const dataRootEnv = "NEURALFORGE_DATA_ROOT"
+
+// errOutsideDataRoot is returned, wrapped with the path, for directories the
+// server does not expose.
+var errOutsideDataRoot = errors.New("directory is outside of the data root")
+
+// Workspace is the directory NeuralForge keeps its projects in. Each server
+// or desktop instance has one; several instances can share a machine with
+// different workspaces.
+type Workspace struct {
+	Root     string
+	DataRoot string // Source directories clients of the server may use
+}
+
+// resolveWorkspace picks the workspace root from flagRoot, the value of the
+// -home command line flag, then from NEURALFORGE_HOME, and falls back to
+// ~/NeuralForge.
+func resolveWorkspace(flagRoot string) (*Workspace, error) {
+	root := flagRoot
+	if root == "" {
+		root = os.Getenv(workspaceEnv)
+	}
+	if root == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		root = filepath.Join(homeDir, "NeuralForge")
+	}
+	workspace, err := newWorkspace(root)
+	if err != nil {
+		return nil, err
+	}
+	if dataRoot := os.Getenv(dataRootEnv); dataRoot != "" {
+		data, err := newWorkspace(dataRoot)
+		if err != nil {
+			return nil, err
+		}
+		workspace.DataRoot = data.Root
+	}
+	return workspace, nil
+}
+
+// newWorkspace returns the workspace at root, expanding a leading ~ and
+// making the path absolute.
+func newWorkspace(root string) (*Workspace, error) {
+	if root == "~" || strings.HasPrefix(root, "~/") || strings.HasPrefix(root, `~\`) {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		root = filepath.Join(homeDir, root[1:])
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workspace %q: %v", root, err)
+	}
+	return &Workspace{Root: absRoot, DataRoot: absRoot}, nil
+}
+
+// ensure creates the workspace and its projects directory.
+func (w *Workspace) ensure() error {
+	return os.MkdirAll(w.projectsDir(), os.ModePerm)
+}
+
+func (w *Workspace) projectsDir() string {
+	return filepath.Join(w.Root, "projects")
+}
+
+// projectDir returns the folder of a project, rejecting names that would
+// resolve outside of the projects directory.
+func (w *Workspace) projectDir(projectName string) (string, error) {
+	if err := checkProjectName(projectName); err != nil {
+		return "", err
+	}
+	return filepath.Join(w.projectsDir(), projectName), nil
+}
+
+// dataPath resolves a directory given by a client of the server, relative
+// paths against the data root, and rejects it unless it lies inside the data
+// root once symbolic links are followed.
+func (w *Workspace) dataPath(dir string) (string, error) {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(w.DataRoot, dir)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(dir))
+	if err != nil {
+		return "", fmt.Errorf("not a directory on the server: %q", dir)
+	}
+	root, err := filepath.EvalSymlinks(w.DataRoot)
+	if err != nil {
+		return "", fmt.Errorf("data root is not accessible: %v", err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %q", errOutsideDataRoot, dir)
+	}
+	return resolved, nil
+}
+
+// parseWorkspaceFlag strips a leading -home flag from the command line
+// arguments and returns its value along with the remaining arguments.
+func parseWorkspaceFlag(args []string) (string, []string, error) {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return "", args, nil
+	}
+	name, value, hasValue := strings.Cut(strings.TrimPrefix(args[0], "-"), "=")
+	if name != "-home" && name != "home" {
+		return "", args, nil
+	}
+	if !hasValue {
+		if len(args) < 2 {
+			return "", nil, fmt.Errorf("flag needs an argument: -home")
+		}
+		value, args = args[1], args[1:]
+	}
+	if value == "" {
+		return "", nil, fmt.Errorf("flag -home must not be empty")
+	}
+	return value, args[1:], nil
+}
+
+// GetWorkspace returns the root directory of the workspace this instance
+// keeps its projects in.
+func (a *App) GetWorkspace() string {
+	return a.workspace.Root
+}
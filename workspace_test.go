@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseWorkspaceFlag(t *testing.T) {
+	tests := []struct {
+		args     []string
+		root     string
+		rest     []string
+		wantsErr bool
+	}{
+		{nil, "", nil, false},
+		{[]string{"help"}, "", []string{"help"}, false},
+		{[]string{"-home", "/data", "project", "list"}, "/data", []string{"project", "list"}, false},
+		{[]string{"--home=/data"}, "/data", []string{}, false},
+		{[]string{"-home=/data", "help"}, "/data", []string{"help"}, false},
+		{[]string{"-verbose", "help"}, "", []string{"-verbose", "help"}, false},
+		{[]string{"-home"}, "", nil, true},
+		{[]string{"-home="}, "", nil, true},
+	}
+	for _, tt := range tests {
+		root, rest, err := parseWorkspaceFlag(tt.args)
+		if (err != nil) != tt.wantsErr {
+			t.Errorf("parseWorkspaceFlag(%q) returned error %v", tt.args, err)
+			continue
+		}
+		if root != tt.root || (len(rest) != 0 || len(tt.rest) != 0) && !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("parseWorkspaceFlag(%q) = %q, %q; want %q, %q", tt.args, root, rest, tt.root, tt.rest)
+		}
+	}
+}
+
+func TestResolveWorkspace(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(workspaceEnv, "")
+	t.Setenv(dataRootEnv, "")
+	tests := []struct {
+		name     string
+		flag     string
+		env      string
+		dataRoot string
+		want     string
+		wantData string
+	}{
+		{"default", "", "", "", filepath.Join(home, "NeuralForge"), filepath.Join(home, "NeuralForge")},
+		{"environment", "", "/srv/neuralforge", "", "/srv/neuralforge", "/srv/neuralforge"},
+		{"flag over environment", "~/projects", "/srv/neuralforge", "", filepath.Join(home, "projects"), filepath.Join(home, "projects")},
+		{"data root", "/srv/neuralforge", "", "/srv/recordings", "/srv/neuralforge", "/srv/recordings"},
+	}
+	for _, tt := range tests {
+		t.Setenv(workspaceEnv, tt.env)
+		t.Setenv(dataRootEnv, tt.dataRoot)
+		workspace, err := resolveWorkspace(tt.flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if workspace.Root != tt.want || workspace.DataRoot != tt.wantData {
+			t.Errorf("%s: root %q and data root %q, want %q and %q", tt.name, workspace.Root, workspace.DataRoot, tt.want, tt.wantData)
+		}
+	}
+}
+
+func TestWorkspacesAreSeparate(t *testing.T) {
+	first, _ := newTestProject(t, "birds")
+	second, _ := newTestProject(t, "frogs")
+	for app, want := range map[*App]string{first: "birds", second: "frogs"} {
+		projects, err := app.ListProjects()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(projects, []string{want}) {
+			t.Errorf("workspace %s lists %v, want [%s]", app.GetWorkspace(), projects, want)
+		}
+	}
+}
+
+func TestDataPath(t *testing.T) {
+	root := t.TempDir()
+	workspace, err := newWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "recordings", "night"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dir     string
+		want    string
+		wantErr error
+	}{
+		{"recordings", filepath.Join(root, "recordings"), nil},
+		{"recordings/night/..", filepath.Join(root, "recordings"), nil},
+		{filepath.Join(root, "recordings", "night"), filepath.Join(root, "recordings", "night"), nil},
+		{".", root, nil},
+		{"..", "", errOutsideDataRoot},
+		{outside, "", errOutsideDataRoot},
+		{"link", "", errOutsideDataRoot},
+		{"missing", "", nil},
+	}
+	for _, tt := range tests {
+		got, err := workspace.dataPath(tt.dir)
+		switch {
+		case tt.want != "":
+			want, _ := filepath.EvalSymlinks(tt.want)
+			if err != nil || got != want {
+				t.Errorf("dataPath(%q) = %q, %v; want %q", tt.dir, got, err, want)
+			}
+		case tt.wantErr != nil:
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("dataPath(%q) returned error %v, want %v", tt.dir, err, tt.wantErr)
+			}
+		case err == nil:
+			t.Errorf("dataPath(%q) = %q, want an error", tt.dir, got)
+		}
+	}
+}